@@ -1,32 +1,261 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/openshift/local-storage-operator/pkg/diskmaker"
+	"github.com/openshift/local-storage-operator/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 var (
 	configLocation  string
+	nodeName        string
+	nodeNameFile    string
+	configURL       string
+	configPaths     []string
+	configAuthFile  string
 	symlinkLocation string
+	protectSwap     bool
+	excludeOpen     bool
+	hostMountInfo   bool
+	rescanSCSI      bool
+	protectedPaths  []string
+	excludeStacked  bool
+	excludeUdev     string
+	gcInterval      time.Duration
+	danglingGrace   time.Duration
+	triggerDebounce time.Duration
+	reconcileLimit  time.Duration
+	filterCommand   string
+	filterTimeout   time.Duration
+	allowlistPath   string
+	dirUID          int
+	dirGID          int
+	dirMode         uint32
+	httpAddress     string
+	socketPath      string
+	lsblkPath       string
+	fstabPath       string
+	lsblkArgs       []string
+	shadowLinks     string
+	shadowReport    string
+	resolveWorkers  int
+	xattrTags       bool
+	sizeHints       bool
+	metricsOnly     bool
+	leasePath       string
+	watchSymlinks   bool
+	statusResource  string
+	statusNamespace string
+	nodeLabelsFile  string
+	nodeLabelsAPI   bool
+	jsonOutput      bool
 )
 
 func init() {
 	flag.StringVar(&configLocation, "config", "/etc/local-storage-operator/config/diskMakerConfig", "location where config map that contains disk maker configuration is mounted")
+	flag.StringSliceVar(&configPaths, "config-paths", nil, "if set, files layered in order instead of --config, a storageclass or setting of a later file replacing the one of earlier files")
+	flag.StringVar(&configURL, "config-url", "", "if set, url the configuration is fetched from instead of --config, with the Authorization header taken from $DISKMAKER_CONFIG_AUTHORIZATION")
+	flag.StringVar(&configAuthFile, "config-authorization-file", "", "file holding the Authorization header for --config-url, such as a mounted secret, re-read before every fetch")
+	flag.StringVar(&nodeName, "node-name", "", "name of the node, by default taken from $NODE_NAME, $MY_NODE_NAME, --node-name-file or the hostname")
+	flag.StringVar(&nodeNameFile, "node-name-file", "/etc/podinfo/nodename", "file holding the node name, such as one mounted through the Downward API")
 	flag.StringVar(&symlinkLocation, "local-disk-location", "/mnt/local-storage", "location where local disks should be symlinked")
+	flag.BoolVar(&protectSwap, "protect-swap", true, "do not symlink devices that are active swap devices")
+	flag.BoolVar(&excludeOpen, "exclude-open-devices", true, "do not symlink devices that some process has open, requires the host's pid namespace")
+	flag.BoolVar(&hostMountInfo, "host-mountinfo", false, "also do not symlink devices mounted on the host according to /proc/1/mountinfo, requires hostPID")
+	flag.BoolVar(&excludeStacked, "exclude-stack-members", true, "do not symlink devices that MD, DRBD or device-mapper devices are built on")
+	flag.StringVar(&excludeUdev, "exclude-udev-property", "", "NAME=VALUE, do not symlink devices whose udev property NAME equals VALUE")
+	flag.DurationVar(&gcInterval, "orphan-gc-interval", 5*time.Minute, "interval for removing symlinks whose devices no longer exist, 0 disables it")
+	flag.DurationVar(&danglingGrace, "dangling-link-grace-period", 0, "how long symlinks of disappeared devices are kept before they are removed")
+	flag.DurationVar(&reconcileLimit, "reconcile-timeout", 0, "abort reconciles taking longer, such as ones stuck on a hung device, killing the commands they run, 0 disables it")
+	flag.BoolVar(&watchSymlinks, "watch-symlinks", false, "re-create symlinks removed from --local-disk-location right away instead of on the next reconcile")
+	flag.StringVar(&filterCommand, "filter-command", "", "executable run with the storageclass and /dev path of every matched device, which is only claimed if it exits with status 0")
+	flag.DurationVar(&filterTimeout, "filter-timeout", 10*time.Second, "time after which --filter-command is killed and the device excluded")
+	flag.DurationVar(&triggerDebounce, "trigger-debounce", 500*time.Millisecond, "delay coalescing requested reconciles, such as on SIGHUP, into one")
+	flag.StringVar(&allowlistPath, "allowlist", "", "optional node local file listing device names or ids that may be symlinked")
+	flag.IntVar(&dirUID, "dir-uid", -1, "owner uid of created storageclass directories, -1 leaves it unchanged")
+	flag.IntVar(&dirGID, "dir-gid", -1, "owner gid of created storageclass directories, -1 leaves it unchanged")
+	flag.Uint32Var(&dirMode, "dir-mode", 0755, "mode of storageclass directories such as 0755, restored if changed out of band")
+	flag.StringVar(&lsblkPath, "lsblk-path", "lsblk", "lsblk binary used to list block devices")
+	flag.StringVar(&fstabPath, "fstab-path", "/host/etc/fstab", "fstab checked for the fs options of devices, the host's one mounted by the DaemonSet by default")
+	flag.StringSliceVar(&lsblkArgs, "lsblk-extra-args", nil, "extra arguments passed to lsblk")
+	flag.IntVar(&resolveWorkers, "resolve-concurrency", runtime.NumCPU(), "number of /dev/disk/by-id entries resolved in parallel")
+	flag.BoolVar(&xattrTags, "xattr-tags", false, "set the storageclass and device id of symlinked devices as extended attributes of their .meta sidecar")
+	flag.BoolVar(&sizeHints, "size-hints", false, "write the size in bytes of symlinked devices to their .meta sidecar")
+	flag.StringVar(&leasePath, "lease-path", "", "lock file that diskmakers sharing the node take before reconciling, empty disables it")
+	flag.StringSliceVar(&protectedPaths, "protected-paths", []string{"/var/lib/kubelet"}, "paths whose devices are never claimed, together with the other partitions of their disks")
+	flag.BoolVar(&rescanSCSI, "rescan-scsi", false, "rescan all SCSI hosts before listing devices, to discover newly attached SAN LUNs")
+	flag.BoolVar(&metricsOnly, "metrics-only", false, "only export metrics about block devices of the node, without claiming any")
+	flag.StringVar(&shadowLinks, "shadow-link-location", "", "if set, only compare devices that would be symlinked with symlinks another tool created here")
+	flag.StringVar(&shadowReport, "shadow-report", "/tmp/diskmaker-shadow-report.json", "file the shadow mode report is written to")
+	flag.BoolVar(&jsonOutput, "json", false, "print the result of discover as JSON")
+	flag.StringVar(&statusResource, "node-status-resource", "", "resource.version.group of custom resources named after nodes whose status is patched with the claimed devices, empty disables it")
+	flag.StringVar(&statusNamespace, "node-status-namespace", "", "namespace of the --node-status-resource, empty if it is cluster scoped")
+	flag.StringVar(&nodeLabelsFile, "node-labels-file", "", "file holding the node labels as key=\"value\" lines, for storageclasses with a nodeLabelSelector")
+	flag.BoolVar(&nodeLabelsAPI, "node-labels-from-api", false, "get the node labels from the API server, for storageclasses with a nodeLabelSelector, unless --node-labels-file is set")
+	flag.StringVar(&httpAddress, "http-address", "", "address such as :8383 to serve metrics and version on, empty disables the http server")
+	flag.StringVar(&socketPath, "socket-path", "", "unix socket answering the line \"status\" with the status of the last reconcile as JSON, empty disables it")
 }
 
 func printVersion() {
 	logrus.Infof("Go Version: %s", runtime.Version())
 	logrus.Infof("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH)
+	info := version.Get()
+	logrus.Infof("diskmaker version %s, commit %s, built %s", info.Version, info.Commit, info.BuildDate)
 }
 
 func main() {
 	printVersion()
 	flag.Parse()
+	if httpAddress != "" {
+		http.Handle("/metrics", promhttp.Handler())
+		http.Handle("/version", version.Handler())
+		go func() {
+			logrus.Fatalf("error serving http on %s: %v", httpAddress, http.ListenAndServe(httpAddress, nil))
+		}()
+	}
+	identityProvider := &diskmaker.IdentityProvider{NodeName: nodeName, DownwardAPIPath: nodeNameFile}
+	identity, err := identityProvider.Resolve()
+	if err != nil {
+		logrus.Fatalf("error resolving node name: %v", err)
+	}
 	diskMaker := diskmaker.NewDiskMaker(configLocation, symlinkLocation)
+	diskMaker.Log = diskMaker.Log.WithField("node", identity.Name)
+	diskMaker.Log.Infof("node name %s resolved from %s", identity.Name, identity.Source)
+	diskMaker.NodeName = identity.Name
+	if len(configPaths) > 0 {
+		diskMaker.ConfigSource = diskmaker.NewLayeredConfigSource(configPaths...)
+	}
+	if configURL != "" {
+		configSource := diskmaker.NewHTTPConfigSource(configURL, os.Getenv("DISKMAKER_CONFIG_AUTHORIZATION"))
+		configSource.AuthHeaderFile = configAuthFile
+		configSource.Log = diskMaker.Log
+		diskMaker.ConfigSource = configSource
+	}
+	diskMaker.ProtectSwap = protectSwap
+	diskMaker.ExcludeOpenDevices = excludeOpen
+	diskMaker.HostMountInfo = hostMountInfo
+	diskMaker.RescanSCSI = rescanSCSI
+	diskMaker.ProtectedPaths = protectedPaths
+	diskMaker.ExcludeStackMembers = excludeStacked
+	if excludeUdev != "" {
+		parts := strings.SplitN(excludeUdev, "=", 2)
+		if len(parts) != 2 {
+			logrus.Fatalf("invalid --exclude-udev-property %q, expected NAME=VALUE", excludeUdev)
+		}
+		diskMaker.ExcludeUdevProperty = parts[0]
+		diskMaker.ExcludeUdevValue = parts[1]
+	}
+	diskMaker.OrphanGCInterval = gcInterval
+	diskMaker.DanglingLinkGracePeriod = danglingGrace
+	diskMaker.TriggerDebounce = triggerDebounce
+	diskMaker.ReconcileTimeout = reconcileLimit
+	diskMaker.FilterCommand = filterCommand
+	diskMaker.FilterTimeout = filterTimeout
+	diskMaker.AllowlistPath = allowlistPath
+	diskMaker.DirUID = dirUID
+	diskMaker.DirGID = dirGID
+	diskMaker.DirMode = os.FileMode(dirMode)
+	diskMaker.LsblkPath = lsblkPath
+	diskMaker.FstabPath = fstabPath
+	diskMaker.LsblkExtraArgs = lsblkArgs
+	diskMaker.ResolveConcurrency = resolveWorkers
+	diskMaker.XattrTags = xattrTags
+	diskMaker.SizeHints = sizeHints
+	diskMaker.MetricsOnly = metricsOnly
+	diskMaker.LeasePath = leasePath
+	diskMaker.WatchSymlinks = watchSymlinks
+	diskMaker.SocketPath = socketPath
+	diskMaker.ShadowMode = shadowLinks != ""
+	diskMaker.ShadowLinkLocation = shadowLinks
+	diskMaker.ShadowReportPath = shadowReport
+	if statusResource != "" {
+		diskMaker.NodeStatusClient = newNodeStatusClient()
+	}
+	if nodeLabelsFile != "" {
+		diskMaker.NodeLabels = &diskmaker.FileNodeLabelSource{Path: nodeLabelsFile}
+	} else if nodeLabelsAPI {
+		diskMaker.NodeLabels = &diskmaker.KubeNodeLabelSource{Client: newKubeClient(), NodeName: identity.Name}
+	}
+	// "diskmaker self-test" only checks access to devices and symlinkLocation
+	if flag.Arg(0) == "self-test" {
+		err := diskMaker.SelfTest()
+		if err != nil {
+			logrus.Fatalf("%v", err)
+		}
+		return
+	}
+	// "diskmaker discover" only prints what would be symlinked
+	if flag.Arg(0) == "discover" {
+		printDiscovery(diskMaker.Discover())
+		return
+	}
 	stopChannel := make(chan struct{})
-	diskMaker.Run(stopChannel)
+	err = diskMaker.Run(stopChannel)
+	if err != nil {
+		logrus.Fatalf("error running diskmaker: %v", err)
+	}
+}
+
+func newNodeStatusClient() diskmaker.NodeStatusClient {
+	gvr, _ := schema.ParseResourceArg(statusResource)
+	if gvr == nil {
+		logrus.Fatalf("invalid --node-status-resource %q, expected resource.version.group", statusResource)
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		logrus.Fatalf("error getting in-cluster config: %v", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		logrus.Fatalf("error creating dynamic client: %v", err)
+	}
+	return &diskmaker.DynamicNodeStatusClient{Client: client, Resource: *gvr, Namespace: statusNamespace}
+}
+
+func newKubeClient() kubernetes.Interface {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		logrus.Fatalf("error getting in-cluster config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		logrus.Fatalf("error creating client: %v", err)
+	}
+	return client
+}
+
+func printDiscovery(result diskmaker.DiscoveryResult) {
+	if jsonOutput {
+		content, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logrus.Fatalf("error marshalling discovery result: %v", err)
+		}
+		fmt.Println(string(content))
+		return
+	}
+	for storageClass, devices := range result.Matched {
+		for _, device := range devices {
+			fmt.Printf("%s: matched by storageclass %s\n", device.Name, storageClass)
+		}
+	}
+	for deviceName, reason := range result.SkipReasons {
+		fmt.Printf("%s: skipped, %s\n", deviceName, reason)
+	}
+	for _, err := range result.Errors {
+		fmt.Printf("error: %s\n", err)
+	}
 }
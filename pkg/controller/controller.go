@@ -575,10 +575,17 @@ func (h *Handler) generateDiskMakerDaemonSet(cr *v1alpha1.LocalVolume) *appsv1.D
 					MountPath:        "/dev",
 					MountPropagation: &hostContainerPropagation,
 				},
+				{
+					// fstab of the host, checked for fs options of devices
+					Name:      "host-fstab",
+					ReadOnly:  true,
+					MountPath: "/host/etc/fstab",
+				},
 			},
 		},
 	}
 	directoryHostPath := corev1.HostPathDirectory
+	fileHostPath := corev1.HostPathFile
 	volumes := []corev1.Volume{
 		{
 			Name: "provisioner-config",
@@ -607,6 +614,15 @@ func (h *Handler) generateDiskMakerDaemonSet(cr *v1alpha1.LocalVolume) *appsv1.D
 				},
 			},
 		},
+		{
+			Name: "host-fstab",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: "/etc/fstab",
+					Type: &fileHostPath,
+				},
+			},
+		},
 	}
 	ds := &appsv1.DaemonSet{
 		TypeMeta: metav1.TypeMeta{
@@ -630,6 +646,8 @@ func (h *Handler) generateDiskMakerDaemonSet(cr *v1alpha1.LocalVolume) *appsv1.D
 					Containers:         containers,
 					ServiceAccountName: provisionerServiceAccount,
 					Volumes:            volumes,
+					// the diskmaker looks for devices opened by processes on the host
+					HostPID: true,
 				},
 			},
 		},
@@ -5,6 +5,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/openshift/local-storage-operator/pkg/apis/local/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -66,3 +67,41 @@ func getHandler() *Handler {
 		localDiskLocation:     "/mnt/local-storage",
 	}
 }
+
+func TestDiskMakerDaemonSetHostPID(t *testing.T) {
+	localStorageProvider := getLocalVolume()
+	handler := getHandler()
+	ds := handler.generateDiskMakerDaemonSet(localStorageProvider)
+	if !ds.Spec.Template.Spec.HostPID {
+		t.Errorf("expected the diskmaker to share the host's pid namespace")
+	}
+}
+
+func TestDiskMakerDaemonSetHostFstab(t *testing.T) {
+	localStorageProvider := getLocalVolume()
+	handler := getHandler()
+	ds := handler.generateDiskMakerDaemonSet(localStorageProvider)
+	mounted := false
+	for _, mount := range ds.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == "host-fstab" && mount.MountPath == "/host/etc/fstab" && mount.ReadOnly {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("expected fstab of the host to be mounted read-only at /host/etc/fstab, got %v", ds.Spec.Template.Spec.Containers[0].VolumeMounts)
+	}
+	found := false
+	for _, volume := range ds.Spec.Template.Spec.Volumes {
+		if volume.Name != "host-fstab" {
+			continue
+		}
+		found = true
+		hostPath := volume.VolumeSource.HostPath
+		if hostPath == nil || hostPath.Path != "/etc/fstab" || hostPath.Type == nil || *hostPath.Type != corev1.HostPathFile {
+			t.Errorf("expected host-fstab to be the /etc/fstab file of the host, got %v", volume.VolumeSource)
+		}
+	}
+	if !found {
+		t.Errorf("expected a host-fstab volume, got %v", ds.Spec.Template.Spec.Volumes)
+	}
+}
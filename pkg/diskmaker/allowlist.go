@@ -0,0 +1,52 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// loadAllowlist reads the node local allowlist of device names and ids, one per line.
+// Lines starting with # are comments. It returns nil when no allowlist is present,
+// which means every device is allowed.
+func (d *DiskMaker) loadAllowlist() (sets.String, error) {
+	if d.AllowlistPath == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(d.AllowlistPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read allowlist %s with %v", d.AllowlistPath, err)
+	}
+	allowlist := sets.NewString()
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowlist.Insert(filepath.Base(line))
+	}
+	return allowlist, nil
+}
+
+// filterAllowlisted drops matched devices that are not on the allowlist
+func (d *DiskMaker) filterAllowlisted(deviceMap map[string][]DiskLocation, allowlist sets.String) {
+	for storageClass, deviceArray := range deviceMap {
+		allowed := []DiskLocation{}
+		for _, deviceLocation := range deviceArray {
+			if allowlist.Has(deviceLocation.diskName) || (deviceLocation.diskID != "" && allowlist.Has(filepath.Base(deviceLocation.diskID))) {
+				allowed = append(allowed, deviceLocation)
+				continue
+			}
+			d.Log.Warningf("not symlinking device %s for storageclass %s, it is not on the allowlist", deviceLocation.diskName, storageClass)
+			d.skipDevice(deviceLocation.diskName, skipNotAllowlisted, "")
+		}
+		deviceMap[storageClass] = allowed
+	}
+}
@@ -0,0 +1,50 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowlist(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.AllowlistPath = filepath.Join(tmpDir, "allowlist")
+
+	// absent file means no restriction
+	allowlist, err := d.loadAllowlist()
+	if err != nil || allowlist != nil {
+		t.Fatalf("expected no allowlist, got %v, %v", allowlist, err)
+	}
+
+	content := "# reserved for local storage\nvdb\n/dev/disk/by-id/scsi-vdc\n"
+	if err := ioutil.WriteFile(d.AllowlistPath, []byte(content), 0644); err != nil {
+		t.Fatalf("error writing allowlist %v", err)
+	}
+	allowlist, err = d.loadAllowlist()
+	if err != nil {
+		t.Fatalf("error loading allowlist %v", err)
+	}
+	deviceMap := map[string][]DiskLocation{
+		"foo": {
+			{diskName: "vdb"},
+			{diskName: "vdc", diskID: "/dev/disk/by-id/scsi-vdc"},
+			{diskName: "vdd", diskID: "/dev/disk/by-id/scsi-vdd"},
+		},
+	}
+	d.filterAllowlisted(deviceMap, allowlist)
+	if len(deviceMap["foo"]) != 2 {
+		t.Fatalf("expected 2 allowed devices, got %+v", deviceMap["foo"])
+	}
+	for _, deviceLocation := range deviceMap["foo"] {
+		if deviceLocation.diskName == "vdd" {
+			t.Errorf("expected vdd to be dropped")
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package diskmaker
+
+import (
+	"os"
+	"path"
+	"syscall"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Modes of storageclass directories unless DiskMaker.DirMode is set, and of metadata sidecars
+const (
+	defaultDirMode os.FileMode = 0755
+	metaFileMode   os.FileMode = 0644
+)
+
+// auditPermissions restores the mode and owner of storageclass directories and the
+// mode of metadata sidecars of devices in deviceMap when they were changed out of band,
+// such as by an administrator chmod'ing the symlink location
+func (d *DiskMaker) auditPermissions(deviceMap map[string][]DiskLocation) {
+	dirs := sets.NewString()
+	metaFiles := []string{}
+	for storageClass, deviceArray := range deviceMap {
+		classDir := path.Join(d.symlinkLocation, storageClass)
+		dirs.Insert(classDir)
+		for _, deviceLocation := range deviceArray {
+			if deviceLocation.subDir != "" {
+				dirs.Insert(path.Join(classDir, deviceLocation.subDir))
+			}
+			metaFiles = append(metaFiles, path.Join(classDir, deviceLocation.symlinkName())+metaSuffix)
+		}
+	}
+	for _, dir := range dirs.List() {
+		info, err := d.fs.Lstat(dir)
+		if err != nil {
+			continue
+		}
+		d.correctMode(dir, info, d.DirMode)
+		d.correctOwner(dir, info)
+	}
+	for _, metaFile := range metaFiles {
+		info, err := d.fs.Lstat(metaFile)
+		if err != nil {
+			continue
+		}
+		d.correctMode(metaFile, info, metaFileMode)
+	}
+}
+
+// correctMode sets the permissions of filePath to mode if they differ
+func (d *DiskMaker) correctMode(filePath string, info os.FileInfo, mode os.FileMode) {
+	if info.Mode().Perm() == mode.Perm() {
+		return
+	}
+	d.Log.Warningf("mode of %s changed to %v, restoring %v", filePath, info.Mode().Perm(), mode.Perm())
+	err := d.fs.Chmod(filePath, mode.Perm())
+	if err != nil {
+		d.Log.Errorf("error changing mode of %s with %v", filePath, err)
+	}
+}
+
+// correctOwner restores the configured owner of a storageclass directory if it differs
+func (d *DiskMaker) correctOwner(dirPath string, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || geteuid() != 0 {
+		return
+	}
+	if (d.DirUID == -1 || int(stat.Uid) == d.DirUID) && (d.DirGID == -1 || int(stat.Gid) == d.DirGID) {
+		return
+	}
+	d.Log.Warningf("owner of %s changed to %d:%d, restoring %d:%d", dirPath, stat.Uid, stat.Gid, d.DirUID, d.DirGID)
+	d.chownDir(dirPath)
+}
@@ -0,0 +1,51 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAuditPermissions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.fs = &fakeFS{setxattrErr: unix.ENOTSUP}
+	d.ProtectSwap = false
+	d.XattrTags = true
+	d.reconcile()
+
+	classDir := filepath.Join(symlinkLocation, "foo")
+	metaPath := filepath.Join(classDir, "vdb"+metaSuffix)
+	if err := os.Chmod(classDir, 0700); err != nil {
+		t.Fatalf("error changing mode of %s %v", classDir, err)
+	}
+	if err := os.Chmod(metaPath, 0600); err != nil {
+		t.Fatalf("error changing mode of %s %v", metaPath, err)
+	}
+	d.reconcile()
+
+	expected := map[string]os.FileMode{classDir: 0755, metaPath: 0644}
+	for filePath, mode := range expected {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			t.Fatalf("error reading %s %v", filePath, err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("expected mode of %s to be restored to %v, got %v", filePath, mode, info.Mode().Perm())
+		}
+	}
+}
@@ -1,12 +1,104 @@
 package diskmaker
 
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lsblkColumns are the columns requested from lsblk, see parseBlockDevices
+const lsblkColumns = "NAME,MAJ:MIN,TYPE,SIZE,MOUNTPOINT,FSTYPE,MODEL,TRAN,UUID,HCTL,PTTYPE,PKNAME,ROTA,PARTUUID,PARTLABEL,WWN,SERIAL"
+
+var lsblkPairRegex = regexp.MustCompile(`([A-Z:\-]+)="([^"]*)"`)
+
 // Block device
 type BlockDevice struct {
 	Name       string `json:"name"`
+	MajMin     string `json:"maj:min"`
 	DiskType   string `json:"type"`
 	Size       string `json:"size"`
 	MountPoint string `json:"mountpoint"`
+	FSType     string `json:"fstype"`
+	Model      string `json:"model"`
+	Transport  string `json:"tran"`
+	UUID       string `json:"uuid"`
+	HCTL       string `json:"hctl"`
+	// PartTable is the type of the partition table of the device, such as gpt or dos
+	PartTable string `json:"pttype"`
+	// Parent is the name of the device this one is a partition of or is stacked on, such
+	// as the disk of an MD array member. Stacked devices are listed once per parent.
+	Parent string `json:"pkname"`
+	// Rotational is set for spinning disks
+	Rotational bool `json:"rota"`
+	// PartUUID is the unique id of a GPT partition, see PartitionSymlinkTargetPartUUID
+	PartUUID string `json:"partuuid"`
+	// PartLabel is the name of a GPT partition, see autoPartitionLabel
+	PartLabel string `json:"partlabel"`
+	// WWN and Serial identify the hardware of the device, partitions report those of their disk
+	WWN    string `json:"wwn"`
+	Serial string `json:"serial"`
 }
 
 type DeviceArray []BlockDevice
 type BlockDeviceMap map[string]DeviceArray
+
+// sizeBytes returns size of the device, lsblk is run with --bytes
+func (b BlockDevice) sizeBytes() (int64, error) {
+	return strconv.ParseInt(b.Size, 10, 64)
+}
+
+// parseBlockDevices parses output of lsblk --pairs, where every line
+// describes one device as KEY="value" pairs.
+func parseBlockDevices(content string) []BlockDevice {
+	blockDevices := []BlockDevice{}
+	for _, deviceLine := range strings.Split(content, "\n") {
+		pairs := lsblkPairRegex.FindAllStringSubmatch(deviceLine, -1)
+		if len(pairs) == 0 {
+			continue
+		}
+		blockDevice := BlockDevice{}
+		for _, pair := range pairs {
+			value := pair[2]
+			switch pair[1] {
+			case "NAME":
+				blockDevice.Name = value
+			case "MAJ:MIN":
+				blockDevice.MajMin = value
+			case "TYPE":
+				blockDevice.DiskType = value
+			case "SIZE":
+				blockDevice.Size = value
+			case "MOUNTPOINT":
+				blockDevice.MountPoint = value
+			case "FSTYPE":
+				blockDevice.FSType = value
+			case "MODEL":
+				blockDevice.Model = strings.TrimSpace(value)
+			case "TRAN":
+				blockDevice.Transport = value
+			case "UUID":
+				blockDevice.UUID = value
+			case "HCTL":
+				blockDevice.HCTL = value
+			case "PTTYPE":
+				blockDevice.PartTable = value
+			case "PKNAME":
+				blockDevice.Parent = value
+			case "ROTA":
+				blockDevice.Rotational = value == "1"
+			case "PARTUUID":
+				blockDevice.PartUUID = value
+			case "PARTLABEL":
+				blockDevice.PartLabel = value
+			case "WWN":
+				blockDevice.WWN = value
+			case "SERIAL":
+				blockDevice.Serial = strings.TrimSpace(value)
+			}
+		}
+		if len(blockDevice.Name) > 0 {
+			blockDevices = append(blockDevices, blockDevice)
+		}
+	}
+	return blockDevices
+}
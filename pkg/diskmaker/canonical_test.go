@@ -0,0 +1,52 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonicalizeTargets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+
+	// by default symlinks point at the stable id
+	d.reconcile()
+	target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", "vdb"))
+	if err != nil || target != filepath.Join(tmpDir, "by-id", "virtio-vdb") {
+		t.Errorf("expected vdb to be symlinked through its stable id, got %s, %v", target, err)
+	}
+
+	config := "canonicalizeTargets: true\nfoo:\n  disks: [vdb, vdc]\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	target, err = os.Readlink(filepath.Join(symlinkLocation, "foo", "vdc"))
+	if err != nil || target != filepath.Join(tmpDir, "vdc") {
+		t.Errorf("expected vdc to be symlinked to its device node, got %s, %v", target, err)
+	}
+	// the existing symlink resolves to the same device and is kept
+	if len(d.reconcileErrors) != 0 {
+		t.Errorf("expected no errors, got %v", d.reconcileErrors)
+	}
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb", "vdc"}) {
+		t.Errorf("expected vdb and vdc to be claimed, got %v", claimed)
+	}
+	if claimed := d.claimed["foo"]; len(claimed) != 2 || claimed[1].diskID != filepath.Join(tmpDir, "by-id", "virtio-vdc") {
+		t.Errorf("expected devices to still be tracked by stable id, got %v", claimed)
+	}
+}
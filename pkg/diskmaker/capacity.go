@@ -0,0 +1,183 @@
+package diskmaker
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// claimCandidate is a matched device considered by limitTotalSize
+type claimCandidate struct {
+	storageClass string
+	location     DiskLocation
+	size         int64
+	claimed      bool
+}
+
+// limitTotalSize drops matched devices from deviceMap so that the total size of claimed
+// devices does not exceed NodeSettings.MaxTotalSize. Devices claimed by the previous
+// reconcile are kept first. The rest are kept in the order of Disks.OrderBy of their
+// storageclass, by size according to NodeSettings.DropPolicy if it is not set.
+// Between storageclasses, the next device is chosen according to DropPolicy.
+func (d *DiskMaker) limitTotalSize(deviceMap map[string][]DiskLocation, deviceSet map[string]BlockDevice) {
+	ordered := make(map[string][]claimCandidate)
+	for storageClass, deviceArray := range deviceMap {
+		if d.marksDevices(storageClass) {
+			continue
+		}
+		sized := []DiskLocation{}
+		for _, deviceLocation := range deviceArray {
+			size, err := deviceSet[deviceLocation.diskName].sizeBytes()
+			if err != nil {
+				d.Log.Infof("not symlinking device %s, unable to determine its size for maxTotalSize: %v", deviceLocation.diskName, err)
+				d.skipDevice(deviceLocation.diskName, skipCapacity, "size unknown")
+				continue
+			}
+			deviceLocation.size = size
+			sized = append(sized, deviceLocation)
+		}
+		orderDevices(sized, d.capacityOrder(storageClass))
+		for _, deviceLocation := range sized {
+			ordered[storageClass] = append(ordered[storageClass], claimCandidate{
+				storageClass: storageClass,
+				location:     deviceLocation,
+				size:         deviceLocation.size,
+				claimed:      hasLocation(d.claimed[storageClass], deviceLocation),
+			})
+		}
+	}
+	candidates := d.mergeCandidates(ordered)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].claimed && !candidates[j].claimed
+	})
+
+	maxTotalSize := d.settings.MaxTotalSize.Value()
+	total := int64(0)
+	for storageClass := range deviceMap {
+		if !d.marksDevices(storageClass) {
+			deviceMap[storageClass] = nil
+		}
+	}
+	for _, candidate := range candidates {
+		if total+candidate.size > maxTotalSize {
+			d.Log.Infof("not symlinking device %s for storageclass %s, claiming it would exceed maxTotalSize %s", candidate.location.diskName, candidate.storageClass, d.settings.MaxTotalSize.String())
+			d.skipDevice(candidate.location.diskName, skipCapacity, fmt.Sprintf("exceeds maxTotalSize %s", d.settings.MaxTotalSize.String()))
+			continue
+		}
+		total += candidate.size
+		deviceMap[candidate.storageClass] = append(deviceMap[candidate.storageClass], candidate.location)
+	}
+	for storageClass, deviceArray := range deviceMap {
+		if len(deviceArray) == 0 {
+			delete(deviceMap, storageClass)
+		}
+	}
+}
+
+// capacityOrder returns the order in which devices of a storageclass are kept within
+// MaxTotalSize, its Disks.OrderBy or else the one following DropPolicy
+func (d *DiskMaker) capacityOrder(storageClass string) string {
+	if disks := d.diskConfig[storageClass]; disks != nil && disks.OrderBy != "" {
+		return disks.OrderBy
+	}
+	if d.settings.DropPolicy == DropSmallest {
+		return OrderBySizeDesc
+	}
+	return OrderBySizeAsc
+}
+
+// mergeCandidates merges the ordered candidates of storageclasses into one list. The
+// next candidate is the first remaining one of a storageclass preferred by DropPolicy.
+func (d *DiskMaker) mergeCandidates(ordered map[string][]claimCandidate) []claimCandidate {
+	storageClasses := []string{}
+	for storageClass := range ordered {
+		storageClasses = append(storageClasses, storageClass)
+	}
+	sort.Strings(storageClasses)
+	merged := []claimCandidate{}
+	for {
+		next := ""
+		for _, storageClass := range storageClasses {
+			if len(ordered[storageClass]) == 0 {
+				continue
+			}
+			if next == "" || d.preferredCandidate(ordered[storageClass][0], ordered[next][0]) {
+				next = storageClass
+			}
+		}
+		if next == "" {
+			return merged
+		}
+		merged = append(merged, ordered[next][0])
+		ordered[next] = ordered[next][1:]
+	}
+}
+
+// preferredCandidate returns whether a is kept before b according to DropPolicy
+func (d *DiskMaker) preferredCandidate(a, b claimCandidate) bool {
+	if a.size != b.size {
+		if d.settings.DropPolicy == DropSmallest {
+			return a.size > b.size
+		}
+		return a.size < b.size
+	}
+	return sortKey(a.location) < sortKey(b.location)
+}
+
+// limitClaimFraction drops matched devices of storageclasses with a ClaimFraction so
+// that only that fraction of them is claimed. Like in limitTotalSize, devices claimed by
+// the previous reconcile are kept first, then the first ones in the order of
+// Disks.OrderBy, so the same subset is kept every time.
+func (d *DiskMaker) limitClaimFraction(diskConfig DiskConfig, deviceMap map[string][]DiskLocation) {
+	for storageClass, deviceArray := range deviceMap {
+		disks := diskConfig[storageClass]
+		if disks == nil || disks.ClaimFraction == 0 {
+			continue
+		}
+		orderDevices(deviceArray, disks.OrderBy)
+		sort.SliceStable(deviceArray, func(i, j int) bool {
+			return hasLocation(d.claimed[storageClass], deviceArray[i]) && !hasLocation(d.claimed[storageClass], deviceArray[j])
+		})
+		keep := int(math.Ceil(disks.ClaimFraction * float64(len(deviceArray))))
+		for _, deviceLocation := range deviceArray[keep:] {
+			d.Log.Infof("not symlinking device %s for storageclass %s, beyond claimFraction %v", deviceLocation.diskName, storageClass, disks.ClaimFraction)
+			d.skipDevice(deviceLocation.diskName, skipFraction, "")
+		}
+		deviceMap[storageClass] = deviceArray[:keep]
+	}
+}
+
+// orderDevices sorts devices of a storageclass in place in the given order, see OrderByName
+func orderDevices(deviceArray []DiskLocation, orderBy string) {
+	serials := make(map[string]string)
+	if orderBy == OrderBySerial {
+		for _, deviceLocation := range deviceArray {
+			serial, err := readSysfsAttribute(deviceLocation.diskName, "device/serial")
+			if err == nil {
+				serials[deviceLocation.diskName] = serial
+			}
+		}
+	}
+	sort.SliceStable(deviceArray, func(i, j int) bool {
+		a, b := deviceArray[i], deviceArray[j]
+		switch orderBy {
+		case OrderBySizeAsc, OrderBySizeDesc:
+			if a.size != b.size {
+				return (a.size < b.size) == (orderBy == OrderBySizeAsc)
+			}
+		case OrderBySerial:
+			serialA, serialB := serials[a.diskName], serials[b.diskName]
+			if serialA != serialB {
+				return serialB == "" || (serialA != "" && serialA < serialB)
+			}
+		}
+		return sortKey(a) < sortKey(b)
+	})
+}
+
+func sortKey(l DiskLocation) string {
+	if l.diskID != "" {
+		return l.diskID
+	}
+	return l.diskName
+}
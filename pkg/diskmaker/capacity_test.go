@@ -0,0 +1,196 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaxTotalSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+
+	tests := []struct {
+		name     string
+		config   string
+		expected map[string][]string
+		dropped  []string
+	}{
+		{
+			name:     "largest dropped by default",
+			config:   "maxTotalSize: 25Gi\nfoo:\n  disks: [vdb, vdd]\nbar:\n  disks: [vdc]\n",
+			expected: map[string][]string{"foo": {"vdb"}, "bar": {"vdc"}},
+			dropped:  []string{"vdd"},
+		},
+		{
+			name:     "smallest dropped",
+			config:   "maxTotalSize: 1030Gi\ndropPolicy: smallest\nfoo:\n  disks: [vdb, vdd]\nbar:\n  disks: [vdc]\n",
+			expected: map[string][]string{"foo": {"vdd"}},
+			dropped:  []string{"vdb", "vdc"},
+		},
+		{
+			name:     "orderBy of the storageclass",
+			config:   "maxTotalSize: 1030Gi\nfoo:\n  disks: [vdb, vdd]\n  orderBy: size-desc\n",
+			expected: map[string][]string{"foo": {"vdd"}},
+			dropped:  []string{"vdb"},
+		},
+		{
+			name:     "unlimited",
+			config:   "foo:\n  disks: [vdb, vdd]\nbar:\n  disks: [vdc]\n",
+			expected: map[string][]string{"foo": {"vdb", "vdd"}, "bar": {"vdc"}},
+		},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(configFile, []byte(test.config), 0644); err != nil {
+			t.Fatalf("error writing config %v", err)
+		}
+		d := NewDiskMaker(configFile, filepath.Join(tmpDir, test.name))
+		d.runner = &fakeRunner{output: getData()}
+		d.ProtectSwap = false
+		d.reconcile()
+
+		status := d.Status()
+		if len(status.Claimed) != len(test.expected) {
+			t.Errorf("%s: expected %v to be claimed, got %v", test.name, test.expected, status.Claimed)
+		}
+		for storageClass, devices := range test.expected {
+			if !equalStrings(status.Claimed[storageClass], devices) {
+				t.Errorf("%s: expected %v to be claimed, got %v", test.name, test.expected, status.Claimed)
+			}
+		}
+		for _, diskName := range test.dropped {
+			if !strings.HasPrefix(status.SkipReasons[diskName], skipCapacity) {
+				t.Errorf("%s: expected %s to be dropped, got %q", test.name, diskName, status.SkipReasons[diskName])
+			}
+		}
+	}
+}
+
+func TestKeepClaimedWithinMaxTotalSize(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	d.settings = NodeSettings{MaxTotalSize: quantity("1030Gi")}
+	// vdd is already claimed, so it is kept although the largest
+	d.claimed = map[string][]DiskLocation{"foo": {{diskName: "vdd"}}}
+	deviceMap := map[string][]DiskLocation{"foo": {{diskName: "vdb"}, {diskName: "vdc"}, {diskName: "vdd"}}}
+	d.limitTotalSize(deviceMap, deviceSet)
+	if len(deviceMap["foo"]) != 1 || deviceMap["foo"][0].diskName != "vdd" {
+		t.Errorf("expected only claimed vdd to be kept, got %v", deviceMap)
+	}
+}
+
+func TestClaimFraction(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	// stable ids sort in reverse order of device names
+	defer fakeDiskByID(t, tmpDir, map[string]string{"wwn-4": "vdb", "wwn-3": "vdc", "wwn-2": "vdd", "wwn-1": "vdf"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vdd, vdf]\n  claimFraction: 0.3\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+		d.runner = &fakeRunner{output: getData()}
+		d.ProtectSwap = false
+		d.reconcile()
+
+		// ceil(0.3 * 4) devices, in the order of their stable ids
+		status := d.Status()
+		if !equalStrings(status.Claimed["foo"], []string{"vdf", "vdd"}) {
+			t.Errorf("expected vdf and vdd to be claimed, got %v", status.Claimed)
+		}
+		for _, diskName := range []string{"vdb", "vdc"} {
+			if status.SkipReasons[diskName] != skipFraction {
+				t.Errorf("expected %s to be skipped for claimFraction, got %q", diskName, status.SkipReasons[diskName])
+			}
+		}
+	}
+
+	if err := (DiskConfig{"foo": &Disks{ClaimFraction: 1.5}}).validate(); err == nil {
+		t.Errorf("expected claimFraction above 1 to fail validation")
+	}
+}
+
+func TestKeepClaimedWithinClaimFraction(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	diskConfig := DiskConfig{"foo": &Disks{ClaimFraction: 0.5}}
+	// vdb appeared after vdc was claimed, vdc is kept although vdb sorts first
+	d.claimed = map[string][]DiskLocation{"foo": {{diskName: "vdc"}}}
+	deviceMap := map[string][]DiskLocation{"foo": {{diskName: "vdb"}, {diskName: "vdc"}}}
+	d.limitClaimFraction(diskConfig, deviceMap)
+	if len(deviceMap["foo"]) != 1 || deviceMap["foo"][0].diskName != "vdc" {
+		t.Errorf("expected only claimed vdc to be kept, got %v", deviceMap)
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "device/serial", "S3")
+	writeSysfsAttribute(t, "vdc", "device/serial", "S1")
+	writeSysfsAttribute(t, "vdd", "device/serial", "S2")
+	devices := []DiskLocation{
+		{diskName: "vdb", diskID: "/dev/disk/by-id/wwn-2", size: 20},
+		{diskName: "vdc", diskID: "/dev/disk/by-id/wwn-3", size: 10},
+		{diskName: "vdd", diskID: "/dev/disk/by-id/wwn-1", size: 30},
+		{diskName: "vde", size: 10},
+	}
+
+	tests := map[string][]string{
+		"":              {"vdd", "vdb", "vdc", "vde"},
+		OrderByName:     {"vdd", "vdb", "vdc", "vde"},
+		OrderBySizeAsc:  {"vdc", "vde", "vdb", "vdd"},
+		OrderBySizeDesc: {"vdd", "vdb", "vdc", "vde"},
+		OrderBySerial:   {"vdc", "vdd", "vdb", "vde"},
+	}
+	for orderBy, expected := range tests {
+		deviceArray := append([]DiskLocation{}, devices...)
+		orderDevices(deviceArray, orderBy)
+		names := []string{}
+		for _, deviceLocation := range deviceArray {
+			names = append(names, deviceLocation.diskName)
+		}
+		if !equalStrings(names, expected) {
+			t.Errorf("expected order %q to be %v, got %v", orderBy, expected, names)
+		}
+	}
+
+	if err := (DiskConfig{"foo": &Disks{OrderBy: "random"}}).validate(); err == nil {
+		t.Errorf("expected unknown orderBy to fail validation")
+	}
+}
+
+func TestClaimFractionOrderBy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd", "virtio-vdf": "vdf"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vdd, vdf]\n  claimFraction: 0.25\n  orderBy: size-desc\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	// vdd is the only 1TiB device, the others have 10GiB
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdd"}) {
+		t.Errorf("expected the largest device vdd to be claimed, got %v", claimed)
+	}
+}
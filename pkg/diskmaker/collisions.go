@@ -0,0 +1,92 @@
+package diskmaker
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// resolveNameCollisions handles devices of a storageclass whose symlinks would have
+// the same name according to Disks.CollisionStrategy. Without it, the symlink of the
+// first device would be created and the others would fail on every reconcile.
+// Devices keep the names they were claimed with, the remaining ones are handed out in
+// the order of their stable ids so that they don't depend on kernel device names.
+func (d *DiskMaker) resolveNameCollisions(diskConfig DiskConfig, deviceMap map[string][]DiskLocation) {
+	for storageClass, deviceArray := range deviceMap {
+		sort.Slice(deviceArray, func(i, j int) bool {
+			return collisionOrderKey(deviceArray[i]) < collisionOrderKey(deviceArray[j])
+		})
+		claimedNames := make(map[string]string)
+		for _, deviceLocation := range d.claimed[storageClass] {
+			claimedNames[deviceLocation.target()] = deviceLocation.symlinkName()
+		}
+		// names maps symlink names to the devices owning them, assigned the names of
+		// devices which are not the first to take them
+		names := make(map[string]string)
+		assigned := make(map[string]string)
+		for _, deviceLocation := range deviceArray {
+			name := deviceLocation.symlinkName()
+			claimedName, found := claimedNames[deviceLocation.target()]
+			if !found || (claimedName != name && !isSuffixedName(claimedName, name)) {
+				continue
+			}
+			if _, taken := names[claimedName]; !taken {
+				names[claimedName] = deviceLocation.diskName
+				assigned[deviceLocation.diskName] = claimedName
+			}
+		}
+		for _, deviceLocation := range deviceArray {
+			if _, found := assigned[deviceLocation.diskName]; found {
+				continue
+			}
+			if _, found := names[deviceLocation.symlinkName()]; !found {
+				names[deviceLocation.symlinkName()] = deviceLocation.diskName
+				assigned[deviceLocation.diskName] = deviceLocation.symlinkName()
+			}
+		}
+		kept := []DiskLocation{}
+		for _, deviceLocation := range deviceArray {
+			name := deviceLocation.symlinkName()
+			if assignedName, found := assigned[deviceLocation.diskName]; found {
+				if assignedName != name {
+					deviceLocation.linkName = path.Base(assignedName)
+				}
+				kept = append(kept, deviceLocation)
+				continue
+			}
+			owner := names[name]
+			if diskConfig[storageClass].CollisionStrategy != CollisionSuffix {
+				d.throttledErrorf("name-collision/"+deviceLocation.diskName, "not symlinking device %s for storageclass %s, its symlink %s would replace the one of %s", deviceLocation.diskName, storageClass, name, owner)
+				d.skipDevice(deviceLocation.diskName, skipNameCollision, owner)
+				continue
+			}
+			baseName := path.Base(name)
+			for n := 2; ; n++ {
+				deviceLocation.linkName = fmt.Sprintf("%s-%d", baseName, n)
+				if _, found := names[deviceLocation.symlinkName()]; !found {
+					break
+				}
+			}
+			d.Log.Infof("symlink %s of device %s for storageclass %s is taken by %s, using %s", name, deviceLocation.diskName, storageClass, owner, deviceLocation.symlinkName())
+			names[deviceLocation.symlinkName()] = deviceLocation.diskName
+			kept = append(kept, deviceLocation)
+		}
+		deviceMap[storageClass] = kept
+	}
+}
+
+// collisionOrderKey returns the stable id of a device, or its name if it has none
+func collisionOrderKey(deviceLocation DiskLocation) string {
+	if deviceLocation.diskID != "" {
+		return deviceLocation.diskID
+	}
+	return deviceLocation.diskName
+}
+
+var collisionSuffixRegex = regexp.MustCompile(`^-[0-9]+$`)
+
+// isSuffixedName returns whether name was derived from baseName by CollisionSuffix
+func isSuffixedName(name, baseName string) bool {
+	return len(name) > len(baseName) && name[:len(baseName)] == baseName && collisionSuffixRegex.MatchString(name[len(baseName):])
+}
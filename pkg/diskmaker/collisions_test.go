@@ -0,0 +1,86 @@
+package diskmaker
+
+import (
+	"testing"
+)
+
+func TestNameCollisions(t *testing.T) {
+	newDeviceMap := func() map[string][]DiskLocation {
+		return map[string][]DiskLocation{"foo": {
+			{diskName: "vdd", linkName: "disk"},
+			{diskName: "vdc", linkName: "disk"},
+			{diskName: "vdb", linkName: "disk"},
+			{diskName: "vde"},
+		}}
+	}
+	symlinkNames := func(deviceArray []DiskLocation) []string {
+		names := []string{}
+		for _, deviceLocation := range deviceArray {
+			names = append(names, deviceLocation.diskName+"="+deviceLocation.symlinkName())
+		}
+		return names
+	}
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.skipReasons = make(map[string]string)
+	deviceMap := newDeviceMap()
+	d.resolveNameCollisions(DiskConfig{"foo": &Disks{}}, deviceMap)
+	if names := symlinkNames(deviceMap["foo"]); !equalStrings(names, []string{"vdb=disk", "vde=vde"}) {
+		t.Errorf("expected only the first device named disk to be kept, got %v", names)
+	}
+	for _, diskName := range []string{"vdc", "vdd"} {
+		if reason := d.skipReasons[diskName]; reason != skipNameCollision+": vdb" {
+			t.Errorf("expected %s to be skipped for its name, got %q", diskName, reason)
+		}
+	}
+
+	d.skipReasons = make(map[string]string)
+	deviceMap = newDeviceMap()
+	d.resolveNameCollisions(DiskConfig{"foo": &Disks{CollisionStrategy: CollisionSuffix}}, deviceMap)
+	if names := symlinkNames(deviceMap["foo"]); !equalStrings(names, []string{"vdb=disk", "vdc=disk-2", "vdd=disk-3", "vde=vde"}) {
+		t.Errorf("expected colliding devices to be suffixed in order, got %v", names)
+	}
+	if len(d.skipReasons) != 0 {
+		t.Errorf("expected no device to be skipped, got %v", d.skipReasons)
+	}
+}
+
+func TestNameCollisionsStable(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.skipReasons = make(map[string]string)
+	diskConfig := DiskConfig{"foo": &Disks{CollisionStrategy: CollisionSuffix}}
+
+	// suffixes follow the stable ids, not the kernel names
+	deviceMap := map[string][]DiskLocation{"foo": {
+		{diskName: "vdb", diskID: "/dev/disk/by-id/wwn-3", linkName: "disk"},
+		{diskName: "vdc", diskID: "/dev/disk/by-id/wwn-1", linkName: "disk"},
+		{diskName: "vdd", diskID: "/dev/disk/by-id/wwn-2", linkName: "disk"},
+	}}
+	d.resolveNameCollisions(diskConfig, deviceMap)
+	names := map[string]string{}
+	for _, deviceLocation := range deviceMap["foo"] {
+		names[deviceLocation.diskID] = deviceLocation.symlinkName()
+	}
+	if names["/dev/disk/by-id/wwn-1"] != "disk" || names["/dev/disk/by-id/wwn-2"] != "disk-2" || names["/dev/disk/by-id/wwn-3"] != "disk-3" {
+		t.Errorf("expected suffixes in the order of stable ids, got %v", names)
+	}
+
+	// claimed devices keep their names when an earlier one appears
+	d.claimed = map[string][]DiskLocation{"foo": {
+		{diskName: "vdb", diskID: "/dev/disk/by-id/wwn-3", linkName: "disk"},
+		{diskName: "vdd", diskID: "/dev/disk/by-id/wwn-2", linkName: "disk-2"},
+	}}
+	deviceMap = map[string][]DiskLocation{"foo": {
+		{diskName: "vdb", diskID: "/dev/disk/by-id/wwn-3", linkName: "disk"},
+		{diskName: "vdc", diskID: "/dev/disk/by-id/wwn-1", linkName: "disk"},
+		{diskName: "vdd", diskID: "/dev/disk/by-id/wwn-2", linkName: "disk"},
+	}}
+	d.resolveNameCollisions(diskConfig, deviceMap)
+	names = map[string]string{}
+	for _, deviceLocation := range deviceMap["foo"] {
+		names[deviceLocation.diskID] = deviceLocation.symlinkName()
+	}
+	if names["/dev/disk/by-id/wwn-3"] != "disk" || names["/dev/disk/by-id/wwn-2"] != "disk-2" || names["/dev/disk/by-id/wwn-1"] != "disk-3" {
+		t.Errorf("expected claimed devices to keep their names, got %v", names)
+	}
+}
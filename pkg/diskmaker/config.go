@@ -0,0 +1,283 @@
+package diskmaker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Config holds the options of a DiskMaker created by NewDiskMakerWithConfig. Zero values
+// get the defaults NewDiskMaker uses, options whose zero value is meaningful are pointers.
+// See the DiskMaker fields of the same name for what the options do.
+type Config struct {
+	// ConfigLocation is the file the configuration is read from. Alternatively ConfigPaths
+	// lists files layered on top of each other, see NewLayeredConfigSource, or
+	// ConfigSource provides it.
+	ConfigLocation string
+	ConfigPaths    []string
+	ConfigSource   ConfigSource
+	// SymlinkLocation is the absolute path of the directory symlinks are created in
+	SymlinkLocation string
+	// Interval is how often devices are reconciled, 5s by default
+	Interval time.Duration
+
+	MetricsOnly bool
+
+	// ProtectSwap, ExcludeOpenDevices and ExcludeStackMembers are enabled by default
+	ProtectSwap         *bool
+	ExcludeOpenDevices  *bool
+	ExcludeStackMembers *bool
+	HostMountInfo       bool
+	RescanSCSI          bool
+	// ProtectedPaths are /var/lib/kubelet by default, an empty list protects none
+	ProtectedPaths      []string
+	ExcludeUdevProperty string
+	ExcludeUdevValue    string
+	AllowlistPath       string
+	FilterCommand       string
+	XattrTags           bool
+	SizeHints           bool
+
+	DanglingLinkGracePeriod time.Duration
+	// OrphanGCInterval is 5m by default, a negative interval disables the collector
+	OrphanGCInterval time.Duration
+	// TriggerDebounce is 500ms by default, a negative debounce disables it
+	TriggerDebounce  time.Duration
+	ReconcileTimeout time.Duration
+	// FilterTimeout is 10s by default
+	FilterTimeout time.Duration
+	// SymlinkRetry makes 3 attempts backing off from 100ms by default
+	SymlinkRetry RetryPolicy
+	// ResolveConcurrency is the number of CPUs by default
+	ResolveConcurrency int
+	// DirUID and DirGID leave the owner of storageclass directories unchanged by default
+	DirUID *int
+	DirGID *int
+	// DirMode is 0755 by default
+	DirMode os.FileMode
+
+	// FstabPath is /host/etc/fstab by default
+	FstabPath string
+	// LsblkPath is lsblk looked up in PATH by default
+	LsblkPath      string
+	LsblkExtraArgs []string
+
+	ShadowMode          bool
+	ShadowLinkLocation  string
+	ShadowReportPath    string
+	ReleasedDevicesPath string
+	LeasePath           string
+	WatchSymlinks       bool
+	SocketPath          string
+
+	NodeStatusClient NodeStatusClient
+	NodeName         string
+	NodeLabels       NodeLabelSource
+	OnReconcile      func(result ReconcileResult)
+
+	// Log, Recorder and Tracer default to a logger with the diskmaker component field,
+	// events that are only logged and no tracing
+	Log      *logrus.Entry
+	Recorder EventRecorder
+	Tracer   Tracer
+	// Runner runs commands such as lsblk and FS accesses the filesystem, they default
+	// to the host
+	Runner CommandRunner
+	FS     FileSystem
+	// MetricsRegisterer, if set, additionally registers the metrics of the diskmaker,
+	// which are always registered with the default prometheus registry
+	MetricsRegisterer prometheus.Registerer
+}
+
+// validate returns an error describing the first invalid option of the config
+func (c *Config) validate() error {
+	sources := 0
+	for _, set := range []bool{c.ConfigLocation != "", len(c.ConfigPaths) > 0, c.ConfigSource != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return fmt.Errorf("one of ConfigLocation, ConfigPaths and ConfigSource is required")
+	}
+	if sources > 1 {
+		return fmt.Errorf("ConfigLocation, ConfigPaths and ConfigSource are mutually exclusive")
+	}
+	if !filepath.IsAbs(c.SymlinkLocation) {
+		return fmt.Errorf("SymlinkLocation %q is not an absolute path", c.SymlinkLocation)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("Interval must not be negative, got %v", c.Interval)
+	}
+	if c.FilterTimeout < 0 {
+		return fmt.Errorf("FilterTimeout must not be negative, got %v", c.FilterTimeout)
+	}
+	if c.DanglingLinkGracePeriod < 0 || c.ReconcileTimeout < 0 {
+		return fmt.Errorf("DanglingLinkGracePeriod and ReconcileTimeout must not be negative")
+	}
+	if c.SymlinkRetry.Attempts < 0 || c.SymlinkRetry.Backoff < 0 {
+		return fmt.Errorf("SymlinkRetry must not be negative, got %+v", c.SymlinkRetry)
+	}
+	if c.ResolveConcurrency < 0 {
+		return fmt.Errorf("ResolveConcurrency must not be negative, got %d", c.ResolveConcurrency)
+	}
+	if (c.ExcludeUdevProperty == "") != (c.ExcludeUdevValue == "") {
+		return fmt.Errorf("ExcludeUdevProperty and ExcludeUdevValue must be set together")
+	}
+	if c.ShadowMode && (c.ShadowLinkLocation == "" || c.ShadowReportPath == "") {
+		return fmt.Errorf("ShadowMode requires ShadowLinkLocation and ShadowReportPath")
+	}
+	if c.MetricsOnly && c.ShadowMode {
+		return fmt.Errorf("MetricsOnly and ShadowMode are mutually exclusive")
+	}
+	if c.NodeStatusClient != nil && c.NodeName == "" {
+		return fmt.Errorf("NodeStatusClient requires NodeName")
+	}
+	return nil
+}
+
+// NewDiskMakerWithConfig returns a new DiskMaker with the options of config, or an
+// error if they are invalid
+func NewDiskMakerWithConfig(config Config) (*DiskMaker, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid diskmaker config: %v", err)
+	}
+	if config.MetricsRegisterer != nil {
+		err = registerMetrics(config.MetricsRegisterer)
+		if err != nil {
+			return nil, fmt.Errorf("error registering metrics with %v", err)
+		}
+	}
+	return newDiskMaker(config), nil
+}
+
+// newDiskMaker returns a DiskMaker with the options of config, applying defaults for
+// unset ones. It doesn't validate config.
+func newDiskMaker(config Config) *DiskMaker {
+	t := &DiskMaker{}
+	t.ConfigSource = config.ConfigSource
+	if len(config.ConfigPaths) > 0 {
+		t.ConfigSource = NewLayeredConfigSource(config.ConfigPaths...)
+	}
+	if t.ConfigSource == nil {
+		t.ConfigSource = fileConfigSource{config.ConfigLocation}
+	}
+	t.symlinkLocation = config.SymlinkLocation
+	t.runner = config.Runner
+	if t.runner == nil {
+		t.runner = execRunner{}
+	}
+	t.fs = config.FS
+	if t.fs == nil {
+		t.fs = osFileSystem{}
+	}
+	t.Interval = durationOrDefault(config.Interval, checkDuration)
+	t.MetricsOnly = config.MetricsOnly
+	t.ProtectSwap = boolOrDefault(config.ProtectSwap, true)
+	t.ExcludeOpenDevices = boolOrDefault(config.ExcludeOpenDevices, true)
+	t.ExcludeStackMembers = boolOrDefault(config.ExcludeStackMembers, true)
+	t.HostMountInfo = config.HostMountInfo
+	t.RescanSCSI = config.RescanSCSI
+	t.ProtectedPaths = config.ProtectedPaths
+	if t.ProtectedPaths == nil {
+		t.ProtectedPaths = append([]string{}, defaultProtectedPaths...)
+	}
+	t.ExcludeUdevProperty = config.ExcludeUdevProperty
+	t.ExcludeUdevValue = config.ExcludeUdevValue
+	t.AllowlistPath = config.AllowlistPath
+	t.XattrTags = config.XattrTags
+	t.SizeHints = config.SizeHints
+	t.DanglingLinkGracePeriod = config.DanglingLinkGracePeriod
+	t.OrphanGCInterval = durationOrDefault(config.OrphanGCInterval, orphanGCInterval)
+	t.TriggerDebounce = durationOrDefault(config.TriggerDebounce, triggerDebounce)
+	t.FilterCommand = config.FilterCommand
+	t.FilterTimeout = durationOrDefault(config.FilterTimeout, defaultFilterTimeout)
+	t.ReconcileTimeout = config.ReconcileTimeout
+	t.SymlinkRetry = config.SymlinkRetry
+	if t.SymlinkRetry.Attempts == 0 {
+		t.SymlinkRetry = defaultSymlinkRetry
+	}
+	t.ResolveConcurrency = config.ResolveConcurrency
+	if t.ResolveConcurrency == 0 {
+		t.ResolveConcurrency = runtime.NumCPU()
+	}
+	t.DirUID = intOrDefault(config.DirUID, -1)
+	t.DirGID = intOrDefault(config.DirGID, -1)
+	t.DirMode = config.DirMode
+	if t.DirMode == 0 {
+		t.DirMode = defaultDirMode
+	}
+	t.FstabPath = config.FstabPath
+	if t.FstabPath == "" {
+		t.FstabPath = defaultFstabPath
+	}
+	t.LsblkPath = config.LsblkPath
+	if t.LsblkPath == "" {
+		t.LsblkPath = "lsblk"
+	}
+	t.LsblkExtraArgs = config.LsblkExtraArgs
+	t.ShadowMode = config.ShadowMode
+	t.ShadowLinkLocation = config.ShadowLinkLocation
+	t.ShadowReportPath = config.ShadowReportPath
+	t.ReleasedDevicesPath = config.ReleasedDevicesPath
+	t.LeasePath = config.LeasePath
+	t.WatchSymlinks = config.WatchSymlinks
+	t.SocketPath = config.SocketPath
+	t.NodeStatusClient = config.NodeStatusClient
+	t.NodeName = config.NodeName
+	t.NodeLabels = config.NodeLabels
+	t.OnReconcile = config.OnReconcile
+	t.Log = config.Log
+	if t.Log == nil {
+		t.Log = logrus.WithField("component", "diskmaker")
+	}
+	t.Recorder = config.Recorder
+	if t.Recorder == nil {
+		t.Recorder = logEventRecorder{t}
+	}
+	t.Tracer = config.Tracer
+	if t.Tracer == nil {
+		t.Tracer = noopTracer{}
+	}
+	t.trigger = make(chan struct{}, 1)
+	t.skipReasons = make(map[string]string)
+	t.mountedDevices = make(map[string]BlockDevice)
+	t.missingSince = make(map[string]time.Time)
+	t.drained = sets.NewString()
+	t.logThrottle = newLogThrottle(logThrottleInterval)
+	t.quarantine = newQuarantine(quarantineThreshold, quarantineCooldown)
+	return t
+}
+
+// durationOrDefault returns value, or def if it's zero. Negative values mean disabled
+// and are returned as zero.
+func durationOrDefault(value, def time.Duration) time.Duration {
+	if value == 0 {
+		return def
+	}
+	if value < 0 {
+		return 0
+	}
+	return value
+}
+
+func boolOrDefault(value *bool, def bool) bool {
+	if value == nil {
+		return def
+	}
+	return *value
+}
+
+func intOrDefault(value *int, def int) int {
+	if value == nil {
+		return def
+	}
+	return *value
+}
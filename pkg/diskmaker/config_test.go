@@ -0,0 +1,106 @@
+package diskmaker
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewDiskMakerWithConfigDefaults(t *testing.T) {
+	d, err := NewDiskMakerWithConfig(Config{ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if d.Interval != checkDuration || d.OrphanGCInterval != orphanGCInterval || d.TriggerDebounce != triggerDebounce {
+		t.Errorf("expected default intervals, got %v, %v and %v", d.Interval, d.OrphanGCInterval, d.TriggerDebounce)
+	}
+	if !d.ProtectSwap || !d.ExcludeOpenDevices || !d.ExcludeStackMembers {
+		t.Errorf("expected device protections to be enabled by default")
+	}
+	if d.DirUID != -1 || d.DirGID != -1 {
+		t.Errorf("expected directory owner to be left unchanged by default, got %d:%d", d.DirUID, d.DirGID)
+	}
+	if d.FstabPath != "/host/etc/fstab" {
+		t.Errorf("expected the host fstab by default, got %s", d.FstabPath)
+	}
+	if d.LsblkPath != "lsblk" || d.ResolveConcurrency != runtime.NumCPU() {
+		t.Errorf("unexpected lsblk path %s and resolve concurrency %d", d.LsblkPath, d.ResolveConcurrency)
+	}
+	if _, ok := d.runner.(execRunner); !ok {
+		t.Errorf("expected commands to run on the host by default, got %T", d.runner)
+	}
+	if source, ok := d.ConfigSource.(fileConfigSource); !ok || source.path != "/tmp/foo" {
+		t.Errorf("expected configuration to be read from /tmp/foo, got %v", d.ConfigSource)
+	}
+
+	// the old constructor gets the same defaults
+	old := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	if old.Interval != d.Interval || old.ProtectSwap != d.ProtectSwap || old.DirUID != d.DirUID || old.TriggerDebounce != d.TriggerDebounce {
+		t.Errorf("expected NewDiskMaker to apply the same defaults")
+	}
+}
+
+func TestNewDiskMakerWithConfigOverrides(t *testing.T) {
+	disabled := false
+	root := 0
+	runner := &fakeRunner{}
+	registry := prometheus.NewRegistry()
+	d, err := NewDiskMakerWithConfig(Config{
+		ConfigLocation:     "/tmp/foo",
+		SymlinkLocation:    "/mnt/local-storage",
+		Interval:           time.Minute,
+		ProtectSwap:        &disabled,
+		OrphanGCInterval:   -1,
+		DirUID:             &root,
+		ResolveConcurrency: 2,
+		Runner:             runner,
+		MetricsRegisterer:  registry,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if d.Interval != time.Minute || d.ProtectSwap || d.OrphanGCInterval != 0 || d.DirUID != 0 || d.DirGID != -1 || d.ResolveConcurrency != 2 {
+		t.Errorf("expected options of the config to be applied, got %+v", d)
+	}
+	if d.runner != runner {
+		t.Errorf("expected the runner of the config to be used")
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics %v", err)
+	}
+	if len(families) == 0 {
+		t.Errorf("expected metrics to be registered with the registerer of the config")
+	}
+
+	// registering the same metrics again is fine
+	_, err = NewDiskMakerWithConfig(Config{ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage", MetricsRegisterer: registry})
+	if err != nil {
+		t.Errorf("expected a second DiskMaker to share the registerer, got %v", err)
+	}
+}
+
+func TestNewDiskMakerWithConfigValidation(t *testing.T) {
+	tests := map[string]Config{
+		"no configuration":          {SymlinkLocation: "/mnt/local-storage"},
+		"two configurations":        {ConfigLocation: "/tmp/foo", ConfigSource: fileConfigSource{"/tmp/bar"}, SymlinkLocation: "/mnt/local-storage"},
+		"location and paths":        {ConfigLocation: "/tmp/foo", ConfigPaths: []string{"/tmp/bar"}, SymlinkLocation: "/mnt/local-storage"},
+		"relative symlink location": {ConfigLocation: "/tmp/foo", SymlinkLocation: "local-storage"},
+		"negative interval":         {ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage", Interval: -time.Second},
+		"negative concurrency":      {ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage", ResolveConcurrency: -1},
+		"udev property only":        {ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage", ExcludeUdevProperty: "RESERVED"},
+		"shadow without links":      {ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage", ShadowMode: true},
+		"status without node":       {ConfigLocation: "/tmp/foo", SymlinkLocation: "/mnt/local-storage", NodeStatusClient: &fakeNodeStatusClient{}},
+	}
+	for name, config := range tests {
+		d, err := NewDiskMakerWithConfig(config)
+		if err == nil || d != nil {
+			t.Errorf("%s: expected config to be invalid", name)
+		} else if !strings.HasPrefix(err.Error(), "invalid diskmaker config") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigSource provides the yaml or json configuration the DiskMaker reloads on every
+// reconcile
+type ConfigSource interface {
+	// Read returns the current configuration
+	Read() ([]byte, error)
+	// String describes the source in logs and events
+	String() string
+}
+
+// fileConfigSource reads the configuration from a file, such as a mounted ConfigMap
+type fileConfigSource struct {
+	path string
+}
+
+func (f fileConfigSource) Read() ([]byte, error) {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s with %v", f.path, err)
+	}
+	return content, nil
+}
+
+func (f fileConfigSource) String() string {
+	return f.path
+}
+
+// layeredConfigSource reads the configuration from several files, see NewLayeredConfigSource
+type layeredConfigSource struct {
+	paths []string
+}
+
+// NewLayeredConfigSource returns a ConfigSource layering the files at paths in order,
+// such as a base shared by all nodes followed by overlays. Every storageclass or node
+// setting defined in a file replaces the one of the same name in earlier files as a
+// whole, so the last file defining a storageclass wins and its fields are not merged
+// with those of earlier files. Entries only defined in earlier files are kept. Each
+// file is migrated from its own schemaVersion before layering.
+func NewLayeredConfigSource(paths ...string) ConfigSource {
+	return layeredConfigSource{paths}
+}
+
+func (l layeredConfigSource) Read() ([]byte, error) {
+	layered := make(map[string]json.RawMessage)
+	for _, configPath := range l.paths {
+		content, err := fileConfigSource{configPath}.Read()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := migratedEntries(content)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s with %v", configPath, err)
+		}
+		for key, entry := range entries {
+			layered[key] = entry
+		}
+	}
+	layered[schemaVersionKey] = json.RawMessage(strconv.Quote(currentSchemaVersion))
+	return json.Marshal(layered)
+}
+
+func (l layeredConfigSource) String() string {
+	return strings.Join(l.paths, ", ")
+}
+
+// HTTPConfigSource GETs the configuration from a central service. The last valid
+// configuration it fetched is returned when fetching fails or the service returns an
+// invalid configuration.
+type HTTPConfigSource struct {
+	URL string
+	// AuthHeader, if set, is sent as the Authorization header
+	AuthHeader string
+	// AuthHeaderFile, if set, is a file such as a mounted Secret holding the Authorization
+	// header instead of AuthHeader. It's read before every fetch, so that rotated
+	// credentials are used without restart.
+	AuthHeaderFile string
+	Client         *http.Client
+	Log            *logrus.Entry
+
+	lock     sync.Mutex
+	lastGood []byte
+	// fileAuthHeader is the header last read from AuthHeaderFile
+	fileAuthHeader string
+}
+
+// NewHTTPConfigSource returns a ConfigSource fetching the configuration from url
+func NewHTTPConfigSource(url, authHeader string) *HTTPConfigSource {
+	return &HTTPConfigSource{
+		URL:        url,
+		AuthHeader: authHeader,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		Log:        logrus.WithField("component", "diskmaker"),
+	}
+}
+
+func (h *HTTPConfigSource) Read() ([]byte, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	content, err := h.fetch()
+	if err == nil {
+		h.lastGood = content
+		return content, nil
+	}
+	if h.lastGood == nil {
+		return nil, err
+	}
+	h.Log.Warningf("%v, using the last configuration fetched", err)
+	return h.lastGood, nil
+}
+
+// fetch GETs and validates the configuration
+func (h *HTTPConfigSource) fetch() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		// parse errors quote the url, which may contain a password
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid config url %s: %v", h, err)
+	}
+	authHeader, err := h.authHeader()
+	if err != nil {
+		return nil, err
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s with %v", h, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", h, resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s with %v", h, err)
+	}
+	diskConfig, settings, err := parseConfig(content)
+	if err == nil {
+		err = validateConfig(diskConfig, settings)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration from %s: %v", h, err)
+	}
+	return content, nil
+}
+
+// authHeader returns the Authorization header to send, reading AuthHeaderFile if set.
+// The header itself is never logged.
+func (h *HTTPConfigSource) authHeader() (string, error) {
+	if h.AuthHeaderFile == "" {
+		return h.AuthHeader, nil
+	}
+	content, err := ioutil.ReadFile(h.AuthHeaderFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials file %s with %v", h.AuthHeaderFile, err)
+	}
+	authHeader := strings.TrimSpace(string(content))
+	if h.fileAuthHeader != "" && authHeader != h.fileAuthHeader {
+		h.Log.Infof("credentials in %s changed, using the new ones", h.AuthHeaderFile)
+	}
+	h.fileAuthHeader = authHeader
+	return authHeader, nil
+}
+
+// String returns the URL with any password in it redacted
+func (h *HTTPConfigSource) String() string {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return "<invalid url>"
+	}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	return u.String()
+}
@@ -0,0 +1,193 @@
+package diskmaker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHTTPConfigSource(t *testing.T) {
+	var lock sync.Mutex
+	response := "foo:\n  disks: [vdb]\n"
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+
+	d := NewDiskMaker("", filepath.Join(tmpDir, "local-storage"))
+	d.ConfigSource = NewHTTPConfigSource(server.URL, "Bearer secret")
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+	if claimed := d.Status().Claimed; len(claimed) != 1 || !equalStrings(claimed["foo"], []string{"vdb"}) {
+		t.Errorf("expected vdb to be claimed with the fetched config, got %v", claimed)
+	}
+
+	// neither a failure nor an invalid config replace the last good one
+	for _, failure := range []struct {
+		status   int
+		response string
+	}{
+		{http.StatusInternalServerError, ""},
+		{http.StatusOK, "foo:\n  disks: [vdc]\n  deviceNumbers: [vdc]\n"},
+	} {
+		lock.Lock()
+		status, response = failure.status, failure.response
+		lock.Unlock()
+		d.reconcile()
+		if errs := d.reconcileResult().Errors; len(errs) != 0 {
+			t.Errorf("expected the cached config to be used, got errors %v", errs)
+		}
+		if claimed := d.Status().Claimed; len(claimed) != 1 || !equalStrings(claimed["foo"], []string{"vdb"}) {
+			t.Errorf("expected vdb to stay claimed with the cached config, got %v", claimed)
+		}
+	}
+
+	// without a cached config, the failure is an error
+	source := NewHTTPConfigSource(server.URL, "")
+	if _, err := source.Read(); err == nil {
+		t.Errorf("expected unauthorized fetch to fail")
+	}
+}
+
+func TestHTTPConfigSourceCredentialsFile(t *testing.T) {
+	var lock sync.Mutex
+	token := "Bearer first-secret"
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("Authorization") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("foo:\n  disks: [vdb]\n"))
+	}))
+	defer server.Close()
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	credentialsFile := filepath.Join(tmpDir, "authorization")
+	if err := ioutil.WriteFile(credentialsFile, []byte("Bearer first-secret\n"), 0600); err != nil {
+		t.Fatalf("error writing credentials %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logs
+	logger.Level = logrus.DebugLevel
+	// the password of the url must not be logged either
+	source := NewHTTPConfigSource(strings.Replace(server.URL, "http://", "http://user:url-secret@", 1), "")
+	source.AuthHeaderFile = credentialsFile
+	source.Log = logrus.NewEntry(logger)
+	if _, err := source.Read(); err != nil {
+		t.Fatalf("expected the credentials of the file to be used, got %v", err)
+	}
+
+	// rotated credentials are picked up on the next fetch
+	lock.Lock()
+	token = "Bearer second-secret"
+	lock.Unlock()
+	if err := ioutil.WriteFile(credentialsFile, []byte("Bearer second-secret\n"), 0600); err != nil {
+		t.Fatalf("error writing credentials %v", err)
+	}
+	if _, err := source.Read(); err != nil {
+		t.Errorf("expected the rotated credentials to be used, got %v", err)
+	}
+	lock.Lock()
+	status = http.StatusInternalServerError
+	lock.Unlock()
+	if _, err := source.Read(); err != nil {
+		t.Errorf("expected the cached config to be used, got %v", err)
+	}
+
+	if !strings.Contains(logs.String(), "credentials in "+credentialsFile+" changed") {
+		t.Errorf("expected the rotation to be logged, got %q", logs.String())
+	}
+	for _, secret := range []string{"first-secret", "second-secret", "url-secret"} {
+		if strings.Contains(logs.String(), secret) || strings.Contains(source.String(), secret) {
+			t.Errorf("expected %s not to be logged, got %q", secret, logs.String())
+		}
+	}
+
+	// a missing credentials file fails the fetch without a cached config
+	source = NewHTTPConfigSource(server.URL, "")
+	source.AuthHeaderFile = filepath.Join(tmpDir, "missing")
+	if _, err := source.Read(); err == nil {
+		t.Errorf("expected fetching without credentials file to fail")
+	}
+}
+
+func TestLayeredConfigSource(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	files := map[string]string{
+		"base":    "maxTotalSize: 100Gi\nfoo:\n  disks: [vdb]\n  minQueueDepth: 32\nbar:\n  disks: [vdc]\n",
+		"overlay": "schemaVersion: v1\nfoo:\n  disks: [vdd]\nbaz:\n  disks: [vde]\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing %s %v", name, err)
+		}
+	}
+
+	d, err := NewDiskMakerWithConfig(Config{
+		ConfigPaths:     []string{filepath.Join(tmpDir, "base"), filepath.Join(tmpDir, "overlay")},
+		SymlinkLocation: filepath.Join(tmpDir, "local-storage"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	diskConfig, settings, err := d.loadConfig()
+	if err != nil {
+		t.Fatalf("error loading layered config %v", err)
+	}
+	// the overlay replaces foo as a whole, without the minQueueDepth of the base
+	if foo := diskConfig["foo"]; foo == nil || !equalStrings(foo.DiskNames, []string{"vdd"}) || foo.MinQueueDepth != 0 {
+		t.Errorf("expected foo of the overlay, got %+v", foo)
+	}
+	// storageclasses and settings only in the base pass through
+	if bar := diskConfig["bar"]; bar == nil || !equalStrings(bar.DiskNames, []string{"vdc"}) {
+		t.Errorf("expected bar of the base, got %+v", bar)
+	}
+	if baz := diskConfig["baz"]; baz == nil || !equalStrings(baz.DiskNames, []string{"vde"}) {
+		t.Errorf("expected baz of the overlay, got %+v", baz)
+	}
+	if settings.MaxTotalSize == nil || settings.MaxTotalSize.String() != "100Gi" {
+		t.Errorf("expected maxTotalSize of the base, got %v", settings.MaxTotalSize)
+	}
+
+	// a missing layer fails the whole configuration
+	d.ConfigSource = NewLayeredConfigSource(filepath.Join(tmpDir, "base"), filepath.Join(tmpDir, "missing"))
+	if _, _, err := d.loadConfig(); err == nil {
+		t.Errorf("expected an error for a missing layer")
+	}
+}
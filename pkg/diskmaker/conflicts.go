@@ -0,0 +1,76 @@
+package diskmaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resolveConflicts leaves every device matched by several storageclasses in at most one
+// of them, according to NodeSettings.ConflictPolicy. Symlinking a device for several
+// storageclasses would let their PVs overwrite each other's data.
+func (d *DiskMaker) resolveConflicts(diskConfig DiskConfig, deviceMap map[string][]DiskLocation) {
+	deviceClasses := make(map[string][]string)
+	for storageClass, deviceArray := range deviceMap {
+		for _, deviceLocation := range deviceArray {
+			deviceClasses[deviceLocation.diskName] = append(deviceClasses[deviceLocation.diskName], storageClass)
+		}
+	}
+	for diskName, storageClasses := range deviceClasses {
+		if len(storageClasses) < 2 {
+			continue
+		}
+		sort.Strings(storageClasses)
+		winner := ""
+		switch d.settings.ConflictPolicy {
+		case ConflictFirstWins:
+			winner = storageClasses[0]
+		case ConflictPriority:
+			winner = highestPriority(diskConfig, storageClasses)
+		}
+		if winner == "" {
+			d.throttledErrorf("conflict/"+diskName, "not symlinking device %s, it matches storageclasses %s", diskName, strings.Join(storageClasses, ", "))
+			d.skipDevice(diskName, skipConflict, fmt.Sprintf("matches %s", strings.Join(storageClasses, ", ")))
+		} else {
+			d.Log.Infof("device %s matches storageclasses %s, symlinking it for %s", diskName, strings.Join(storageClasses, ", "), winner)
+		}
+		for _, storageClass := range storageClasses {
+			if storageClass != winner {
+				deviceMap[storageClass] = removeLocation(deviceMap[storageClass], diskName)
+			}
+		}
+	}
+	for storageClass, deviceArray := range deviceMap {
+		if len(deviceArray) == 0 {
+			delete(deviceMap, storageClass)
+		}
+	}
+}
+
+// highestPriority returns the storageclass with highest priority, or "" if several share it
+func highestPriority(diskConfig DiskConfig, storageClasses []string) string {
+	winner := ""
+	tie := false
+	for _, storageClass := range storageClasses {
+		if winner == "" || diskConfig[storageClass].Priority > diskConfig[winner].Priority {
+			winner = storageClass
+			tie = false
+		} else if diskConfig[storageClass].Priority == diskConfig[winner].Priority {
+			tie = true
+		}
+	}
+	if tie {
+		return ""
+	}
+	return winner
+}
+
+func removeLocation(deviceArray []DiskLocation, diskName string) []DiskLocation {
+	kept := []DiskLocation{}
+	for _, deviceLocation := range deviceArray {
+		if deviceLocation.diskName != diskName {
+			kept = append(kept, deviceLocation)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,61 @@
+package diskmaker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		fast     int
+		slow     int
+		expected string
+	}{
+		{"", 0, 0, ""},
+		{ConflictError, 0, 0, ""},
+		{ConflictFirstWins, 0, 0, "fast"},
+		{ConflictPriority, 1, 10, "slow"},
+		{ConflictPriority, 10, 1, "fast"},
+		{ConflictPriority, 5, 5, ""},
+	}
+	for _, test := range tests {
+		d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+		deviceSet, err := d.findNewDisks(getData())
+		if err != nil {
+			t.Fatalf("error getting data %v", err)
+		}
+		d.settings = NodeSettings{ConflictPolicy: test.policy}
+		diskConfig := DiskConfig{
+			"fast": &Disks{DiskNames: []string{"vdb", "vdc"}, Priority: test.fast},
+			"slow": &Disks{DiskNames: []string{"vdc", "vdd"}, Priority: test.slow},
+		}
+		deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+		if err != nil {
+			t.Fatalf("error finding matching device %v", err)
+		}
+
+		// vdb and vdd match a single storageclass and are not affected
+		if !hasDevice(deviceMap["fast"], "vdb") || !hasDevice(deviceMap["slow"], "vdd") {
+			t.Errorf("policy %q: expected uncontested devices to be kept, got %+v", test.policy, deviceMap)
+		}
+		for _, storageClass := range []string{"fast", "slow"} {
+			if hasDevice(deviceMap[storageClass], "vdc") != (storageClass == test.expected) {
+				t.Errorf("policy %q: expected vdc to go to %q, got %+v", test.policy, test.expected, deviceMap)
+			}
+		}
+		if test.expected == "" && !strings.HasPrefix(d.skipReasons["vdc"], skipConflict) {
+			t.Errorf("policy %q: expected vdc to be skipped for conflict, got %q", test.policy, d.skipReasons["vdc"])
+		}
+	}
+}
+
+func hasDevice(deviceArray []DiskLocation, diskName string) bool {
+	for _, deviceLocation := range deviceArray {
+		if deviceLocation.diskName == diskName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package diskmaker
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// dirTemplateData are the fields of a device available to Disks.DirTemplate
+type dirTemplateData struct {
+	Class     string
+	NUMA      string
+	Transport string
+	Model     string
+}
+
+// renderDirTemplate returns the directory of a symlink within its storageclass directory
+// rendered from a Disks.DirTemplate, or an error if it points outside of it
+func renderDirTemplate(text string, data dirTemplateData) (string, error) {
+	tmpl, err := template.New("dirTemplate").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid dirTemplate %q: %v", text, err)
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("error rendering dirTemplate %q with %v", text, err)
+	}
+	dir := path.Clean(out.String())
+	if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", fmt.Errorf("dirTemplate %q renders %q outside of the storageclass directory", text, out.String())
+	}
+	if dir == "." {
+		return "", nil
+	}
+	return dir, nil
+}
+
+// dirTemplateSubDir renders the Disks.DirTemplate of a device matched by storageClass.
+// Values of the device are sanitized like symlink names, so that they can't add
+// directory levels, and are "unknown" if lsblk doesn't report them.
+func (d *DiskMaker) dirTemplateSubDir(storageClass string, disks *Disks, blockDevice BlockDevice) (string, error) {
+	data := dirTemplateData{
+		Class:     storageClass,
+		Transport: dirTemplateValue(blockDevice.Transport),
+		Model:     dirTemplateValue(blockDevice.Model),
+	}
+	// the NUMA node is only read from sysfs when needed
+	if strings.Contains(disks.DirTemplate, ".NUMA") {
+		data.NUMA = d.numaSubDir(blockDevice)
+	}
+	return renderDirTemplate(disks.DirTemplate, data)
+}
+
+func dirTemplateValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return unsafeLinkNameRegex.ReplaceAllString(value, "_")
+}
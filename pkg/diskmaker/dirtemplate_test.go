@@ -0,0 +1,62 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirTemplate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	config := "foo:\n  disks: [vdb, vdc, vdd]\n  dirTemplate: \"{{.Class}}-{{.Model}}\"\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	for linkName, diskName := range map[string]string{"foo-unknown/vdb": "vdb", "foo-FastSSD/vdc": "vdc", "foo-FastSSD/vdd": "vdd"} {
+		target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", linkName))
+		if err != nil {
+			t.Errorf("expected symlink foo/%s, got %v", linkName, err)
+			continue
+		}
+		if target != filepath.Join(tmpDir, "by-id", "virtio-"+diskName) {
+			t.Errorf("expected foo/%s to point to %s, got %s", linkName, diskName, target)
+		}
+	}
+}
+
+func TestDirTemplateTraversal(t *testing.T) {
+	for _, dirTemplate := range []string{"../{{.Model}}", "/{{.Class}}", "{{.Model}}/../..", "{{.Unknown}}", "{{.Model"} {
+		diskConfig := DiskConfig{"foo": &Disks{DiskNames: []string{"vdb"}, DirTemplate: dirTemplate}}
+		if err := diskConfig.validate(); err == nil {
+			t.Errorf("expected dirTemplate %q to be invalid", dirTemplate)
+		}
+	}
+	if err := (DiskConfig{"foo": &Disks{DirTemplate: "{{.NUMA}}", GroupByNUMA: true}}).validate(); err == nil {
+		t.Errorf("expected dirTemplate and groupByNUMA to be mutually exclusive")
+	}
+
+	// values of devices can't leave the storageclass directory either
+	for _, model := range []string{"..", "../../etc"} {
+		subDir, err := renderDirTemplate("{{.Model}}", dirTemplateData{Model: dirTemplateValue(model)})
+		if err == nil && (subDir == ".." || filepath.IsAbs(subDir) || filepath.Dir(subDir) != ".") {
+			t.Errorf("expected model %q not to traverse directories, got %q", model, subDir)
+		}
+	}
+	if _, err := renderDirTemplate("{{.Model}}", dirTemplateData{Model: ".."}); err == nil {
+		t.Errorf("expected rendering .. to be rejected")
+	}
+}
@@ -0,0 +1,66 @@
+package diskmaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// DiscoveryResult is the outcome of discovering devices without symlinking them, see
+// DiskMaker.Discover
+type DiscoveryResult struct {
+	// Devices are all block devices listed by lsblk
+	Devices []BlockDevice `json:"devices"`
+	// Matched maps storageclass names to the devices that would be symlinked for them
+	Matched map[string][]MatchedDevice `json:"matched"`
+	// SkipReasons maps names of devices that would not be symlinked to the reason why
+	SkipReasons map[string]string `json:"skipReasons"`
+	// Errors are the problems discovery ran into
+	Errors []string `json:"errors,omitempty"`
+}
+
+// MatchedDevice is a device matched to a storageclass
+type MatchedDevice struct {
+	Name string `json:"name"`
+	// ID is the stable path the device would be symlinked through, if any
+	ID string `json:"id,omitempty"`
+	// Symlink is the path of the symlink relative to the storageclass directory
+	Symlink string `json:"symlink"`
+}
+
+// Discover runs discovery once with the current configuration and returns every
+// device with the decision taken for it. It creates no symlinks.
+func (d *DiskMaker) Discover() DiscoveryResult {
+	d.skipReasons = make(map[string]string)
+	d.mountedDevices = make(map[string]BlockDevice)
+	d.fstab = nil
+	d.filterDecisions = nil
+	d.reconcileErrors = nil
+	result := DiscoveryResult{Matched: make(map[string][]MatchedDevice)}
+	diskConfig, settings, err := d.loadConfig()
+	if err != nil {
+		d.reconcileErrorf("error loading configuration with %v", err)
+	} else {
+		d.diskConfig = diskConfig
+		d.settings = settings
+		devices, deviceMap, _ := d.discoverDisks(context.Background(), diskConfig)
+		result.Devices = devices
+		for storageClass, deviceArray := range deviceMap {
+			for _, deviceLocation := range deviceArray {
+				result.Matched[storageClass] = append(result.Matched[storageClass], MatchedDevice{
+					Name:    deviceLocation.diskName,
+					ID:      deviceLocation.diskID,
+					Symlink: deviceLocation.symlinkName(),
+				})
+			}
+			sort.Slice(result.Matched[storageClass], func(i, j int) bool {
+				return result.Matched[storageClass][i].Name < result.Matched[storageClass][j].Name
+			})
+		}
+	}
+	result.SkipReasons = d.skipReasons
+	for _, err := range d.reconcileErrors {
+		result.Errors = append(result.Errors, fmt.Sprintf("%v", err))
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoverJSON(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+
+	content, err := json.Marshal(d.Discover())
+	if err != nil {
+		t.Fatalf("error marshalling discovery result %v", err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	for _, field := range []string{"devices", "matched", "skipReasons"} {
+		if _, ok := result[field]; !ok {
+			t.Errorf("expected field %s in %s", field, content)
+		}
+	}
+	if devices := result["devices"].([]interface{}); len(devices) != 10 {
+		t.Errorf("expected all 10 devices, got %d", len(devices))
+	}
+	matched := result["matched"].(map[string]interface{})["foo"].([]interface{})
+	if len(matched) != 2 {
+		t.Fatalf("expected vdb and vdc to be matched, got %v", matched)
+	}
+	vdb := matched[0].(map[string]interface{})
+	if vdb["name"] != "vdb" || vdb["id"] != filepath.Join(tmpDir, "by-id", "virtio-vdb") || vdb["symlink"] != "vdb" {
+		t.Errorf("expected vdb to be matched by id, got %v", vdb)
+	}
+	skipReasons := result["skipReasons"].(map[string]interface{})
+	if skipReasons["vdd"] != skipNoMatch || skipReasons["sda1"] != skipMounted+": /boot" {
+		t.Errorf("expected skip reasons of vdd and sda1, got %v", skipReasons)
+	}
+	if _, err := os.Stat(symlinkLocation); !os.IsNotExist(err) {
+		t.Errorf("expected discovery to create no symlinks, got %v", err)
+	}
+}
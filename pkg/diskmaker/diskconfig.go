@@ -1,21 +1,393 @@
 package diskmaker
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 
 	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 // Disks defines disks to be used for local volumes
 type Disks struct {
 	DiskNames []string `json:"disks,omitempty"`
+	// DeviceIDs matches devices by their /dev/disk/by-id entries, which may be glob
+	// patterns. Unlike the other criteria it matches mounted devices too.
 	DeviceIDs []string `json:"deviceIDs,omitempty"`
+	// DeviceNumbers matches devices by their major:minor numbers, such as 8:16
+	DeviceNumbers []string `json:"deviceNumbers,omitempty"`
+	// MinQueueDepth excludes devices whose queue depth (nr_requests in sysfs) is lower
+	MinQueueDepth int `json:"minQueueDepth,omitempty"`
+	// Transports excludes devices whose transport reported by lsblk, such as sata,
+	// sas, nvme or usb, is not listed. Devices with unknown transport are excluded too.
+	Transports []string `json:"transports,omitempty"`
+	// FSLabels matches formatted devices by their filesystem label
+	FSLabels []string `json:"fsLabels,omitempty"`
+	// HCTL matches SCSI devices by their host:channel:target:lun address, such as 0:0:1:0.
+	// Matched devices are symlinked through /dev/disk/by-path when available.
+	HCTL []string `json:"hctl,omitempty"`
+	// AllowFormatted allows symlinking devices that already contain a filesystem.
+	// Such devices are skipped by default since they likely hold data.
+	AllowFormatted bool `json:"allowFormatted,omitempty"`
+	// AllowPartitioned allows symlinking disks that have a partition table, which are
+	// skipped by default even if none of their partitions is mounted, as they likely
+	// hold data. Disks partitioned by AutoPartition are handled by it instead.
+	AllowPartitioned bool `json:"allowPartitioned,omitempty"`
+	// MatchExpression selects devices using nested and/or combinations of criteria.
+	// Devices it matches are added to those matched by the fields above.
+	MatchExpression *MatchExpression `json:"matchExpression,omitempty"`
+	// Enabled pauses claiming of new devices for the storageclass when false.
+	// Existing symlinks are kept unless RemoveOnDisable is set. Defaults to true.
+	Enabled         *bool `json:"enabled,omitempty"`
+	RemoveOnDisable bool  `json:"removeOnDisable,omitempty"`
+	// KeepDanglingLinks keeps symlinks of the storageclass whose devices disappeared,
+	// for provisioners that expect them to survive brief outages of flapping devices
+	KeepDanglingLinks bool `json:"keepDanglingLinks,omitempty"`
+	// NameByStableID names symlinks after the stable id of devices instead of their
+	// kernel name, so that a different disk reusing a name never gets the same symlink.
+	// Devices without a /dev/disk/by-id entry are named after their wwid or serial in sysfs.
+	NameByStableID bool `json:"nameByStableID,omitempty"`
+	// NodeLabelSelector restricts the storageclass to nodes whose labels it selects, such
+	// as node-role=storage, see DiskMaker.NodeLabels. Devices are not claimed for it
+	// on other nodes.
+	NodeLabelSelector *metav1.LabelSelector `json:"nodeLabelSelector,omitempty"`
+	// Priority decides which storageclass gets a device matched by several of them
+	// when NodeSettings.ConflictPolicy is priority, higher wins
+	Priority int `json:"priority,omitempty"`
+	// GroupByNUMA places symlinks in numa<N> subdirectories after the NUMA node of
+	// their device, or numa-none if the device has no NUMA affinity
+	GroupByNUMA bool `json:"groupByNUMA,omitempty"`
+	// DirTemplate places symlinks in the directory it renders within the storageclass
+	// directory. It is a text/template with the fields .Class, .NUMA (as numa<N> or
+	// numa-none), .Transport and .Model of the device, such as "{{.Transport}}/{{.Model}}".
+	DirTemplate string `json:"dirTemplate,omitempty"`
+	// ClaimFraction, if set, claims only this fraction (0 to 1) of the matching devices,
+	// rounded up, for canary rollouts. Devices are chosen in the order set by OrderBy.
+	ClaimFraction float64 `json:"claimFraction,omitempty"`
+	// OrderBy is the order in which matching devices are chosen when not all of them
+	// are claimed, such as with ClaimFraction, see OrderByName
+	OrderBy string `json:"orderBy,omitempty"`
+	// SymlinkTarget selects what symlinks point at, see SymlinkTargetStableID
+	SymlinkTarget string `json:"symlinkTarget,omitempty"`
+	// ClaimMode selects how matching devices are claimed, see ClaimModeSymlink
+	ClaimMode string `json:"claimMode,omitempty"`
+	// AllowRemovable allows claiming removable media, which is excluded by default
+	AllowRemovable bool `json:"allowRemovable,omitempty"`
+	// Zoned selects zoned (SMR) drives when true and conventional drives when false.
+	// Zoned drives perform poorly under random writes, so they are excluded by default.
+	Zoned *bool `json:"zoned,omitempty"`
+	// AutoPartition creates a single GPT partition spanning matching disks that have no
+	// partition table and symlinks the partition instead of the disk. As this writes to
+	// the disks, it must be acknowledged by setting Force.
+	AutoPartition bool `json:"autoPartition,omitempty"`
+	Force         bool `json:"force,omitempty"`
+	// PartitionSymlinkTarget selects what symlinks of partitions created by AutoPartition
+	// point at, see PartitionSymlinkTargetStableID
+	PartitionSymlinkTarget string `json:"partitionSymlinkTarget,omitempty"`
+	// CollisionStrategy decides what happens to devices of the storageclass whose
+	// symlinks would have the same name, see CollisionSkip
+	CollisionStrategy string `json:"collisionStrategy,omitempty"`
+	// SkipUnhealthy keeps devices whose SMART health check reported by smartctl -H failed
+	// from being claimed. Devices whose health is unknown, for example because smartctl
+	// is not installed, are claimed.
+	SkipUnhealthy bool `json:"skipUnhealthy,omitempty"`
+	// SkipErroredDevices keeps devices with more than MaxIOErrors I/O errors counted in
+	// sysfs from being claimed, as they likely have bad sectors
+	SkipErroredDevices bool  `json:"skipErroredDevices,omitempty"`
+	MaxIOErrors        int64 `json:"maxIOErrors,omitempty"`
+	// RequireFSOptions and ExcludeFSOptions select formatted devices by the mount options
+	// of their filesystem in fstab, such as noatime. Devices need all required options
+	// and none of the excluded ones, devices without fstab entry have no options.
+	RequireFSOptions []string `json:"requireFSOptions,omitempty"`
+	ExcludeFSOptions []string `json:"excludeFSOptions,omitempty"`
 }
 
+// enabled returns whether devices should be claimed for the storageclass
+func (disks *Disks) enabled() bool {
+	return disks.Enabled == nil || *disks.Enabled
+}
+
+// MatchCriteria are the ways in which a device can be selected
+type MatchCriteria struct {
+	DiskNames     []string `json:"disks,omitempty"`
+	DeviceIDs     []string `json:"deviceIDs,omitempty"`
+	DeviceNumbers []string `json:"deviceNumbers,omitempty"`
+	FSLabels      []string `json:"fsLabels,omitempty"`
+	HCTL          []string `json:"hctl,omitempty"`
+	// Models matches the device model reported by lsblk, wildcards are allowed
+	Models []string `json:"models,omitempty"`
+	// MinSize and MaxSize match devices whose size is within the range
+	MinSize *resource.Quantity `json:"minSize,omitempty"`
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+}
+
+// MatchExpression is a tree of match criteria. A device matches an expression if it
+// matches all criteria set on it, all expressions in And and at least one in Or.
+// For example (model=X AND size>500Gi) OR name=sdb is expressed as:
+//
+//	or:
+//	- models: [X]
+//	  minSize: 500Gi
+//	- disks: [sdb]
+type MatchExpression struct {
+	MatchCriteria `json:",inline"`
+	And           []*MatchExpression `json:"and,omitempty"`
+	Or            []*MatchExpression `json:"or,omitempty"`
+}
+
+// criteria returns the flat match criteria of a storageclass, any of which selects a device
+func (disks *Disks) criteria() *MatchCriteria {
+	return &MatchCriteria{
+		DiskNames:     disks.DiskNames,
+		DeviceIDs:     disks.DeviceIDs,
+		DeviceNumbers: disks.DeviceNumbers,
+		FSLabels:      disks.FSLabels,
+		HCTL:          disks.HCTL,
+	}
+}
+
+var (
+	deviceNumberRegex = regexp.MustCompile(`^[0-9]+:[0-9]+$`)
+	hctlRegex         = regexp.MustCompile(`^[0-9]+:[0-9]+:[0-9]+:[0-9]+$`)
+)
+
 // DiskConfig stores a mapping between StorageClass Name and disks that the storageclass
 // will use on each matached node.
 type DiskConfig map[string]*Disks
 
+// What symlinks of partitions point at, see Disks.PartitionSymlinkTarget. With stable-id
+// (default) it's the stable id of their disk suffixed with -part<N> by udev, with raw
+// /dev/<partition> such as /dev/nvme0n1p1 and with by-partuuid the
+// /dev/disk/by-partuuid entry of the partition. Partitions without the selected link
+// are symlinked through /dev/<partition>.
+const (
+	PartitionSymlinkTargetStableID = "stable-id"
+	PartitionSymlinkTargetRaw      = "raw"
+	PartitionSymlinkTargetPartUUID = "by-partuuid"
+)
+
+// Policies deciding which devices are dropped first when NodeSettings.MaxTotalSize is exceeded
+const (
+	DropLargest  = "largest"
+	DropSmallest = "smallest"
+)
+
+// What symlinks of a storageclass point at, see Disks.SymlinkTarget. With stable-id
+// (default) it's the most stable reference available, usually in /dev/disk/by-id, with
+// raw always /dev/<name> and with by-path the /dev/disk/by-path entry of the device.
+// Devices without the selected link are symlinked through /dev/<name>.
+const (
+	SymlinkTargetStableID = "stable-id"
+	SymlinkTargetRaw      = "raw"
+	SymlinkTargetByPath   = "by-path"
+)
+
+// How devices of a storageclass are claimed, see Disks.ClaimMode. With symlink (default)
+// they are symlinked for provisioning, with marker a file describing each device is
+// written instead, to review the inventory before provisioning. Marked devices are not
+// claimed, switching modes removes the symlinks or markers of the other mode.
+const (
+	ClaimModeSymlink = "symlink"
+	ClaimModeMarker  = "marker"
+)
+
+// How devices of a storageclass whose symlinks would have the same name are handled,
+// see Disks.CollisionStrategy. Devices are taken in order of their names and the first
+// keeps the name. With skip (default) the others are not claimed, with suffix they are
+// named after it suffixed with -2, -3 and so on.
+const (
+	CollisionSkip   = "skip"
+	CollisionSuffix = "suffix"
+)
+
+// Orders of devices of a storageclass, see Disks.OrderBy. With name (default) devices
+// are ordered by stable id, or by name if they have none. With size-asc and size-desc
+// they are ordered by size, with serial by the serial number sysfs reports, devices
+// without serial last. Devices that tie are ordered by name.
+const (
+	OrderByName     = "name"
+	OrderBySizeAsc  = "size-asc"
+	OrderBySizeDesc = "size-desc"
+	OrderBySerial   = "serial"
+)
+
+// Policies resolving devices matched by several storageclasses, see NodeSettings.ConflictPolicy
+const (
+	ConflictError     = "error"
+	ConflictFirstWins = "first-wins"
+	ConflictPriority  = "priority"
+)
+
+// NodeSettings are node wide settings. They are given as top level keys of the
+// configuration next to storageclasses, storageclass names cannot collide with them
+// as they are never camelCase.
+type NodeSettings struct {
+	// SchemaVersion is the version of the configuration format, v1 if not set
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+	// MaxTotalSize caps the total size of devices claimed on the node
+	MaxTotalSize *resource.Quantity `json:"maxTotalSize,omitempty"`
+	// DropPolicy decides whether the largest (default) or smallest devices are left
+	// unclaimed first when MaxTotalSize would be exceeded
+	DropPolicy string `json:"dropPolicy,omitempty"`
+	// GlobalMinSize excludes devices smaller than it before any storageclass is matched
+	GlobalMinSize *resource.Quantity `json:"globalMinSize,omitempty"`
+	// ConflictPolicy decides what happens to a device matched by several storageclasses.
+	// With error (default) it is not claimed at all, with first-wins the storageclass
+	// first by name gets it and with priority the one with highest Priority.
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	// RefuseThinDevices keeps thin provisioned devices from being claimed, by default
+	// they are claimed with a warning
+	RefuseThinDevices bool `json:"refuseThinDevices,omitempty"`
+	// ScanPrefixes, if set, restricts discovery to devices whose names start with one
+	// of them, such as nvme, so that no work is spent on devices never claimed
+	ScanPrefixes []string `json:"scanPrefixes,omitempty"`
+	// PartialApply applies the valid storageclasses of a configuration in which others
+	// are invalid, which are reported in Status.InvalidClasses. By default a single
+	// invalid storageclass rejects the whole configuration.
+	PartialApply bool `json:"partialApply,omitempty"`
+	// CanonicalizeTargets points symlinks at the device node their target resolves to,
+	// such as /dev/sdb, instead of the stable reference selected by Disks.SymlinkTarget.
+	// Devices are still matched and tracked by their stable ids.
+	CanonicalizeTargets bool `json:"canonicalizeTargets,omitempty"`
+}
+
+func (s *NodeSettings) validate() error {
+	switch s.DropPolicy {
+	case "", DropLargest, DropSmallest:
+	default:
+		return fmt.Errorf("invalid dropPolicy %q, expected %s or %s", s.DropPolicy, DropLargest, DropSmallest)
+	}
+	switch s.ConflictPolicy {
+	case "", ConflictError, ConflictFirstWins, ConflictPriority:
+	default:
+		return fmt.Errorf("invalid conflictPolicy %q, expected %s, %s or %s", s.ConflictPolicy, ConflictError, ConflictFirstWins, ConflictPriority)
+	}
+	return nil
+}
+
+// nodeSettingKeys are the top level configuration keys of NodeSettings
+var nodeSettingKeys = jsonFieldNames(reflect.TypeOf(NodeSettings{}))
+
+func jsonFieldNames(t reflect.Type) sets.String {
+	names := sets.NewString()
+	for i := 0; i < t.NumField(); i++ {
+		names.Insert(strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return names
+}
+
+// currentSchemaVersion is the version of the configuration format parseConfig returns.
+// Older configurations are upgraded to it by schemaMigrations.
+const currentSchemaVersion = "v1"
+
+// schemaVersionKey is the entry of the configuration holding NodeSettings.SchemaVersion
+const schemaVersionKey = "schemaVersion"
+
+// schemaMigration upgrades the top level entries of a configuration to the next version
+type schemaMigration struct {
+	next    string
+	migrate func(entries map[string]json.RawMessage) error
+}
+
+// schemaMigrations are keyed by the version they upgrade from
+var schemaMigrations = map[string]schemaMigration{}
+
+// migrateConfig upgrades configuration entries of schemaVersion to currentSchemaVersion
+func migrateConfig(entries map[string]json.RawMessage, schemaVersion string) error {
+	if schemaVersion == "" {
+		schemaVersion = "v1"
+	}
+	for schemaVersion != currentSchemaVersion {
+		migration, ok := schemaMigrations[schemaVersion]
+		if !ok {
+			return fmt.Errorf("unsupported schemaVersion %q, expected %s or older", schemaVersion, currentSchemaVersion)
+		}
+		err := migration.migrate(entries)
+		if err != nil {
+			return fmt.Errorf("error migrating configuration from %s to %s: %v", schemaVersion, migration.next, err)
+		}
+		schemaVersion = migration.next
+	}
+	return nil
+}
+
+// migratedEntries parses the top level entries of yaml configuration, that is its
+// storageclasses and node settings, migrated to currentSchemaVersion
+func migratedEntries(content []byte) (map[string]json.RawMessage, error) {
+	jsonContent, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]json.RawMessage)
+	err = json.Unmarshal(jsonContent, &entries)
+	if err != nil {
+		return nil, err
+	}
+	var version struct {
+		SchemaVersion string `json:"schemaVersion"`
+	}
+	err = json.Unmarshal(jsonContent, &version)
+	if err != nil {
+		return nil, err
+	}
+	err = migrateConfig(entries, version.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseConfig parses yaml configuration into storageclasses and node settings
+func parseConfig(content []byte) (DiskConfig, NodeSettings, error) {
+	diskConfig := DiskConfig{}
+	settings := NodeSettings{}
+	entries, err := migratedEntries(content)
+	if err != nil {
+		return nil, settings, err
+	}
+	jsonContent, err := json.Marshal(entries)
+	if err != nil {
+		return nil, settings, err
+	}
+	err = json.Unmarshal(jsonContent, &settings)
+	if err != nil {
+		return nil, settings, err
+	}
+	settings.SchemaVersion = currentSchemaVersion
+	for key, entry := range entries {
+		if nodeSettingKeys.Has(key) {
+			continue
+		}
+		var disks *Disks
+		err = json.Unmarshal(entry, &disks)
+		if err != nil {
+			return nil, settings, fmt.Errorf("storageclass %s: %v", key, err)
+		}
+		diskConfig[key] = disks
+	}
+	return diskConfig, settings, nil
+}
+
+// configHash returns a digest of parsed configuration, which is the same for
+// configurations that differ only in formatting or order of storageclasses and fields
+func configHash(diskConfig DiskConfig, settings NodeSettings) (string, error) {
+	// maps are marshalled with sorted keys, so the encoding is stable
+	content, err := json.Marshal(struct {
+		StorageClasses DiskConfig
+		Settings       NodeSettings
+	}{diskConfig, settings})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling to json: %v", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content)), nil
+}
+
 // ToYAML returns yaml representation of diskconfig
 func (d *DiskConfig) ToYAML() (string, error) {
 	y, err := yaml.Marshal(d)
@@ -24,3 +396,170 @@ func (d *DiskConfig) ToYAML() (string, error) {
 	}
 	return string(y), nil
 }
+
+func (d DiskConfig) validate() error {
+	for storageClass, disks := range d {
+		if disks == nil {
+			continue
+		}
+		if err := disks.validate(); err != nil {
+			return fmt.Errorf("storageclass %s: %v", storageClass, err)
+		}
+	}
+	return nil
+}
+
+// invalidClasses returns validation errors of invalid storageclasses keyed by their name
+func (d DiskConfig) invalidClasses() map[string]error {
+	invalid := make(map[string]error)
+	for storageClass, disks := range d {
+		if disks == nil {
+			continue
+		}
+		if err := disks.validate(); err != nil {
+			invalid[storageClass] = err
+		}
+	}
+	return invalid
+}
+
+func (disks *Disks) validate() error {
+	err := disks.criteria().validate()
+	if err == nil && (disks.ClaimFraction < 0 || disks.ClaimFraction > 1) {
+		err = fmt.Errorf("invalid claimFraction %v, expected a value between 0 and 1", disks.ClaimFraction)
+	}
+	if err == nil {
+		switch disks.SymlinkTarget {
+		case "", SymlinkTargetStableID, SymlinkTargetRaw, SymlinkTargetByPath:
+		default:
+			err = fmt.Errorf("invalid symlinkTarget %q, expected %s, %s or %s", disks.SymlinkTarget, SymlinkTargetStableID, SymlinkTargetRaw, SymlinkTargetByPath)
+		}
+	}
+	if err == nil {
+		switch disks.ClaimMode {
+		case "", ClaimModeSymlink, ClaimModeMarker:
+		default:
+			err = fmt.Errorf("invalid claimMode %q, expected %s or %s", disks.ClaimMode, ClaimModeSymlink, ClaimModeMarker)
+		}
+	}
+	if err == nil {
+		switch disks.CollisionStrategy {
+		case "", CollisionSkip, CollisionSuffix:
+		default:
+			err = fmt.Errorf("invalid collisionStrategy %q, expected %s or %s", disks.CollisionStrategy, CollisionSkip, CollisionSuffix)
+		}
+	}
+	if err == nil {
+		switch disks.OrderBy {
+		case "", OrderByName, OrderBySizeAsc, OrderBySizeDesc, OrderBySerial:
+		default:
+			err = fmt.Errorf("invalid orderBy %q, expected %s, %s, %s or %s", disks.OrderBy, OrderByName, OrderBySizeAsc, OrderBySizeDesc, OrderBySerial)
+		}
+	}
+	if err == nil && disks.MaxIOErrors < 0 {
+		err = fmt.Errorf("invalid maxIOErrors %d, expected a value of at least 0", disks.MaxIOErrors)
+	}
+	if err == nil && disks.DirTemplate != "" {
+		if disks.GroupByNUMA {
+			err = fmt.Errorf("groupByNUMA and dirTemplate are mutually exclusive, use {{.NUMA}} in dirTemplate instead")
+		} else {
+			_, err = renderDirTemplate(disks.DirTemplate, dirTemplateData{Class: "class", NUMA: "numa0", Transport: "sata", Model: "model"})
+		}
+	}
+	if err == nil && disks.AutoPartition && !disks.Force {
+		err = fmt.Errorf("autoPartition erases matching disks and requires force to be set")
+	}
+	if err == nil && disks.AutoPartition && (disks.AllowFormatted || len(disks.FSLabels) > 0 || disks.MatchExpression.matchesFSLabels()) {
+		err = fmt.Errorf("autoPartition cannot be combined with allowFormatted or fsLabels, it never partitions formatted disks")
+	}
+	if err == nil && disks.PartitionSymlinkTarget != "" {
+		switch disks.PartitionSymlinkTarget {
+		case PartitionSymlinkTargetStableID, PartitionSymlinkTargetRaw, PartitionSymlinkTargetPartUUID:
+			if !disks.AutoPartition {
+				err = fmt.Errorf("partitionSymlinkTarget requires autoPartition to be set")
+			}
+		default:
+			err = fmt.Errorf("invalid partitionSymlinkTarget %q, expected %s, %s or %s", disks.PartitionSymlinkTarget, PartitionSymlinkTargetStableID, PartitionSymlinkTargetRaw, PartitionSymlinkTargetPartUUID)
+		}
+	}
+	if err == nil && disks.MatchExpression != nil {
+		err = disks.MatchExpression.validate()
+	}
+	if err == nil && disks.NodeLabelSelector != nil {
+		_, err = metav1.LabelSelectorAsSelector(disks.NodeLabelSelector)
+		if err != nil {
+			err = fmt.Errorf("invalid nodeLabelSelector with %v", err)
+		}
+	}
+	return err
+}
+
+// validateConfig validates parsed configuration. With NodeSettings.PartialApply
+// storageclasses are not validated, the invalid ones are dropped by loadConfig instead.
+func validateConfig(diskConfig DiskConfig, settings NodeSettings) error {
+	if !settings.PartialApply {
+		err := diskConfig.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return settings.validate()
+}
+
+func (c *MatchCriteria) validate() error {
+	for _, deviceNumber := range c.DeviceNumbers {
+		if !deviceNumberRegex.MatchString(deviceNumber) {
+			return fmt.Errorf("invalid device number %q, expected major:minor", deviceNumber)
+		}
+	}
+	for _, hctl := range c.HCTL {
+		if !hctlRegex.MatchString(hctl) {
+			return fmt.Errorf("invalid hctl %q, expected host:channel:target:lun", hctl)
+		}
+	}
+	return nil
+}
+
+func (e *MatchExpression) validate() error {
+	if err := e.MatchCriteria.validate(); err != nil {
+		return err
+	}
+	for _, expression := range append(append([]*MatchExpression{}, e.And...), e.Or...) {
+		if expression == nil {
+			return fmt.Errorf("empty match expression")
+		}
+		if err := expression.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesFSLabels returns whether fsLabels are set anywhere in the expression
+func (e *MatchExpression) matchesFSLabels() bool {
+	if e == nil {
+		return false
+	}
+	if len(e.FSLabels) > 0 {
+		return true
+	}
+	for _, expression := range append(append([]*MatchExpression{}, e.And...), e.Or...) {
+		if expression.matchesFSLabels() {
+			return true
+		}
+	}
+	return false
+}
+
+// scanned returns whether a device is discovered according to ScanPrefixes
+func (s *NodeSettings) scanned(diskName string) bool {
+	if len(s.ScanPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.ScanPrefixes {
+		if strings.HasPrefix(diskName, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestValidateDeviceNumbers(t *testing.T) {
+	tests := []struct {
+		deviceNumber string
+		valid        bool
+	}{
+		{"8:16", true},
+		{"259:0", true},
+		{"8", false},
+		{"8:", false},
+		{"sda", false},
+		{"8:16:1", false},
+	}
+	for _, test := range tests {
+		diskConfig := DiskConfig{"foo": &Disks{DeviceNumbers: []string{test.deviceNumber}}}
+		err := diskConfig.validate()
+		if test.valid && err != nil {
+			t.Errorf("expected %s to be valid, got %v", test.deviceNumber, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %s to be invalid", test.deviceNumber)
+		}
+	}
+}
+
+func TestValidateHCTL(t *testing.T) {
+	tests := []struct {
+		hctl  string
+		valid bool
+	}{
+		{"0:0:1:0", true},
+		{"12:0:3:255", true},
+		{"0:0:1", false},
+		{"0:0:1:0:0", false},
+		{"a:b:c:d", false},
+	}
+	for _, test := range tests {
+		diskConfig := DiskConfig{"foo": &Disks{HCTL: []string{test.hctl}}}
+		err := diskConfig.validate()
+		if test.valid && err != nil {
+			t.Errorf("expected hctl %q to be valid, got %v", test.hctl, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected hctl %q to be invalid", test.hctl)
+		}
+	}
+}
+
+func TestConfigChange(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	recorder := &fakeRecorder{}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.Recorder = recorder
+	d.ProtectSwap = false
+
+	configs := []string{
+		"foo:\n  disks: [vdb]\n  minQueueDepth: 32\nbar:\n  disks: [vdc]\n",
+		// same configuration with different formatting and ordering
+		"bar: {disks: [\"vdc\"]}\n\nfoo:\n    minQueueDepth: 32\n    disks:\n    - vdb\n",
+		"foo:\n  disks: [vdb, vdd]\n  minQueueDepth: 32\nbar:\n  disks: [vdc]\n",
+	}
+	// the first load counts as a reload as well
+	expectedReloads := []float64{1, 1, 2}
+	reloadsBefore := metricValue(t, configReloads).GetCounter().GetValue()
+	for i, config := range configs {
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("error writing config %v", err)
+		}
+		d.reconcile()
+		if reloads := metricValue(t, configReloads).GetCounter().GetValue() - reloadsBefore; reloads != expectedReloads[i] {
+			t.Errorf("expected %v reloads after config %d, got %v", expectedReloads[i], i, reloads)
+		}
+	}
+	if events := recorder.withReason(configChangedReason); len(events) != 1 || events[0] != "Normal ConfigChanged configuration "+configFile+" changed" {
+		t.Errorf("expected a single ConfigChanged event, got %v", recorder.events)
+	}
+	if timestamp := metricValue(t, configLastReload).GetGauge().GetValue(); timestamp < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("expected last reload timestamp to be recent, got %v", timestamp)
+	}
+}
+
+func metricValue(t *testing.T, metric prometheus.Metric) *dto.Metric {
+	value := &dto.Metric{}
+	if err := metric.Write(value); err != nil {
+		t.Fatalf("error reading metric %v", err)
+	}
+	return value
+}
+
+func TestParseNodeSettings(t *testing.T) {
+	diskConfig, settings, err := parseConfig([]byte("maxTotalSize: 1Ti\ndropPolicy: smallest\nfoo:\n  disks: [vdb]\n"))
+	if err != nil {
+		t.Fatalf("error parsing config %v", err)
+	}
+	if len(diskConfig) != 1 || diskConfig["foo"] == nil || !equalStrings(diskConfig["foo"].DiskNames, []string{"vdb"}) {
+		t.Errorf("expected only storageclass foo, got %v", diskConfig)
+	}
+	if settings.MaxTotalSize == nil || settings.MaxTotalSize.String() != "1Ti" || settings.DropPolicy != DropSmallest {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+	settings.DropPolicy = "random"
+	if settings.validate() == nil {
+		t.Errorf("expected invalid dropPolicy to fail validation")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		invalid bool
+	}{
+		{name: "implicit v1", config: "foo:\n  disks: [vdb]\n"},
+		{name: "explicit v1", config: "schemaVersion: v1\nfoo:\n  disks: [vdb]\n"},
+		{name: "unknown version", config: "schemaVersion: v2\nfoo:\n  disks: [vdb]\n", invalid: true},
+	}
+	for _, test := range tests {
+		diskConfig, settings, err := parseConfig([]byte(test.config))
+		if test.invalid {
+			if err == nil || !strings.Contains(err.Error(), `unsupported schemaVersion "v2"`) {
+				t.Errorf("%s: expected unsupported schemaVersion error, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error parsing config %v", test.name, err)
+			continue
+		}
+		if len(diskConfig) != 1 || diskConfig["foo"] == nil || !equalStrings(diskConfig["foo"].DiskNames, []string{"vdb"}) {
+			t.Errorf("%s: expected only storageclass foo, got %v", test.name, diskConfig)
+		}
+		if settings.SchemaVersion != currentSchemaVersion {
+			t.Errorf("%s: expected schemaVersion %s, got %q", test.name, currentSchemaVersion, settings.SchemaVersion)
+		}
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	// a hypothetical v0 listed disks under a storageclasses key
+	schemaMigrations["v0"] = schemaMigration{next: "v1", migrate: func(entries map[string]json.RawMessage) error {
+		storageClasses := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(entries["storageclasses"], &storageClasses); err != nil {
+			return err
+		}
+		delete(entries, "storageclasses")
+		for storageClass, disks := range storageClasses {
+			entries[storageClass] = disks
+		}
+		return nil
+	}}
+	defer delete(schemaMigrations, "v0")
+
+	diskConfig, _, err := parseConfig([]byte("schemaVersion: v0\nstorageclasses:\n  foo:\n    disks: [vdb]\n"))
+	if err != nil {
+		t.Fatalf("error parsing config %v", err)
+	}
+	if len(diskConfig) != 1 || diskConfig["foo"] == nil || !equalStrings(diskConfig["foo"].DiskNames, []string{"vdb"}) {
+		t.Errorf("expected storageclass foo to be migrated, got %v", diskConfig)
+	}
+}
+
+func TestPartialApply(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	config := "foo:\n  disks: [vdb]\nbar:\n  deviceNumbers: [vdc]\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+
+	// by default the invalid storageclass rejects the whole configuration
+	d.reconcile()
+	if claimed := d.Status().Claimed; len(claimed) != 0 {
+		t.Errorf("expected nothing to be claimed, got %v", claimed)
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte("partialApply: true\n"+config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	status := d.Status()
+	if claimed := status.Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected valid foo to claim vdb, got %v", status.Claimed)
+	}
+	if reason := status.InvalidClasses["bar"]; !strings.Contains(reason, "invalid device number") || len(status.InvalidClasses) != 1 {
+		t.Errorf("expected bar to be reported invalid, got %v", status.InvalidClasses)
+	}
+	if len(d.reconcileErrors) != 1 {
+		t.Errorf("expected the invalid storageclass to be reported as error, got %v", d.reconcileErrors)
+	}
+
+	// fixing the storageclass clears it from status
+	if err := ioutil.WriteFile(configFile, []byte("partialApply: true\nfoo:\n  disks: [vdb]\nbar:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	if status := d.Status(); len(status.InvalidClasses) != 0 || len(status.Claimed) != 2 {
+		t.Errorf("expected both storageclasses to be applied, got %+v", status)
+	}
+}
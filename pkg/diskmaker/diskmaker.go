@@ -1,18 +1,21 @@
 package diskmaker
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/ghodss/yaml"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
@@ -22,220 +25,1241 @@ import (
 
 var (
 	checkDuration = 5 * time.Second
-	diskByIDPath  = "/dev/disk/by-id/*"
+	// orphanGCInterval is the default interval for removing symlinks of vanished devices
+	orphanGCInterval = 5 * time.Minute
+	triggerDebounce  = 500 * time.Millisecond
+	diskByIDPath     = "/dev/disk/by-id/*"
+	diskByUUIDPath   = "/dev/disk/by-uuid"
+	diskByPathPath   = "/dev/disk/by-path/*"
+	geteuid          = os.Geteuid
 )
 
 type DiskMaker struct {
-	configLocation  string
 	symlinkLocation string
+	runner          CommandRunner
+	fs              FileSystem
+	// Interval is how often devices are reconciled
+	Interval time.Duration
+	// ConfigSource provides the configuration, by default the file passed to NewDiskMaker
+	ConfigSource ConfigSource
+	// MetricsOnly only exports metrics about the block devices of the node, such as how
+	// many are available for claiming, without loading configuration or claiming any
+	MetricsOnly bool
+	// ProtectSwap excludes active swap devices listed in /proc/swaps from being symlinked
+	ProtectSwap bool
+	// HostMountInfo excludes devices mounted on the host according to /proc/1/mountinfo,
+	// in addition to those mounted in the diskmaker's own mount namespace. It needs the
+	// diskmaker to run in the host PID namespace.
+	HostMountInfo bool
+	// RescanSCSI rescans all SCSI hosts before listing devices, so that LUNs added to
+	// a SAN are discovered without waiting for the kernel to notice them
+	RescanSCSI bool
+	// ProtectedPaths are paths such as /var/lib/kubelet whose devices are never claimed,
+	// together with the other partitions of their disks. They are paths of the host,
+	// resolved through /proc/1/mountinfo as the diskmaker runs in the host PID namespace.
+	ProtectedPaths []string
+	// ExcludeOpenDevices excludes devices some process has open, such as a formatting job.
+	// Only processes visible in /proc are seen, the pod must share the host's pid namespace.
+	ExcludeOpenDevices bool
+	// ExcludeStackMembers excludes devices that MD arrays, DRBD or device-mapper devices
+	// such as LVM are built on, even though they are not mounted
+	ExcludeStackMembers bool
+	// ExcludeUdevProperty, if set, excludes devices whose udev property of this name
+	// equals ExcludeUdevValue, such as a tag set by a udev rule to reserve disks
+	ExcludeUdevProperty string
+	ExcludeUdevValue    string
+	// DanglingLinkGracePeriod is how long a symlink whose device disappeared is kept,
+	// so that brief outages such as a SAN blip don't churn PVs
+	DanglingLinkGracePeriod time.Duration
+	// OrphanGCInterval is how often symlinks pointing to missing devices are removed.
+	// Zero disables the collector.
+	OrphanGCInterval time.Duration
+	// AllowlistPath is an optional node local file listing device names or ids that
+	// may be symlinked. Devices matched by config but not listed are skipped.
+	AllowlistPath string
+	// DirUID and DirGID set the owner of created storageclass directories.
+	// -1 leaves the respective id unchanged.
+	DirUID int
+	DirGID int
+	// DirMode is the mode of storageclass directories. It's restored with their owner
+	// on every reconcile if changed out of band.
+	DirMode os.FileMode
+	// Log is the logger of the DiskMaker, it is the place to add fields such as
+	// the node name to all log lines
+	Log *logrus.Entry
+	// Recorder receives events about devices, by default they are only logged
+	Recorder EventRecorder
+	// FstabPath is the fstab Disks.RequireFSOptions and Disks.ExcludeFSOptions are
+	// checked against, by default the one of the host mounted by the DaemonSet
+	FstabPath string
+	// LsblkPath is the lsblk binary to run, by default it is looked up in PATH.
+	// LsblkExtraArgs are appended to the arguments the DiskMaker passes to lsblk.
+	LsblkPath      string
+	LsblkExtraArgs []string
+	// ShadowMode only reports what would be claimed instead of creating symlinks, for
+	// comparison with another tool symlinking devices into ShadowLinkLocation. The
+	// report, a ShadowReport, is written to ShadowReportPath after every reconcile.
+	ShadowMode         bool
+	ShadowLinkLocation string
+	ShadowReportPath   string
+	// SymlinkRetry retries creating symlinks that failed, such as because their
+	// directory was only just created
+	SymlinkRetry RetryPolicy
+	// ResolveConcurrency is the number of /dev/disk/by-id entries resolved in parallel
+	ResolveConcurrency int
+	// XattrTags writes the metadata sidecar of every symlink and sets the storageclass,
+	// device id and size as user.diskmaker.* extended attributes on it, so that tools can
+	// read them without parsing the sidecar. Filesystems without xattr support only
+	// get the sidecar.
+	XattrTags bool
+	// SizeHints writes the metadata sidecar of every symlink, with the size of its
+	// device in bytes for provisioners that need the exact capacity
+	SizeHints bool
+	// ReleasedDevicesPath is an optional file persisting devices released by Release
+	ReleasedDevicesPath string
+	// Tracer traces every reconcile with a span and child spans for discovery, matching
+	// and symlinking, by default nothing is traced
+	Tracer Tracer
+	// NodeStatusClient, if set, reports the devices claimed per storageclass to the
+	// status of the custom resource named NodeName whenever they change
+	NodeStatusClient NodeStatusClient
+	NodeName         string
+	// NodeLabels returns the labels of the node for storageclasses with a
+	// Disks.NodeLabelSelector, which are skipped if it is not set
+	NodeLabels NodeLabelSource
+	// OnReconcile, if set, is called with the result at the end of every reconcile
+	OnReconcile func(result ReconcileResult)
+	// ReconcileTimeout, if set, aborts a reconcile taking longer, such as one stuck
+	// resolving links of a hung device. Commands it runs are killed, devices it did not
+	// get to keep their claims. Zero disables it.
+	ReconcileTimeout time.Duration
+	// LeasePath, if set, is a lock file shared with other diskmakers on the node, such as
+	// during a migration. Only the one holding the lock reconciles, the others skip
+	// reconciles until it is released.
+	LeasePath string
+	// TriggerDebounce is how long a reconcile requested by Trigger is delayed, so
+	// that triggers arriving in a burst result in a single reconcile. Zero disables it.
+	TriggerDebounce time.Duration
+	// WatchSymlinks watches symlinkLocation with inotify while running and triggers a
+	// reconcile as soon as the symlink of a claimed device is removed, so that it is
+	// re-created without waiting for the next interval
+	WatchSymlinks bool
+	// FilterCommand, if set, is an executable vetting every device matched by a
+	// storageclass, run as "FilterCommand <storageclass> /dev/<name>". Devices are only
+	// claimed if it exits with status 0 within FilterTimeout.
+	FilterCommand string
+	FilterTimeout time.Duration
+	// SocketPath, if set, is a unix socket serving the current Status as JSON to local
+	// agents while running. Clients send the line "status" and read one line of JSON.
+	SocketPath string
+	// events are published to consumers of Events, nil until it's called
+	events chan ReconcileEvent
+	// trigger requests a reconcile outside of the regular ticker interval
+	trigger chan struct{}
+
+	// lock protects state shared between Run and callers of DiskMaker methods
+	lock    sync.Mutex
+	running bool
+	// reconciling is set while a reconcile runs, reconcilePending when another one was
+	// requested meanwhile, see runReconcile
+	reconciling      bool
+	reconcilePending bool
+	paused           bool
+	status           Status
+	// missingSince maps dangling symlinks to when they were first seen dangling
+	missingSince map[string]time.Time
+	// released are devices excluded from claiming by Release, keyed by storageclass
+	released map[string]sets.String
+	// drained are storageclasses excluded from claiming by DrainClass
+	drained sets.String
+
+	logThrottle *logThrottle
+	quarantine  *quarantine
+
+	// skipReasons collects why devices were skipped during the current reconcile
+	skipReasons map[string]string
+	// mountedDevices collects devices skipped as mounted during the current reconcile,
+	// Disks.DeviceIDs match them anyway
+	mountedDevices map[string]BlockDevice
+	// fstab is read on demand by the current reconcile, see fstabOptions
+	fstab *fstabCache
+	// filterDecisions are the outcomes of FilterCommand in the current reconcile,
+	// keyed by storageclass/device
+	filterDecisions map[string]filterDecision
+	// devices are the block devices found by the current reconcile, see Status.Devices
+	devices map[string]Device
+	// reconcileErrors collects errors of the current reconcile
+	reconcileErrors []error
+	// reconcileCtx is done once the current reconcile is aborted, see run
+	reconcileCtx context.Context
+	// eventBatches collects per-device events of the current reconcile, see batchEvent
+	eventBatches []*eventBatch
+	// claimed are the devices symlinked by the previous reconcile, see recordHistory.
+	// It's only written under lock, as Release reads it.
+	claimed map[string][]DiskLocation
+	// claimsLoaded is set once claimed was seeded from the symlinks of a previous run
+	claimsLoaded bool
+	// diskConfig and settings are the configuration of the current reconcile
+	diskConfig DiskConfig
+	settings   NodeSettings
+	// invalidClasses maps storageclasses dropped from the configuration by
+	// NodeSettings.PartialApply to the reason why
+	invalidClasses map[string]string
+	// reportedClaimed are the devices last reported through NodeStatusClient
+	reportedClaimed map[string][]string
+	// lastConfigHash is the hash of the last loaded configuration
+	lastConfigHash string
+	// symlinkLocationID identifies symlinkLocation as of the last reconcile
+	symlinkLocationID fileID
 }
 
 type DiskLocation struct {
 	diskName string
 	diskID   string
+	// size of the device in bytes, 0 if unknown
+	size int64
+	// linkName is the name of the symlink if it's not named after the device, see symlinkName
+	linkName string
+	// subDir is the directory of the symlink within its storageclass directory, if any
+	subDir string
+	// raw symlinks the device through /dev/<name> even if it has a stable id
+	raw bool
+}
+
+// target returns the path the symlink of the device points at
+func (l DiskLocation) target() string {
+	if l.diskID == "" || l.raw {
+		return path.Join("/dev", l.diskName)
+	}
+	return l.diskID
+}
+
+// symlinkName returns the path of the symlink of the device relative to the directory
+// of its storageclass
+func (l DiskLocation) symlinkName() string {
+	if l.linkName != "" {
+		return path.Join(l.subDir, l.linkName)
+	}
+	return path.Join(l.subDir, l.diskName)
 }
 
-// DiskMaker returns a new instance of DiskMaker
+var unsafeLinkNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// stableLinkName returns a symlink name derived from a stable device path
+func stableLinkName(stableDeviceID string) string {
+	return unsafeLinkNameRegex.ReplaceAllString(filepath.Base(stableDeviceID), "_")
+}
+
+// DiskMaker returns a new instance of DiskMaker with default options, see
+// NewDiskMakerWithConfig for setting them up front
 func NewDiskMaker(configLocation, symLinkLocation string) *DiskMaker {
-	t := &DiskMaker{}
-	t.configLocation = configLocation
-	t.symlinkLocation = symLinkLocation
-	return t
+	return newDiskMaker(Config{ConfigLocation: configLocation, SymlinkLocation: symLinkLocation})
 }
 
-func (d *DiskMaker) loadConfig() (DiskConfig, error) {
-	var err error
-	content, err := ioutil.ReadFile(d.configLocation)
+// Trigger requests an immediate reload of configuration and reconcile of disks.
+// Multiple triggers received before the reconcile runs are coalesced into one.
+func (d *DiskMaker) Trigger() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (d *DiskMaker) loadConfig() (DiskConfig, NodeSettings, error) {
+	content, err := d.ConfigSource.Read()
+	if err != nil {
+		return nil, NodeSettings{}, err
+	}
+	diskConfig, settings, err := parseConfig(content)
+	if err != nil {
+		return nil, settings, fmt.Errorf("error unmarshalling %s with %v", d.ConfigSource, err)
+	}
+	err = validateConfig(diskConfig, settings)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file %s with %v", d.configLocation, err)
+		return nil, settings, fmt.Errorf("invalid configuration %s: %v", d.ConfigSource, err)
+	}
+	d.invalidClasses = make(map[string]string)
+	if settings.PartialApply {
+		for storageClass, err := range diskConfig.invalidClasses() {
+			d.reconcileErrorf("not applying invalid storageclass %s: %v", storageClass, err)
+			d.invalidClasses[storageClass] = err.Error()
+			delete(diskConfig, storageClass)
+		}
 	}
-	var diskConfig DiskConfig
-	err = yaml.Unmarshal(content, &diskConfig)
+	err = d.checkPrivileges(diskConfig)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling %s with %v", d.configLocation, err)
+		return nil, settings, fmt.Errorf("invalid configuration %s: %v", d.ConfigSource, err)
 	}
-	return diskConfig, nil
+	return diskConfig, settings, nil
 }
 
-// Run and create disk config
-func (d *DiskMaker) Run(stop <-chan struct{}) {
-	ticker := time.NewTicker(checkDuration)
-	defer ticker.Stop()
+// Run and create disk config. Run blocks until stop is closed and returns an error
+// if the DiskMaker is already running.
+func (d *DiskMaker) Run(stop <-chan struct{}) error {
+	d.lock.Lock()
+	if d.running {
+		d.lock.Unlock()
+		return fmt.Errorf("diskmaker is already running")
+	}
+	d.running = true
+	d.lock.Unlock()
+	// reconciles started by ticks run in the background and are waited for on exit
+	var ticks sync.WaitGroup
+	defer func() {
+		ticks.Wait()
+		d.lock.Lock()
+		d.running = false
+		d.lock.Unlock()
+	}()
 
-	err := os.MkdirAll(d.symlinkLocation, 0755)
+	err := d.checkPrivileges(d.startupConfig())
 	if err != nil {
-		logrus.Errorf("error creating local-storage directory %s with %v", d.symlinkLocation, err)
-		os.Exit(-1)
+		return err
+	}
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	if !d.MetricsOnly {
+		err := d.fs.MkdirAll(d.symlinkLocation, 0755)
+		if err != nil {
+			return fmt.Errorf("error creating local-storage directory %s with %v", d.symlinkLocation, err)
+		}
 	}
 
+	if d.SocketPath != "" {
+		listener, err := d.listenStatusSocket()
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go d.serveStatusSocket(listener)
+	}
+
+	if d.WatchSymlinks && !d.MetricsOnly {
+		watcher, err := d.newSymlinkWatcher()
+		if err != nil {
+			return err
+		}
+		defer watcher.close()
+		go watcher.run()
+	}
+
+	// SIGHUP forces an immediate config reload, like most daemons. It is handled from
+	// before the first reconcile, which would otherwise leave the default action of
+	// terminating the process in place while it runs.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// reconcile once right away instead of waiting for the first tick
+	d.runReconcile()
+
+	var gcTick <-chan time.Time
+	if d.OrphanGCInterval > 0 && !d.MetricsOnly {
+		gcTicker := time.NewTicker(d.OrphanGCInterval)
+		defer gcTicker.Stop()
+		gcTick = gcTicker.C
+	}
+
+	// debounce fires once TriggerDebounce passed since the first of pending triggers
+	var debounce <-chan time.Time
 	for {
 		select {
 		case <-ticker.C:
-			diskConfig, err := d.loadConfig()
-			if err != nil {
-				logrus.Errorf("error loading configuration with %v", err)
-				break
+			d.tick(&ticks)
+		case <-hup:
+			d.Log.Infof("received SIGHUP, reloading configuration")
+			d.Trigger()
+		case <-d.trigger:
+			if d.TriggerDebounce <= 0 {
+				d.runReconcile()
+			} else if debounce == nil {
+				debounce = time.After(d.TriggerDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			d.runReconcile()
+		case <-gcTick:
+			if !d.isPaused() {
+				d.collectOrphanedLinks()
 			}
-			d.symLinkDisks(diskConfig)
 		case <-stop:
-			logrus.Infof("exiting, received message on stop channel")
-			os.Exit(0)
+			d.Log.Infof("exiting, received message on stop channel")
+			return nil
 		}
 	}
 }
 
-func (d *DiskMaker) symLinkDisks(diskConfig DiskConfig) {
-	cmd := exec.Command("lsblk", "--list", "-o", "NAME,MOUNTPOINT", "--noheadings")
-	var out bytes.Buffer
-	var err error
-	cmd.Stdout = &out
-	err = cmd.Run()
-	if err != nil {
-		logrus.Errorf("error running lsblk %v", err)
+// Pause stops reconciling, and so any changes to symlinks, until Resume is called.
+// Run keeps running while paused.
+func (d *DiskMaker) Pause() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.paused = true
+}
+
+// Resume undoes Pause
+func (d *DiskMaker) Resume() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.paused = false
+}
+
+func (d *DiskMaker) isPaused() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.paused
+}
+
+// tick starts a reconcile in the background unless one is still running, in which case
+// the tick is skipped rather than queued, so that reconciles slowed down by stalling
+// filesystem operations don't pile up
+func (d *DiskMaker) tick(ticks *sync.WaitGroup) {
+	d.lock.Lock()
+	if d.reconciling {
+		d.lock.Unlock()
+		d.Log.Warningf("skipped tick, reconcile still running")
+		skippedTicks.Inc()
+		return
+	}
+	d.reconciling = true
+	d.lock.Unlock()
+	ticks.Add(1)
+	go func() {
+		defer ticks.Done()
+		d.reconcileUntilDone()
+	}()
+}
+
+// runReconcile runs reconcile unless another one is already running, in which case a
+// single follow-up reconcile runs once it finished, however many were requested
+func (d *DiskMaker) runReconcile() {
+	d.lock.Lock()
+	if d.reconciling {
+		d.reconcilePending = true
+		d.lock.Unlock()
 		return
 	}
-	deviceSet, err := d.findNewDisks(out.String())
+	d.reconciling = true
+	d.lock.Unlock()
+	d.reconcileUntilDone()
+}
+
+// collectOrphanedLinks runs removeOrphanedLinks unless a reconcile is running, in which
+// case collection waits for the next interval. Reconciles requested meanwhile run once
+// it finished, as both change symlinks and the configuration is replaced by reconcile.
+func (d *DiskMaker) collectOrphanedLinks() {
+	d.lock.Lock()
+	if d.reconciling {
+		d.lock.Unlock()
+		d.Log.Debugf("skipped orphaned symlink collection, reconcile still running")
+		return
+	}
+	d.reconciling = true
+	d.lock.Unlock()
+	d.removeOrphanedLinks()
+	d.lock.Lock()
+	pending := d.reconcilePending
+	d.reconcilePending = false
+	d.reconciling = pending
+	d.lock.Unlock()
+	if pending {
+		d.reconcileUntilDone()
+	}
+}
+
+// reconcileUntilDone reconciles until no follow-up reconcile is pending. The caller
+// must have set d.reconciling, which is cleared on return.
+func (d *DiskMaker) reconcileUntilDone() {
+	for {
+		d.reconcile()
+		d.lock.Lock()
+		if !d.reconcilePending {
+			d.reconciling = false
+			d.lock.Unlock()
+			return
+		}
+		d.reconcilePending = false
+		d.lock.Unlock()
+	}
+}
+
+// reconcile loads the current configuration and symlinks matching disks
+func (d *DiskMaker) reconcile() {
+	if d.isPaused() {
+		d.Log.Debugf("paused, skipping reconcile")
+		return
+	}
+	if d.LeasePath != "" {
+		release, acquired := d.acquireLease()
+		if !acquired {
+			return
+		}
+		defer release()
+	}
+	ctx := context.Background()
+	if d.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.ReconcileTimeout)
+		defer cancel()
+	}
+	ctx, span := d.Tracer.Start(ctx, "reconcile")
+	defer span.End()
+	d.reconcileCtx = ctx
+	defer func() { d.reconcileCtx = nil }()
+	d.skipReasons = make(map[string]string)
+	d.mountedDevices = make(map[string]BlockDevice)
+	d.fstab = nil
+	d.filterDecisions = nil
+	d.reconcileErrors = nil
+	d.devices = nil
+	if d.MetricsOnly {
+		d.takeInventory()
+	} else {
+		d.claimDevices(ctx)
+	}
+	d.flushEvents()
+	result := d.reconcileResult()
+	span.SetAttribute("skipped", len(result.SkipReasons))
+	span.SetAttribute("errors", len(result.Errors))
+	if d.OnReconcile != nil {
+		d.OnReconcile(result)
+	}
+}
+
+// claimDevices loads the configuration and claims the devices matching it
+func (d *DiskMaker) claimDevices(ctx context.Context) {
+	d.checkSymlinkLocation()
+	diskConfig, settings, err := d.loadConfig()
 	if err != nil {
-		logrus.Errorf("error unmrashalling json %v", err)
+		d.reconcileErrorf("error loading configuration with %v", err)
 		return
 	}
+	d.diskConfig = diskConfig
+	d.settings = settings
+	d.detectConfigChange(diskConfig)
+	if !d.claimsLoaded {
+		// devices symlinked before a restart are not claimed anew
+		d.claimsLoaded = true
+		if d.claimed == nil {
+			claimed := d.loadClaims()
+			d.lock.Lock()
+			d.claimed = claimed
+			d.lock.Unlock()
+		}
+	}
+	deviceMap := d.symLinkDisks(ctx, diskConfig)
+	if ctx.Err() != nil {
+		d.reconcileErrorf("reconcile aborted after %v: %v", d.ReconcileTimeout, ctx.Err())
+		reconcileTimeouts.Inc()
+		deviceMap = d.keepUnreachedClaims(deviceMap)
+	}
+	d.auditPermissions(deviceMap)
+	d.recordHistory(d.claimed, deviceMap)
+	d.lock.Lock()
+	d.claimed = deviceMap
+	d.lock.Unlock()
+	d.updateStatus(deviceMap)
+	d.reportNodeStatus(d.Status().Claimed)
+}
 
-	if len(deviceSet) == 0 {
-		logrus.Infof("unable to find any new disks")
+// keepUnreachedClaims adds the devices claimed before to the partial outcome of an
+// aborted reconcile, unless the reconcile got to symlink or skip them. Their symlinks
+// were left alone, so they remain claimed.
+func (d *DiskMaker) keepUnreachedClaims(deviceMap map[string][]DiskLocation) map[string][]DiskLocation {
+	merged := make(map[string][]DiskLocation, len(deviceMap))
+	for storageClass, deviceArray := range deviceMap {
+		merged[storageClass] = append([]DiskLocation{}, deviceArray...)
+	}
+	for storageClass, deviceArray := range d.claimed {
+		for _, deviceLocation := range deviceArray {
+			if _, skipped := d.skipReasons[deviceLocation.diskName]; skipped {
+				continue
+			}
+			reached := false
+			for _, linked := range merged[storageClass] {
+				if linked.diskName == deviceLocation.diskName {
+					reached = true
+					break
+				}
+			}
+			if !reached {
+				merged[storageClass] = append(merged[storageClass], deviceLocation)
+			}
+		}
+	}
+	return merged
+}
+
+// detectConfigChange reports when the loaded configuration differs from the previous one,
+// including the first configuration loaded.
+// Changes which do not affect the parsed configuration, such as formatting, are ignored.
+func (d *DiskMaker) detectConfigChange(diskConfig DiskConfig) {
+	hash, err := configHash(diskConfig, d.settings)
+	if err != nil {
+		d.Log.Errorf("error hashing configuration with %v", err)
 		return
 	}
+	if hash == d.lastConfigHash {
+		return
+	}
+	configReloads.Inc()
+	configLastReload.Set(float64(time.Now().Unix()))
+	if d.lastConfigHash != "" {
+		d.Recorder.Eventf(corev1.EventTypeNormal, configChangedReason, "configuration %s changed", d.ConfigSource)
+	}
+	d.lastConfigHash = hash
+}
+
+// findCandidateDisks lists block devices, returning all of them and the ones that may
+// be claimed, or false if listing failed
+func (d *DiskMaker) findCandidateDisks() ([]BlockDevice, map[string]BlockDevice, bool) {
+	if d.RescanSCSI {
+		d.rescanSCSI()
+	}
+	args := append([]string{"--pairs", "--bytes", "-o", lsblkColumns}, d.LsblkExtraArgs...)
+	out, err := d.run(d.LsblkPath, args...)
+	if err != nil {
+		d.reconcileErrorf("error running lsblk %v", err)
+		return nil, nil, false
+	}
+	deviceSet, err := d.findNewDisks(string(out))
+	if err != nil {
+		d.reconcileErrorf("error unmrashalling json %v", err)
+		return nil, nil, false
+	}
+	d.handleLostDevices(presentDeviceNames(string(out)))
+	d.excludeEmptyDevices(deviceSet)
+	err = d.excludeMountedDevices(deviceSet)
+	if err != nil {
+		d.reconcileErrorf("error finding mounted devices %v", err)
+		return nil, nil, false
+	}
+
+	allDevices := parseBlockDevices(string(out))
+	if !d.excludeProtectedDevices(deviceSet, allDevices) {
+		return nil, nil, false
+	}
+	// mounted devices may still be matched by DeviceIDs, so they are protected too,
+	// but they keep being reported as mounted
+	mountedReasons := make(map[string]string, len(d.mountedDevices))
+	for diskName := range d.mountedDevices {
+		mountedReasons[diskName] = d.skipReasons[diskName]
+	}
+	ok := d.excludeProtectedDevices(d.mountedDevices, allDevices)
+	for diskName, reason := range mountedReasons {
+		d.skipReasons[diskName] = reason
+	}
+	if !ok {
+		return nil, nil, false
+	}
+	duplicateIDs := d.findDuplicateIDs(allDevices)
+	d.excludeDuplicateIDs(deviceSet, duplicateIDs)
+	for diskName := range duplicateIDs {
+		delete(d.mountedDevices, diskName)
+	}
+
+	if d.ExcludeOpenDevices {
+		err = d.excludeOpenDevices(deviceSet, allDevices)
+		if err != nil {
+			d.reconcileErrorf("error finding open devices %v", err)
+			return nil, nil, false
+		}
+	}
+
+	if d.ExcludeStackMembers {
+		d.excludeStackMembers(deviceSet, allDevices)
+	}
+
+	if d.ExcludeUdevProperty != "" {
+		d.excludeUdevTagged(deviceSet)
+	}
+
+	if d.settings.GlobalMinSize != nil {
+		d.excludeSmallDevices(deviceSet)
+	}
+	d.recordInventory(allDevices, deviceSet)
+	return allDevices, deviceSet, true
+}
+
+// excludeProtectedDevices removes swap devices and the devices backing the container's
+// own storage and ProtectedPaths from deviceSet, returning false if they can't be found
+func (d *DiskMaker) excludeProtectedDevices(deviceSet map[string]BlockDevice, allDevices []BlockDevice) bool {
+	if d.ProtectSwap {
+		err := d.excludeSwapDevices(deviceSet, allDevices)
+		if err != nil {
+			d.reconcileErrorf("error finding swap devices %v", err)
+			return false
+		}
+	}
+	err := d.excludeOwnStorage(deviceSet, allDevices)
+	if err != nil {
+		d.reconcileErrorf("error finding devices backing own storage %v", err)
+		return false
+	}
+	if len(d.ProtectedPaths) > 0 {
+		err = d.excludeProtectedPaths(deviceSet, allDevices)
+		if err != nil {
+			d.reconcileErrorf("error finding devices backing protected paths %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// discoverDisks lists block devices and matches them to storageclasses, returning all
+// devices listed by lsblk and the matched ones, or false if discovery failed or found
+// no candidate devices. It creates no symlinks.
+func (d *DiskMaker) discoverDisks(ctx context.Context, diskConfig DiskConfig) ([]BlockDevice, map[string][]DiskLocation, bool) {
+	_, span := d.Tracer.Start(ctx, "discovery")
+	allDevices, deviceSet, ok := d.findCandidateDisks()
+	span.SetAttribute("devices", len(allDevices))
+	span.SetAttribute("candidates", len(deviceSet))
+	span.End()
+	if !ok || ctx.Err() != nil {
+		return nil, nil, false
+	}
+	if len(deviceSet) == 0 && len(d.mountedDevices) == 0 {
+		d.Log.Infof("unable to find any new disks")
+		return allDevices, nil, false
+	}
+
+	_, span = d.Tracer.Start(ctx, "matching")
+	defer span.End()
 
 	// read all available disks from /dev/disk/by-id/*
 	allDiskIds, err := filepath.Glob(diskByIDPath)
 	if err != nil {
-		logrus.Errorf("error listing disks in /dev/disk/by-id : %v", err)
-		return
+		d.reconcileErrorf("error listing disks in /dev/disk/by-id : %v", err)
+		return nil, nil, false
 	}
 
-	deviceMap, err := d.findMatchingDisks(diskConfig, deviceSet, allDiskIds)
+	// DeviceIDs match mounted devices too
+	matchable := make(map[string]BlockDevice, len(deviceSet)+len(d.mountedDevices))
+	for _, devices := range []map[string]BlockDevice{deviceSet, d.mountedDevices} {
+		for diskName, blockDevice := range devices {
+			matchable[diskName] = blockDevice
+		}
+	}
+	deviceMap, err := d.findMatchingDisks(ctx, diskConfig, matchable, allDiskIds)
+	if ctx.Err() != nil {
+		return nil, nil, false
+	}
 	if err != nil {
-		logrus.Errorf("error matching finding disks : %v", err)
-		return
+		d.reconcileErrorf("error matching finding disks : %v", err)
+		return nil, nil, false
 	}
 
+	allowlist, err := d.loadAllowlist()
+	if err != nil {
+		d.reconcileErrorf("error loading allowlist: %v", err)
+		return nil, nil, false
+	}
+	if allowlist != nil {
+		d.filterAllowlisted(deviceMap, allowlist)
+	}
+	d.checkThinDevices(deviceMap)
+	d.limitClaimFraction(diskConfig, deviceMap)
+	if d.settings.MaxTotalSize != nil {
+		d.limitTotalSize(deviceMap, matchable)
+	}
+	d.recordUnmatched(deviceSet, deviceMap)
+	span.SetAttribute("matched", countLocations(deviceMap))
+	return allDevices, deviceMap, true
+}
+
+// symLinkDisks symlinks disks matching diskConfig and returns them keyed by storageclass
+func (d *DiskMaker) symLinkDisks(ctx context.Context, diskConfig DiskConfig) map[string][]DiskLocation {
+	allDevices, deviceMap, ok := d.discoverDisks(ctx, diskConfig)
+	if !ok {
+		return nil
+	}
+
+	if d.ShadowMode {
+		err := d.writeShadowReport(deviceMap)
+		if err != nil {
+			d.reconcileErrorf("error writing shadow report: %v", err)
+		}
+		return nil
+	}
+
+	d.removeInactiveClassLinks(diskConfig)
+
 	if len(deviceMap) == 0 {
-		logrus.Errorf("unable to find any matching disks")
-		return
+		d.throttledErrorf("no-matching-disks", "unable to find any matching disks")
+		return deviceMap
 	}
 
+	_, span := d.Tracer.Start(ctx, "symlinking")
+	defer span.End()
+	linkedDeviceMap := make(map[string][]DiskLocation)
+	// devices of storageclasses in marker claim mode are not claimed, see marksDevices
+	markedDeviceMap := make(map[string][]DiskLocation)
 	for storageClass, deviceArray := range deviceMap {
 		for _, deviceNameLoction := range deviceArray {
-			symLinkDirPath := path.Join(d.symlinkLocation, storageClass)
-			err := os.MkdirAll(symLinkDirPath, 0755)
+			if ctx.Err() != nil {
+				return linkedDeviceMap
+			}
+			diskName := deviceNameLoction.diskName
+			if d.quarantine.isQuarantined(diskName) {
+				d.skipDevice(diskName, skipQuarantined, "")
+				continue
+			}
+			// marker classes never modify the device, they only record the claim
+			if diskConfig[storageClass].AutoPartition && !d.marksDevices(storageClass) {
+				var err error
+				deviceNameLoction, err = d.autoPartition(deviceNameLoction, allDevices)
+				if err == errPartitioned {
+					d.Log.Infof("not partitioning device %s for storageclass %s, it already has partitions", diskName, storageClass)
+					d.skipDevice(diskName, skipPartitioned, "")
+					continue
+				}
+				if err == errFormatted {
+					d.Log.Infof("not partitioning device %s for storageclass %s, it contains a filesystem", diskName, storageClass)
+					d.skipDevice(diskName, skipFormatted, "")
+					continue
+				}
+				if err != nil {
+					d.reconcileErrorf("error partitioning device %s for storageclass %s: %v", diskName, storageClass, err)
+					d.skipDevice(diskName, skipFailed, err.Error())
+					continue
+				}
+				if deviceNameLoction.diskName != diskName {
+					deviceNameLoction = d.partitionTarget(diskConfig[storageClass], deviceNameLoction, allDevices)
+				}
+				diskName = deviceNameLoction.diskName
+			}
+			if d.marksDevices(storageClass) {
+				err := d.writeMarker(storageClass, deviceNameLoction)
+				if err != nil {
+					d.reconcileErrorf("error marking device %s for storageclass %s: %v", diskName, storageClass, err)
+					d.skipDevice(diskName, skipFailed, err.Error())
+					continue
+				}
+				d.skipDevice(diskName, skipMarked, storageClass)
+				markedDeviceMap[storageClass] = append(markedDeviceMap[storageClass], deviceNameLoction)
+				continue
+			}
+			err := d.createSymlink(storageClass, deviceNameLoction)
 			if err != nil {
-				logrus.Errorf("error creating symlink directory %s with %v", symLinkDirPath, err)
+				d.reconcileErrorf("error symlinking device %s for storageclass %s: %v", diskName, storageClass, err)
+				if d.quarantine.recordFailure(diskName) {
+					d.Log.Warningf("quarantining device %s for %v after %d consecutive failures", diskName, d.quarantine.cooldown, d.quarantine.threshold)
+				}
+				d.skipDevice(diskName, skipFailed, err.Error())
 				continue
 			}
-			symLinkPath := path.Join(symLinkDirPath, deviceNameLoction.diskName)
-			var symLinkErr error
-			if deviceNameLoction.diskID != "" {
-				logrus.Infof("symlinking to %s to %s", deviceNameLoction.diskID, symLinkPath)
-				symLinkErr = os.Symlink(deviceNameLoction.diskID, symLinkPath)
-			} else {
-				devicePath := path.Join("/dev", deviceNameLoction.diskName)
-				logrus.Infof("symlinking to %s to %s", devicePath, symLinkPath)
-				symLinkErr = os.Symlink(devicePath, symLinkPath)
+			d.quarantine.recordSuccess(diskName)
+			err = d.writeMeta(storageClass, deviceNameLoction)
+			if err != nil {
+				d.reconcileErrorf("error writing metadata of device %s for storageclass %s: %v", diskName, storageClass, err)
 			}
+			linkedDeviceMap[storageClass] = append(linkedDeviceMap[storageClass], deviceNameLoction)
+		}
+	}
+	d.removeStaleMarkers(diskConfig, markedDeviceMap)
+	span.SetAttribute("linked", countLocations(linkedDeviceMap))
+	return linkedDeviceMap
+}
 
-			if symLinkErr != nil {
-				logrus.Errorf("error creating symlink %s with %v", symLinkPath, err)
-			}
+// createSymlink symlinks a device into the directory of storageClass. An existing
+// symlink to the same target is left alone.
+func (d *DiskMaker) createSymlink(storageClass string, deviceNameLoction DiskLocation) error {
+	symLinkDirPath := path.Join(d.symlinkLocation, storageClass)
+	err := d.fs.MkdirAll(symLinkDirPath, d.DirMode)
+	if err != nil {
+		return fmt.Errorf("error creating symlink directory %s with %v", symLinkDirPath, err)
+	}
+	d.chownDir(symLinkDirPath)
+	if deviceNameLoction.subDir != "" {
+		symLinkDirPath = path.Join(symLinkDirPath, deviceNameLoction.subDir)
+		err = d.fs.MkdirAll(symLinkDirPath, d.DirMode)
+		if err != nil {
+			return fmt.Errorf("error creating symlink directory %s with %v", symLinkDirPath, err)
+		}
+		d.chownDir(symLinkDirPath)
+	}
+	symLinkPath := path.Join(d.symlinkLocation, storageClass, deviceNameLoction.symlinkName())
+	target := deviceNameLoction.target()
+	if d.settings.CanonicalizeTargets {
+		canonicalTarget, err := d.fs.EvalSymlinks(target)
+		if err != nil {
+			return fmt.Errorf("error resolving symlink target %s with %v", target, err)
+		}
+		target = canonicalTarget
+	}
+
+	if _, err := d.fs.Lstat(symLinkPath); err == nil {
+		existingTarget, err := d.fs.Readlink(symLinkPath)
+		if err != nil {
+			return fmt.Errorf("%s already exists and is not a symlink", symLinkPath)
+		}
+		if existingTarget != target && !d.sameCanonicalTarget(existingTarget, target) {
+			return fmt.Errorf("%s already exists and points to %s instead of %s", symLinkPath, existingTarget, target)
 		}
+		return nil
 	}
 
+	d.Log.Infof("symlinking to %s to %s", target, symLinkPath)
+	// creating the symlink right after its directory may fail on some filesystems
+	err = d.SymlinkRetry.retry(func() error {
+		return d.fs.Symlink(target, symLinkPath)
+	}, func(err error) bool {
+		return !os.IsExist(err)
+	})
+	if err != nil {
+		return fmt.Errorf("error creating symlink %s with %v", symLinkPath, err)
+	}
+	return nil
 }
 
-func (d *DiskMaker) findMatchingDisks(diskConfig DiskConfig, deviceSet sets.String, allDiskIds []string) (map[string][]DiskLocation, error) {
+// sameCanonicalTarget returns whether the target of an existing symlink resolves to
+// canonicalTarget with NodeSettings.CanonicalizeTargets, so that symlinks created
+// before it was enabled are kept
+func (d *DiskMaker) sameCanonicalTarget(existingTarget, canonicalTarget string) bool {
+	if !d.settings.CanonicalizeTargets {
+		return false
+	}
+	resolved, err := d.fs.EvalSymlinks(existingTarget)
+	return err == nil && resolved == canonicalTarget
+}
+
+// chownDir sets the configured owner of a storageclass directory
+func (d *DiskMaker) chownDir(dirPath string) {
+	if d.DirUID == -1 && d.DirGID == -1 {
+		return
+	}
+	if geteuid() != 0 {
+		d.throttledWarningf("chown-not-root", "not changing owner of %s to %d:%d, diskmaker is not running as root", dirPath, d.DirUID, d.DirGID)
+		return
+	}
+	err := d.fs.Chown(dirPath, d.DirUID, d.DirGID)
+	if err != nil {
+		d.Log.Errorf("error changing owner of %s to %d:%d with %v", dirPath, d.DirUID, d.DirGID, err)
+	}
+}
+
+func (d *DiskMaker) findMatchingDisks(ctx context.Context, diskConfig DiskConfig, deviceSet map[string]BlockDevice, allDiskIds []string) (map[string][]DiskLocation, error) {
 	// blockDeviceMap is a map of storageclass and device locations
 	blockDeviceMap := make(map[string][]DiskLocation)
 
-	addDiskToMap := func(scName, stableDeviceID, diskName string) {
-		deviceArray, ok := blockDeviceMap[scName]
-		if !ok {
-			deviceArray = []DiskLocation{}
-		}
-		deviceArray = append(deviceArray, DiskLocation{diskName, stableDeviceID})
-		blockDeviceMap[scName] = deviceArray
+	byIDIndex, byIDDenied := d.buildByIDIndex(ctx, allDiskIds)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	matchCtx := &matchContext{
+		d:         d,
+		deviceSet: deviceSet,
+		byIDIndex: byIDIndex,
+	}
+	unselectedClasses := d.unselectedClasses(diskConfig)
+	diskNames := []string{}
+	devices := []BlockDevice{}
+	for diskName, blockDevice := range deviceSet {
+		diskNames = append(diskNames, diskName)
+		devices = append(devices, blockDevice)
 	}
+	sort.Strings(diskNames)
+
 	for storageClass, disks := range diskConfig {
-		// handle diskNames
-		for _, diskName := range disks.DiskNames {
-			if hasExactDisk(deviceSet, diskName) {
-				matchedDeviceID, err := d.findStableDeviceID(diskName, allDiskIds)
-				if err != nil {
-					logrus.Errorf("Unable to find disk ID %s for local pool %v", diskName, err)
-					addDiskToMap(storageClass, "", diskName)
+		matcher := matchCtx.newClassMatcher(storageClass, disks)
+		idMatcher := deviceIDMatcher{patterns: idPatterns(disks.DeviceIDs), byIDIndex: matchCtx.byIDIndex}
+		drained := d.isDrained(storageClass)
+		for _, diskName := range diskNames {
+			blockDevice := deviceSet[diskName]
+			if _, mounted := d.mountedDevices[diskName]; mounted {
+				// only DeviceIDs select mounted devices, as they always have
+				if !idMatcher.Matches(blockDevice) {
 					continue
 				}
-				addDiskToMap(storageClass, matchedDeviceID, diskName)
+			} else if !matcher.any.Matches(blockDevice) {
 				continue
 			}
-		}
-		// handle DeviceIDs
-		for _, deviceID := range disks.DeviceIDs {
-			matchedDeviceID, matchedDiskName, err := d.findDeviceByID(deviceID)
+			if !disks.enabled() {
+				d.skipDevice(diskName, skipDisabled, storageClass)
+				continue
+			}
+			if unselectedClasses.Has(storageClass) {
+				d.skipDevice(diskName, skipNodeLabels, storageClass)
+				continue
+			}
+			if drained {
+				d.skipDevice(diskName, skipDrained, storageClass)
+				continue
+			}
+			released, err := d.isReleased(storageClass, diskName)
 			if err != nil {
-				logrus.Errorf("unable to add disk-id %s to local disk pool %v", deviceID, err)
+				return nil, err
+			}
+			if released {
+				d.skipDevice(diskName, skipReleased, "")
 				continue
 			}
-			addDiskToMap(storageClass, matchedDeviceID, matchedDiskName)
+			if blockDevice.FSType != "" && !disks.AllowFormatted && !matcher.formatted.Matches(blockDevice) {
+				d.Log.Infof("not symlinking device %s for storageclass %s, it has a %s filesystem", diskName, storageClass, blockDevice.FSType)
+				d.skipDevice(diskName, skipFormatted, blockDevice.FSType)
+				continue
+			}
+			// autoPartition only takes back the disks it partitioned itself
+			ownPartition := disks.AutoPartition && isAutoPartitioned(diskName, devices)
+			if blockDevice.DiskType == "disk" && blockDevice.PartTable != "" && !disks.AllowPartitioned && !ownPartition {
+				d.Log.Infof("not symlinking device %s for storageclass %s, it has a %s partition table", diskName, storageClass, blockDevice.PartTable)
+				d.skipDevice(diskName, skipPartitioned, blockDevice.PartTable)
+				continue
+			}
+			if !d.deviceAllowed(storageClass, disks, blockDevice) {
+				continue
+			}
+			stableDeviceID := matchCtx.stableDeviceID(disks, diskName)
+			if len(disks.HCTL) > 0 && sets.NewString(disks.HCTL...).Has(blockDevice.HCTL) {
+				// devices selected by their slot are referenced by it too
+				if byPath := d.findByPath(blockDevice); byPath != "" {
+					stableDeviceID = byPath
+				}
+			}
+			if disks.AllowFormatted {
+				// the filesystem UUID is the most stable reference to a formatted device,
+				// but devices symlinked by id before are left alone
+				claimedByID := stableDeviceID != "" && hasLocation(d.claimed[storageClass], DiskLocation{diskName: diskName, diskID: stableDeviceID})
+				if uuidPath := d.findByUUID(blockDevice); uuidPath != "" && !claimedByID {
+					stableDeviceID = uuidPath
+				}
+			}
+			// without a by-id link, symlinks named after stable ids are named after one from
+			// sysfs, other symlinks keep the kernel name they always had
+			sysfsID := ""
+			if stableDeviceID == "" && disks.NameByStableID {
+				sysfsID = sysfsStableID(diskName)
+			}
+			if stableDeviceID == "" && sysfsID == "" && !byIDDenied {
+				d.throttledErrorf("disk-id/"+diskName, "Unable to find disk ID %s for local pool", diskName)
+			}
+			size, _ := blockDevice.sizeBytes()
+			location := DiskLocation{diskName: diskName, diskID: stableDeviceID, size: size}
+			if disks.NameByStableID && stableDeviceID != "" {
+				location.linkName = stableLinkName(stableDeviceID)
+			}
+			if sysfsID != "" {
+				location.linkName = sysfsID
+			}
+			switch disks.SymlinkTarget {
+			case SymlinkTargetRaw:
+				location.raw = true
+			case SymlinkTargetByPath:
+				location.diskID = d.findByPath(blockDevice)
+			}
+			if disks.GroupByNUMA {
+				location.subDir = d.numaSubDir(blockDevice)
+			}
+			if disks.DirTemplate != "" {
+				subDir, err := d.dirTemplateSubDir(storageClass, disks, blockDevice)
+				if err != nil {
+					d.reconcileErrorf("not symlinking device %s for storageclass %s: %v", diskName, storageClass, err)
+					d.skipDevice(diskName, skipFailed, err.Error())
+					continue
+				}
+				location.subDir = subDir
+			}
+			blockDeviceMap[storageClass] = append(blockDeviceMap[storageClass], location)
 		}
 	}
+	d.resolveConflicts(diskConfig, blockDeviceMap)
+	d.resolveNameCollisions(diskConfig, blockDeviceMap)
 	return blockDeviceMap, nil
 }
 
-// findDeviceByID finds device ID and return device name(such as sda, sdb) and complete deviceID path
-func (d *DiskMaker) findDeviceByID(deviceID string) (string, string, error) {
-	completeDiskIDPath := fmt.Sprintf("%s/%s", diskByIDPath, deviceID)
-	diskDevPath, err := filepath.EvalSymlinks(completeDiskIDPath)
-	if err != nil {
-		return "", "", fmt.Errorf("unable to find device with id %s", deviceID)
+// buildByIDIndex resolves /dev/disk/by-id entries and maps device names to them.
+// It also returns whether some entries could not be resolved for lack of privileges.
+func (d *DiskMaker) buildByIDIndex(ctx context.Context, allDiskIds []string) (map[string][]string, bool) {
+	diskIDPaths := []string{}
+	for _, diskIDPath := range allDiskIds {
+		if d.quarantine.isQuarantined(diskIDPath) {
+			continue
+		}
+		// udev links point directly at the device, so entries of devices that are not
+		// scanned are skipped without resolving them
+		if target, err := d.fs.Readlink(diskIDPath); err == nil && !d.settings.scanned(filepath.Base(target)) {
+			continue
+		}
+		diskIDPaths = append(diskIDPaths, diskIDPath)
+	}
+	resolved := d.resolveSymlinks(ctx, diskIDPaths)
+	if ctx.Err() != nil {
+		// entries not resolved in time are not to blame, the reconcile is aborted anyway
+		return nil, false
 	}
-	diskDevName := filepath.Base(diskDevPath)
-	return completeDiskIDPath, diskDevName, nil
-}
 
-func (d *DiskMaker) findStableDeviceID(diskName string, allDisks []string) (string, error) {
-	for _, diskIDPath := range allDisks {
-		diskDevPath, err := filepath.EvalSymlinks(diskIDPath)
+	byIDIndex := make(map[string][]string)
+	denied := 0
+	for i, diskIDPath := range diskIDPaths {
+		err := resolved[i].err
+		if os.IsPermission(err) {
+			// not a problem of the device, so it's reported once below rather than quarantined
+			denied++
+			continue
+		}
 		if err != nil {
+			if d.quarantine.recordFailure(diskIDPath) {
+				d.Log.Warningf("quarantining disk-id %s for %v after %d consecutive failures", diskIDPath, d.quarantine.cooldown, d.quarantine.threshold)
+			}
 			continue
 		}
-		diskDevName := filepath.Base(diskDevPath)
-		if diskDevName == diskName {
-			return diskIDPath, nil
+		d.quarantine.recordSuccess(diskIDPath)
+		diskDevName := filepath.Base(resolved[i].path)
+		byIDIndex[diskDevName] = append(byIDIndex[diskDevName], diskIDPath)
+	}
+	if denied > 0 {
+		d.throttledWarningf("by-id-permission-denied", "permission denied resolving %d of %d entries of %s, devices may be symlinked without stable ids. Run diskmaker privileged or with access to /dev", denied, len(allDiskIds), diskByIDPath)
+		degraded.WithLabelValues(degradedByIDPermissionDenied).Set(1)
+	} else {
+		degraded.WithLabelValues(degradedByIDPermissionDenied).Set(0)
+	}
+	return byIDIndex, denied > 0
+}
+
+type resolvedSymlink struct {
+	path string
+	err  error
+}
+
+// resolveSymlinks evaluates linkPaths with up to ResolveConcurrency workers. Results
+// are in the order of linkPaths. If ctx is done before all are resolved, the remaining
+// ones fail with its error.
+func (d *DiskMaker) resolveSymlinks(ctx context.Context, linkPaths []string) []resolvedSymlink {
+	resolved := make([]resolvedSymlink, len(linkPaths))
+	workers := d.ResolveConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(linkPaths) {
+		workers = len(linkPaths)
+	}
+	indexes := make(chan int, len(linkPaths))
+	for i := range linkPaths {
+		indexes <- i
+	}
+	close(indexes)
+	// results are sent rather than written to resolved, as a worker stuck on a link
+	// may only finish after resolveSymlinks returned
+	type result struct {
+		index int
+		resolvedSymlink
+	}
+	results := make(chan result, len(linkPaths))
+	fs := d.fs
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range indexes {
+				if ctx.Err() != nil {
+					results <- result{i, resolvedSymlink{err: ctx.Err()}}
+					continue
+				}
+				path, err := fs.EvalSymlinks(linkPaths[i])
+				results <- result{i, resolvedSymlink{path, err}}
+			}
+		}()
+	}
+	done := make([]bool, len(linkPaths))
+	for range linkPaths {
+		select {
+		case r := <-results:
+			resolved[r.index] = r.resolvedSymlink
+			done[r.index] = true
+		case <-ctx.Done():
+			for i := range resolved {
+				if !done[i] {
+					resolved[i].err = ctx.Err()
+				}
+			}
+			return resolved
+		}
+	}
+	return resolved
+}
+
+// stableDeviceID returns the by-id path a device should be symlinked through,
+// preferring ids configured for the storageclass, or "" if the device has none.
+func (ctx *matchContext) stableDeviceID(disks *Disks, diskName string) string {
+	idMatcher := deviceIDMatcher{patterns: idPatterns(disks.DeviceIDs), byIDIndex: ctx.byIDIndex}
+	if idPath := idMatcher.matchingID(diskName); idPath != "" {
+		return idPath
+	}
+	if idPaths := ctx.byIDIndex[diskName]; len(idPaths) > 0 {
+		return idPaths[0]
+	}
+	return ""
+}
+
+// findByUUID returns the /dev/disk/by-uuid path of a device with a filesystem, or ""
+// if the device has no UUID or udev did not create a link for it
+func (d *DiskMaker) findByUUID(blockDevice BlockDevice) string {
+	if blockDevice.UUID == "" {
+		return ""
+	}
+	uuidPath := path.Join(diskByUUIDPath, blockDevice.UUID)
+	devPath, err := d.fs.EvalSymlinks(uuidPath)
+	if err != nil || filepath.Base(devPath) != blockDevice.Name {
+		return ""
+	}
+	return uuidPath
+}
+
+// findByPath returns a /dev/disk/by-path link of a device, or "" if there is none
+func (d *DiskMaker) findByPath(blockDevice BlockDevice) string {
+	byPaths, err := filepath.Glob(diskByPathPath)
+	if err != nil {
+		return ""
+	}
+	for _, byPath := range byPaths {
+		devPath, err := d.fs.EvalSymlinks(byPath)
+		if err == nil && filepath.Base(devPath) == blockDevice.Name {
+			return byPath
 		}
 	}
-	return "", fmt.Errorf("unable to find ID of disk %s", diskName)
+	return ""
+}
+
+// findDeviceByLabel returns name of the device carrying given filesystem label
+func (d *DiskMaker) findDeviceByLabel(label string) (string, error) {
+	out, err := d.run("blkid", "-o", "device", "-t", fmt.Sprintf("LABEL=%s", label))
+	if err != nil {
+		return "", fmt.Errorf("unable to find device with label %s: %v", label, err)
+	}
+	devices := strings.Fields(string(out))
+	if len(devices) != 1 {
+		return "", fmt.Errorf("expected one device with label %s, found %d", label, len(devices))
+	}
+	return filepath.Base(devices[0]), nil
 }
 
-func (d *DiskMaker) findNewDisks(content string) (sets.String, error) {
-	deviceSet := sets.NewString()
-	deviceLines := strings.Split(content, "\n")
-	for _, deviceLine := range deviceLines {
-		deviceLine := strings.TrimSpace(deviceLine)
-		deviceDetails := strings.Split(deviceLine, " ")
+// findNewDisks parses lsblk output and returns unmounted devices keyed by name
+func (d *DiskMaker) findNewDisks(content string) (map[string]BlockDevice, error) {
+	deviceSet := make(map[string]BlockDevice)
+	for _, blockDevice := range parseBlockDevices(content) {
+		if !d.settings.scanned(blockDevice.Name) {
+			continue
+		}
 		// We only consider devices that are not mounted.
 		// TODO: We should also consider checking for device partitions, so as
 		// if a device has partitions then we do not consider the device. We only
 		// consider partitions.
-		if len(deviceDetails) == 1 && len(deviceDetails[0]) > 0 {
-			deviceSet.Insert(deviceDetails[0])
+		if len(blockDevice.MountPoint) == 0 {
+			deviceSet[blockDevice.Name] = blockDevice
+		} else {
+			d.skipDevice(blockDevice.Name, skipMounted, blockDevice.MountPoint)
+			d.mountedDevices[blockDevice.Name] = blockDevice
 		}
 	}
 	return deviceSet, nil
 }
 
-func hasExactDisk(disks sets.String, device string) bool {
-	for _, disk := range disks.List() {
-		if disk == device {
-			return true
+// findDeviceByNumber returns name of the device with given major:minor number
+func findDeviceByNumber(deviceSet map[string]BlockDevice, deviceNumber string) string {
+	for diskName, blockDevice := range deviceSet {
+		if blockDevice.MajMin == deviceNumber {
+			return diskName
 		}
 	}
-	return false
+	return ""
 }
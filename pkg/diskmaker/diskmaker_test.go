@@ -1,9 +1,50 @@
 package diskmaker
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// keep devices backing the test process' own storage from being excluded,
+	mountInfo, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating mountinfo %v\n", err)
+		os.Exit(1)
+	}
+	mountInfo.Close()
+	procMountInfoPath = mountInfo.Name()
+	// nor devices opened by other processes
+	emptyProc, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating proc dir %v\n", err)
+		os.Exit(1)
+	}
+	procPath = emptyProc
+	// and attributes of the host's devices from affecting tests
+	emptySysBlock, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating sysfs dir %v\n", err)
+		os.Exit(1)
+	}
+	sysBlockPath = emptySysBlock
+	code := m.Run()
+	os.Remove(mountInfo.Name())
+	os.RemoveAll(emptyProc)
+	os.RemoveAll(emptySysBlock)
+	os.Exit(code)
+}
+
 func TestFindMatchingDisk(t *testing.T) {
 	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
 	deviceSet, err := d.findNewDisks(getData())
@@ -19,7 +60,7 @@ func TestFindMatchingDisk(t *testing.T) {
 		},
 	}
 	allDiskIds := getDeiveIDs()
-	deviceMap, err := d.findMatchingDisks(diskConfig, deviceSet, allDiskIds)
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, allDiskIds)
 	if err != nil {
 		t.Fatalf("error finding matchin device %v", err)
 	}
@@ -30,16 +71,16 @@ func TestFindMatchingDisk(t *testing.T) {
 
 func getData() string {
 	return `
-sda
-sda1 /boot
-sda2 [SWAP]
-sda3 /
-vda
-vdb
-vdc
-vdd
-vde
-vdf`
+NAME="sda" MAJ:MIN="8:0" TYPE="disk" SIZE="107374182400" MOUNTPOINT=""
+NAME="sda1" MAJ:MIN="8:1" TYPE="part" SIZE="1073741824" MOUNTPOINT="/boot" PKNAME="sda"
+NAME="sda2" MAJ:MIN="8:2" TYPE="part" SIZE="2147483648" MOUNTPOINT="[SWAP]" PKNAME="sda"
+NAME="sda3" MAJ:MIN="8:3" TYPE="part" SIZE="104152956928" MOUNTPOINT="/" PKNAME="sda"
+NAME="vda" MAJ:MIN="252:0" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" MODEL="FastSSD "
+NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT="" MODEL="FastSSD "
+NAME="vde" MAJ:MIN="252:64" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" HCTL="0:0:1:0"
+NAME="vdf" MAJ:MIN="252:80" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""`
 }
 
 func getDeiveIDs() []string {
@@ -47,3 +88,916 @@ func getDeiveIDs() []string {
 		"/dev/disk/by-id/xyz",
 	}
 }
+
+func TestFindMatchingDiskByNumber(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	diskConfig := DiskConfig{
+		"foo": &Disks{
+			DeviceNumbers: []string{"252:32", "8:1", "9:9"},
+		},
+	}
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	// 8:1 is mounted and 9:9 does not exist
+	if len(deviceMap["foo"]) != 1 || deviceMap["foo"][0].diskName != "vdc" {
+		t.Errorf("expected only vdc to match, got %+v", deviceMap["foo"])
+	}
+}
+
+func TestFindMatchingDiskByLabel(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = &fakeRunner{
+		outputs: map[string]string{
+			"blkid -o device -t LABEL=scratch": "/dev/vdc\n",
+		},
+		errors: map[string]error{
+			"blkid -o device -t LABEL=missing": fmt.Errorf("exit status 2"),
+		},
+	}
+	deviceSet, err := d.findNewDisks(getData() + `
+NAME="vdg" MAJ:MIN="252:96" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" FSTYPE="xfs"`)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	// vdc is given a filesystem, which FSLabels must allow
+	vdc := deviceSet["vdc"]
+	vdc.FSType = "ext4"
+	deviceSet["vdc"] = vdc
+
+	diskConfig := DiskConfig{
+		"foo": &Disks{
+			FSLabels:  []string{"scratch", "missing"},
+			DiskNames: []string{"vdg"},
+		},
+	}
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	// vdg is formatted and matched by name only, so it is skipped
+	if len(deviceMap["foo"]) != 1 || deviceMap["foo"][0].diskName != "vdc" {
+		t.Errorf("expected only vdc to match, got %+v", deviceMap["foo"])
+	}
+
+	diskConfig["foo"].AllowFormatted = true
+	deviceMap, err = d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if len(deviceMap["foo"]) != 2 {
+		t.Errorf("expected vdc and vdg to match with allowFormatted, got %+v", deviceMap["foo"])
+	}
+}
+
+func TestFindMatchingDiskByIDGlob(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{
+		"scsi-SATA_INTEL_A1":  "vdb",
+		"scsi-SATA_INTEL_A2":  "vdc",
+		"scsi-SATA_INTEL_B1":  "vdd",
+		"scsi-SATA_SAMSUNG_1": "vde",
+	})()
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	diskConfig := DiskConfig{
+		"foo": &Disks{
+			// the patterns overlap on the INTEL_A devices
+			DeviceIDs: []string{"scsi-SATA_INTEL_*", "scsi-SATA_INTEL_A?", "scsi-SATA_NONE_*"},
+		},
+	}
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, allDiskIds)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	claimed := map[string]int{}
+	for _, deviceLocation := range deviceMap["foo"] {
+		claimed[deviceLocation.diskName]++
+	}
+	if len(claimed) != 3 || claimed["vdb"] != 1 || claimed["vdc"] != 1 || claimed["vdd"] != 1 {
+		t.Errorf("expected vdb, vdc and vdd to be claimed once, got %v", claimed)
+	}
+}
+
+func TestSIGHUPTriggersReconcile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("{}"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = time.Hour
+	defer func() { checkDuration = oldCheckDuration }()
+
+	// keep SIGHUP from terminating the test binary before Run installs its handler
+	testHup := make(chan os.Signal, 1)
+	signal.Notify(testHup, syscall.SIGHUP)
+	defer signal.Stop(testHup)
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+	// wait for the startup reconcile so it isn't mistaken for the SIGHUP one
+	waitForCalls(t, runner, "lsblk", 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runner.count("lsblk") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a reconcile after SIGHUP")
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestTriggerDebounce(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("{}"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = time.Hour
+	defer func() { checkDuration = oldCheckDuration }()
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.TriggerDebounce = 200 * time.Millisecond
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+	waitForCalls(t, runner, "lsblk", 1)
+
+	for i := 0; i < 5; i++ {
+		d.Trigger()
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitForCalls(t, runner, "lsblk", 2)
+	time.Sleep(3 * d.TriggerDebounce)
+	if calls := runner.count("lsblk"); calls != 2 {
+		t.Errorf("expected triggers to be coalesced into a single reconcile, got %d reconciles", calls-1)
+	}
+}
+
+// blockingRunner blocks lsblk until release is closed and tracks concurrent calls
+type blockingRunner struct {
+	fakeRunner
+	release     chan struct{}
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (b *blockingRunner) Run(name string, args ...string) ([]byte, error) {
+	if name == "lsblk" {
+		inFlight := atomic.AddInt32(&b.inFlight, 1)
+		defer atomic.AddInt32(&b.inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&b.maxInFlight)
+			if inFlight <= max || atomic.CompareAndSwapInt32(&b.maxInFlight, max, inFlight) {
+				break
+			}
+		}
+		<-b.release
+	}
+	return b.fakeRunner.Run(name, args...)
+}
+
+func (b *blockingRunner) RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return b.Run(name, args...)
+}
+
+// hangingRunner runs the command hang, given by its name or full command line, until
+// it's killed through its context
+type hangingRunner struct {
+	fakeRunner
+	hang string
+}
+
+func (h *hangingRunner) RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name == h.hang || strings.Join(append([]string{name}, args...), " ") == h.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return h.fakeRunner.RunContext(ctx, name, args...)
+}
+
+func TestSerializedReconcile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("{}"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &blockingRunner{fakeRunner: fakeRunner{output: getData()}, release: make(chan struct{})}
+	d.runner = runner
+
+	// a tick driven reconcile is running when triggers arrive
+	done := make(chan struct{})
+	go func() {
+		d.runReconcile()
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runner.inFlight) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a reconcile to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runReconcile()
+		}()
+	}
+	wg.Wait()
+	close(runner.release)
+	<-done
+
+	if max := atomic.LoadInt32(&runner.maxInFlight); max != 1 {
+		t.Errorf("expected reconciles not to overlap, got %d at once", max)
+	}
+	if calls := runner.count("lsblk"); calls != 2 {
+		t.Errorf("expected overlapping requests to be coalesced into one follow-up reconcile, got %d reconciles", calls)
+	}
+}
+
+func TestSlowReconcileSkipsTicks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = 20 * time.Millisecond
+	defer func() { checkDuration = oldCheckDuration }()
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	d.ProtectSwap = false
+	delay := 200 * time.Millisecond
+	d.fs = &fakeFS{lstatDelay: delay}
+	skipped := metricValue(t, skippedTicks).GetCounter().GetValue()
+	stop := make(chan struct{})
+	start := time.Now()
+	go d.Run(stop)
+	time.Sleep(time.Second)
+	close(stop)
+	calls := runner.count("lsblk")
+	elapsed := time.Since(start)
+
+	// every reconcile takes at least delay, ticks are skipped instead of running later
+	if max := int(elapsed/delay) + 1; calls > max {
+		t.Errorf("expected at most %d reconciles in %v, got %d", max, elapsed, calls)
+	}
+	if value := metricValue(t, skippedTicks).GetCounter().GetValue(); value <= skipped {
+		t.Errorf("expected ticks to be skipped while reconciling")
+	}
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.ReconcileTimeout = 100 * time.Millisecond
+	hung := make(chan struct{})
+	d.fs = &fakeFS{evalSymlinksBlock: hung}
+	timeouts := metricValue(t, reconcileTimeouts).GetCounter().GetValue()
+
+	start := time.Now()
+	d.reconcile()
+	elapsed := time.Since(start)
+	close(hung)
+	if elapsed < d.ReconcileTimeout || elapsed > 10*d.ReconcileTimeout {
+		t.Errorf("expected the reconcile to be aborted after %v, took %v", d.ReconcileTimeout, elapsed)
+	}
+	if value := metricValue(t, reconcileTimeouts).GetCounter().GetValue(); value != timeouts+1 {
+		t.Errorf("expected one timeout to be counted, got %v", value-timeouts)
+	}
+	if len(d.reconcileErrors) != 1 || !strings.Contains(d.reconcileErrors[0].Error(), "aborted") {
+		t.Errorf("expected the aborted reconcile to be reported, got %v", d.reconcileErrors)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be symlinked by the aborted reconcile")
+	}
+
+	// the next reconcile proceeds once the device responds again
+	d.fs = &fakeFS{}
+	d.reconcile()
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); err != nil {
+		t.Errorf("expected vdb to be symlinked, got %v", err)
+	}
+}
+
+func TestReconcileTimeoutKillsCommands(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  skipUnhealthy: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Fatalf("expected vdb to be claimed, got %v", claimed)
+	}
+
+	// smartctl hangs on the device, the aborted reconcile keeps the earlier claim
+	d.runner = &hangingRunner{fakeRunner: fakeRunner{output: getData()}, hang: "smartctl"}
+	d.ReconcileTimeout = 100 * time.Millisecond
+	start := time.Now()
+	d.reconcile()
+	if elapsed := time.Since(start); elapsed > 10*d.ReconcileTimeout {
+		t.Errorf("expected the hung command to be killed after %v, took %v", d.ReconcileTimeout, elapsed)
+	}
+	if len(d.reconcileErrors) == 0 || !strings.Contains(d.reconcileErrors[len(d.reconcileErrors)-1].Error(), "aborted") {
+		t.Errorf("expected the aborted reconcile to be reported, got %v", d.reconcileErrors)
+	}
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected vdb to remain claimed, got %v", claimed)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); err != nil {
+		t.Errorf("expected the symlink of vdb to be kept, got %v", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("{}"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = 20 * time.Millisecond
+	defer func() { checkDuration = oldCheckDuration }()
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	d.Pause()
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+
+	time.Sleep(10 * checkDuration)
+	d.Trigger()
+	time.Sleep(2 * d.TriggerDebounce)
+	if calls := runner.count("lsblk"); calls != 0 {
+		t.Errorf("expected no reconcile while paused, got %d", calls)
+	}
+	d.Resume()
+	waitForCalls(t, runner, "lsblk", 1)
+}
+
+func TestReconcileOnStartup(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("{}"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = time.Hour
+	defer func() { checkDuration = oldCheckDuration }()
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+
+	// the first tick is an hour away, so any reconcile must be the startup one
+	waitForCalls(t, runner, "lsblk", 1)
+}
+
+func TestLsblkCommand(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	d.ProtectSwap = false
+	d.LsblkPath = "/usr/local/bin/lsblk"
+	d.LsblkExtraArgs = []string{"--nodeps"}
+	d.symLinkDisks(context.Background(), DiskConfig{})
+
+	expected := []string{"/usr/local/bin/lsblk", "--pairs", "--bytes", "-o", lsblkColumns, "--nodeps"}
+	if runner.count("/usr/local/bin/lsblk") != 1 || !equalStrings(runner.calls[0], expected) {
+		t.Errorf("expected lsblk to be run as %v, got %v", expected, runner.calls)
+	}
+}
+
+func TestRunTwiceFails(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldCheckDuration := checkDuration
+	checkDuration = time.Hour
+	defer func() { checkDuration = oldCheckDuration }()
+
+	d := NewDiskMaker(filepath.Join(tmpDir, "config"), filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+	// config is missing, so wait for the first Run to be up via the mkdir
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(tmpDir, "local-storage")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected first Run to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Run(stop) }()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Errorf("expected second Run to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected second Run to return immediately")
+	}
+}
+
+// fakeDiskByID creates a fake /dev tree under dir with by-id links pointing to
+// devices and returns a function restoring diskByIDPath
+func fakeDiskByID(t testing.TB, dir string, links map[string]string) func() {
+	byIDDir := filepath.Join(dir, "by-id")
+	if err := os.MkdirAll(byIDDir, 0755); err != nil {
+		t.Fatalf("error creating by-id dir %v", err)
+	}
+	for link, device := range links {
+		devicePath := filepath.Join(dir, device)
+		if err := ioutil.WriteFile(devicePath, []byte{}, 0644); err != nil {
+			t.Fatalf("error creating fake device %v", err)
+		}
+		if err := os.Symlink(devicePath, filepath.Join(byIDDir, link)); err != nil {
+			t.Fatalf("error creating by-id link %v", err)
+		}
+	}
+	oldDiskByIDPath := diskByIDPath
+	diskByIDPath = filepath.Join(byIDDir, "*")
+	return func() { diskByIDPath = oldDiskByIDPath }
+}
+
+func waitForCalls(t *testing.T, runner *fakeRunner, name string, expected int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runner.count(name) < expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d calls to %s, got %d", expected, name, runner.count(name))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeRunner returns canned output and records the commands it was asked to run
+type fakeRunner struct {
+	lock sync.Mutex
+	// output is returned for commands without an entry in outputs
+	output string
+	// outputs and errors are keyed by the full command line or the command name
+	outputs map[string]string
+	errors  map[string]error
+	calls   [][]string
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	call := append([]string{name}, args...)
+	f.calls = append(f.calls, call)
+	for _, key := range []string{strings.Join(call, " "), name} {
+		if err, ok := f.errors[key]; ok {
+			return nil, err
+		}
+		if output, ok := f.outputs[key]; ok {
+			return []byte(output), nil
+		}
+	}
+	return []byte(f.output), nil
+}
+
+func (f *fakeRunner) RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Run(name, args...)
+}
+
+func (f *fakeRunner) count(name string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n := 0
+	for _, call := range f.calls {
+		if call[0] == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFormattedDeviceByUUID(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdd": "vdd"})()
+	uuid := "0b0e5c3a-7a2f-4a8e-9d51-5e0c8bd1d8a4"
+	oldDiskByUUIDPath := diskByUUIDPath
+	diskByUUIDPath = filepath.Join(tmpDir, "by-uuid")
+	defer func() { diskByUUIDPath = oldDiskByUUIDPath }()
+	if err := os.MkdirAll(diskByUUIDPath, 0755); err != nil {
+		t.Fatalf("error creating by-uuid dir %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "vdd"), filepath.Join(diskByUUIDPath, uuid)); err != nil {
+		t.Fatalf("error creating by-uuid link %v", err)
+	}
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(strings.Replace(getData(), `NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT=""`,
+		`NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT="" FSTYPE="ext4" UUID="`+uuid+`"`, 1))
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+	d.runner = &fakeRunner{outputs: map[string]string{"blkid -o device -t LABEL=data": "/dev/vdd\n"}}
+	deviceMap, err := d.findMatchingDisks(context.Background(), DiskConfig{"reclaim": &Disks{DiskNames: []string{"vdd"}, AllowFormatted: true}}, deviceSet, allDiskIds)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	expected := filepath.Join(diskByUUIDPath, uuid)
+	if len(deviceMap["reclaim"]) != 1 || deviceMap["reclaim"][0].diskID != expected {
+		t.Errorf("expected vdd to be symlinked through %s, got %+v", expected, deviceMap["reclaim"])
+	}
+	// without allowFormatted the by-id path is kept
+	deviceMap, err = d.findMatchingDisks(context.Background(), DiskConfig{"labels": &Disks{DeviceIDs: []string{"virtio-vdd"}, FSLabels: []string{"data"}}}, deviceSet, allDiskIds)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if len(deviceMap["labels"]) != 1 || filepath.Base(deviceMap["labels"][0].diskID) != "virtio-vdd" {
+		t.Errorf("expected vdd to be symlinked by id, got %+v", deviceMap["labels"])
+	}
+}
+
+func TestFormattedDeviceLinkedByID(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdd": "vdd"})()
+	uuid := "0b0e5c3a-7a2f-4a8e-9d51-5e0c8bd1d8a4"
+	oldDiskByUUIDPath := diskByUUIDPath
+	diskByUUIDPath = filepath.Join(tmpDir, "by-uuid")
+	defer func() { diskByUUIDPath = oldDiskByUUIDPath }()
+	if err := os.MkdirAll(diskByUUIDPath, 0755); err != nil {
+		t.Fatalf("error creating by-uuid dir %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "vdd"), filepath.Join(diskByUUIDPath, uuid)); err != nil {
+		t.Fatalf("error creating by-uuid link %v", err)
+	}
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("reclaim:\n  disks: [vdd]\n  allowFormatted: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	// vdd was symlinked by id before formatted devices were symlinked by uuid
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	byIDTarget := filepath.Join(tmpDir, "by-id", "virtio-vdd")
+	if err := os.MkdirAll(filepath.Join(symlinkLocation, "reclaim"), 0755); err != nil {
+		t.Fatalf("error creating class dir %v", err)
+	}
+	if err := os.Symlink(byIDTarget, filepath.Join(symlinkLocation, "reclaim", "vdd")); err != nil {
+		t.Fatalf("error creating symlink %v", err)
+	}
+
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: strings.Replace(getData(), `NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT=""`,
+		`NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT="" FSTYPE="ext4" UUID="`+uuid+`"`, 1)}
+	d.ProtectSwap = false
+	d.reconcile()
+	if len(d.reconcileErrors) != 0 {
+		t.Errorf("expected no errors, got %v", d.reconcileErrors)
+	}
+	status := d.Status()
+	if !equalStrings(status.Claimed["reclaim"], []string{"vdd"}) {
+		t.Errorf("expected vdd to stay claimed, got %v", status.Claimed)
+	}
+	if target, err := os.Readlink(filepath.Join(symlinkLocation, "reclaim", "vdd")); err != nil || target != byIDTarget {
+		t.Errorf("expected vdd to stay symlinked through %s, got %s %v", byIDTarget, target, err)
+	}
+}
+
+func TestDeviceIDsMatchMountedDevices(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.ProtectSwap = false
+	d.runner = &fakeRunner{output: strings.Replace(getData(), `NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""`,
+		`NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="/data"`, 1)}
+	_, deviceMap, ok := d.discoverDisks(context.Background(), DiskConfig{
+		"ids":   &Disks{DeviceIDs: []string{"virtio-vdb"}},
+		"names": &Disks{DiskNames: []string{"vdb"}},
+	})
+	if !ok {
+		t.Fatalf("expected discovery to succeed")
+	}
+	if len(deviceMap["ids"]) != 1 || deviceMap["ids"][0].diskName != "vdb" {
+		t.Errorf("expected mounted vdb to be matched by its id, got %+v", deviceMap["ids"])
+	}
+	if len(deviceMap["names"]) != 0 {
+		t.Errorf("expected mounted vdb not to be matched by name, got %+v", deviceMap["names"])
+	}
+}
+
+func TestSymlinkTarget(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	byPathDir := filepath.Join(tmpDir, "by-path")
+	oldDiskByPathPath := diskByPathPath
+	diskByPathPath = filepath.Join(byPathDir, "*")
+	defer func() { diskByPathPath = oldDiskByPathPath }()
+	if err := os.MkdirAll(byPathDir, 0755); err != nil {
+		t.Fatalf("error creating by-path dir %v", err)
+	}
+	byPath := filepath.Join(byPathDir, "pci-0000:00:05.0")
+	if err := os.Symlink(filepath.Join(tmpDir, "vdb"), byPath); err != nil {
+		t.Fatalf("error creating by-path link %v", err)
+	}
+	configFile := filepath.Join(tmpDir, "config")
+
+	tests := []struct {
+		symlinkTarget string
+		expected      string
+	}{
+		{"", filepath.Join(tmpDir, "by-id", "virtio-vdb")},
+		{SymlinkTargetStableID, filepath.Join(tmpDir, "by-id", "virtio-vdb")},
+		{SymlinkTargetRaw, "/dev/vdb"},
+		{SymlinkTargetByPath, byPath},
+	}
+	for _, test := range tests {
+		config := fmt.Sprintf("foo:\n  disks: [vdb]\n  symlinkTarget: %q\n", test.symlinkTarget)
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("error writing config %v", err)
+		}
+		symlinkLocation := filepath.Join(tmpDir, "local-storage-"+test.symlinkTarget)
+		d := NewDiskMaker(configFile, symlinkLocation)
+		d.runner = &fakeRunner{output: getData()}
+		d.ProtectSwap = false
+		d.reconcile()
+		target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", "vdb"))
+		if err != nil {
+			t.Errorf("symlinkTarget %q: expected vdb to be symlinked, got %v", test.symlinkTarget, err)
+			continue
+		}
+		if target != test.expected {
+			t.Errorf("symlinkTarget %q: expected vdb to point to %s, got %s", test.symlinkTarget, test.expected, target)
+		}
+	}
+
+	if err := (DiskConfig{"foo": &Disks{SymlinkTarget: "by-label"}}).validate(); err == nil {
+		t.Errorf("expected invalid symlinkTarget to fail validation")
+	}
+}
+
+func TestHCTLByPath(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vde": "vde"})()
+	byPathDir := filepath.Join(tmpDir, "by-path")
+	oldDiskByPathPath := diskByPathPath
+	diskByPathPath = filepath.Join(byPathDir, "*")
+	defer func() { diskByPathPath = oldDiskByPathPath }()
+	if err := os.MkdirAll(byPathDir, 0755); err != nil {
+		t.Fatalf("error creating by-path dir %v", err)
+	}
+	byPath := filepath.Join(byPathDir, "pci-0000:00:10.0-scsi-0:0:1:0")
+	if err := os.Symlink(filepath.Join(tmpDir, "vde"), byPath); err != nil {
+		t.Fatalf("error creating by-path link %v", err)
+	}
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+	deviceMap, err := d.findMatchingDisks(context.Background(), DiskConfig{"slotted": &Disks{HCTL: []string{"0:0:1:0"}}}, deviceSet, allDiskIds)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if len(deviceMap["slotted"]) != 1 || deviceMap["slotted"][0].diskID != byPath {
+		t.Errorf("expected vde to be symlinked through %s, got %+v", byPath, deviceMap["slotted"])
+	}
+	deviceMap, err = d.findMatchingDisks(context.Background(), DiskConfig{"named": &Disks{DiskNames: []string{"vde"}}}, deviceSet, allDiskIds)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if len(deviceMap["named"]) != 1 || filepath.Base(deviceMap["named"][0].diskID) != "virtio-vde" {
+		t.Errorf("expected vde matched by name to be symlinked by id, got %+v", deviceMap["named"])
+	}
+}
+
+func TestNameByStableID(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"wwn-0x5000:a": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  nameByStableID: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+	firstLink := filepath.Join(symlinkLocation, "foo", "wwn-0x5000_a")
+	if _, err := os.Lstat(firstLink); err != nil {
+		t.Fatalf("expected symlink named after the stable id, got %v", err)
+	}
+
+	// after a reboot vdb is a different disk
+	byIDDir := filepath.Join(tmpDir, "by-id")
+	os.Remove(filepath.Join(byIDDir, "wwn-0x5000:a"))
+	if err := os.Symlink(filepath.Join(tmpDir, "vdb"), filepath.Join(byIDDir, "wwn-0x6000")); err != nil {
+		t.Fatalf("error creating by-id link %v", err)
+	}
+	d.reconcile()
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "wwn-0x6000")); err != nil {
+		t.Errorf("expected the new disk to get a new symlink, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); !os.IsNotExist(err) {
+		t.Errorf("expected no symlink named after the kernel name")
+	}
+}
+
+func TestGroupByNUMA(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "device/numa_node", "0")
+	writeSysfsAttribute(t, "vdc", "device/numa_node", "1")
+	writeSysfsAttribute(t, "vdd", "device/numa_node", "-1")
+	// partitions are on the NUMA node of their disk
+	writeSysfsAttribute(t, "vde", "device/numa_node", "1")
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd", "virtio-vde1": "vde1"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vdd, vde1]\n  groupByNUMA: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData() + `
+NAME="vde1" MAJ:MIN="252:65" TYPE="part" SIZE="10737418240" MOUNTPOINT="" PKNAME="vde"`}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	for linkName, diskName := range map[string]string{"numa0/vdb": "vdb", "numa1/vdc": "vdc", "numa-none/vdd": "vdd", "numa1/vde1": "vde1"} {
+		target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", linkName))
+		if err != nil {
+			t.Errorf("expected symlink foo/%s, got %v", linkName, err)
+			continue
+		}
+		if target != filepath.Join(tmpDir, "by-id", "virtio-"+diskName) {
+			t.Errorf("expected foo/%s to point to %s, got %s", linkName, diskName, target)
+		}
+	}
+}
+
+func TestSysfsStableIDFallback(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "device/wwid", "naa.600508b1001c5e4d")
+	writeSysfsAttribute(t, "vdc", "device/serial", "QM 00002")
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	// no /dev/disk/by-id entries at all
+	defer fakeDiskByID(t, tmpDir, map[string]string{})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vdd]\n  nameByStableID: true\nbar:\n  disks: [vde]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	writeSysfsAttribute(t, "vde", "device/wwid", "naa.600508b1001c5e4e")
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	// without nameByStableID the kernel name is kept
+	if linkTarget, err := os.Readlink(filepath.Join(symlinkLocation, "bar", "vde")); err != nil || linkTarget != "/dev/vde" {
+		t.Errorf("expected bar/vde to point to /dev/vde, got %s %v", linkTarget, err)
+	}
+	for linkName, target := range map[string]string{
+		"wwid-naa.600508b1001c5e4d": "/dev/vdb",
+		"serial-QM_00002":           "/dev/vdc",
+		// vdd has neither
+		"vdd": "/dev/vdd",
+	} {
+		linkTarget, err := os.Readlink(filepath.Join(symlinkLocation, "foo", linkName))
+		if err != nil {
+			t.Errorf("expected symlink foo/%s, got %v", linkName, err)
+			continue
+		}
+		if linkTarget != target {
+			t.Errorf("expected foo/%s to point to %s, got %s", linkName, target, linkTarget)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package diskmaker
+
+// DrainClass removes all symlinks of storageClass, so that its provisioner releases the
+// PVs, and stops claiming devices for it until UndrainClass is called, for example
+// during node maintenance. Unlike disabling the storageclass, its configuration is
+// kept. Drained storageclasses are only kept in memory.
+func (d *DiskMaker) DrainClass(storageClass string) error {
+	d.lock.Lock()
+	d.drained.Insert(storageClass)
+	d.lock.Unlock()
+	d.Log.Infof("draining storageclass %s", storageClass)
+	return d.removeClassLinks(storageClass)
+}
+
+// UndrainClass allows devices to be claimed for a storageclass drained by DrainClass again
+func (d *DiskMaker) UndrainClass(storageClass string) {
+	d.lock.Lock()
+	d.drained.Delete(storageClass)
+	d.lock.Unlock()
+	d.Log.Infof("undraining storageclass %s", storageClass)
+	d.Trigger()
+}
+
+// isDrained returns whether devices must not be claimed for storageClass
+func (d *DiskMaker) isDrained(storageClass string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.drained.Has(storageClass)
+}
@@ -0,0 +1,59 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDrainClass(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\nbar:\n  disks: [vdd]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	linked := func(storageClass, diskName string) bool {
+		_, err := os.Lstat(filepath.Join(symlinkLocation, storageClass, diskName))
+		return err == nil
+	}
+
+	for cycle := 0; cycle < 2; cycle++ {
+		d.reconcile()
+		if !linked("foo", "vdb") || !linked("foo", "vdc") || !linked("bar", "vdd") {
+			t.Fatalf("cycle %d: expected all devices to be symlinked", cycle)
+		}
+
+		if err := d.DrainClass("foo"); err != nil {
+			t.Fatalf("cycle %d: error draining foo %v", cycle, err)
+		}
+		if linked("foo", "vdb") || linked("foo", "vdc") {
+			t.Errorf("cycle %d: expected symlinks of drained foo to be removed", cycle)
+		}
+		d.reconcile()
+		if linked("foo", "vdb") || linked("foo", "vdc") {
+			t.Errorf("cycle %d: expected drained foo not to claim devices", cycle)
+		}
+		if !linked("bar", "vdd") {
+			t.Errorf("cycle %d: expected bar to be left alone", cycle)
+		}
+		if reason := d.Status().SkipReasons["vdb"]; reason != skipDrained+": foo" {
+			t.Errorf("cycle %d: expected vdb to be skipped as drained, got %q", cycle, reason)
+		}
+
+		d.UndrainClass("foo")
+	}
+	d.reconcile()
+	if !linked("foo", "vdb") || !linked("foo", "vdc") {
+		t.Errorf("expected undrained foo to claim devices again")
+	}
+}
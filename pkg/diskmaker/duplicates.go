@@ -0,0 +1,69 @@
+package diskmaker
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// findDuplicateIDs returns names of disks sharing their WWN or serial number with
+// another disk, mapped to the shared id. Distinct disks should never share an id, when
+// they do (e.g. cloned disks) a /dev/disk/by-id symlink could point at either of them,
+// so such disks and their partitions are not claimed.
+func (d *DiskMaker) findDuplicateIDs(allDevices []BlockDevice) map[string]string {
+	idDevices := make(map[string]sets.String)
+	for _, blockDevice := range allDevices {
+		if blockDevice.DiskType != "disk" {
+			continue
+		}
+		for _, id := range []string{blockDevice.WWN, blockDevice.Serial} {
+			if id == "" {
+				continue
+			}
+			if idDevices[id] == nil {
+				idDevices[id] = sets.NewString()
+			}
+			idDevices[id].Insert(blockDevice.Name)
+		}
+	}
+
+	ids := []string{}
+	for id := range idDevices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	ambiguous := make(map[string]string)
+	for _, id := range ids {
+		diskNames := idDevices[id]
+		if diskNames.Len() < 2 {
+			continue
+		}
+		if d.logThrottle.allow("duplicate-id/" + id) {
+			d.Recorder.Eventf(corev1.EventTypeWarning, duplicateStableIDReason, "stable id %s is shared by devices %v, not claiming them", id, diskNames.List())
+		}
+		for _, diskName := range diskNames.List() {
+			if _, found := ambiguous[diskName]; !found {
+				ambiguous[diskName] = id
+			}
+		}
+	}
+	for _, blockDevice := range allDevices {
+		if id, found := ambiguous[blockDevice.Parent]; found && isPartitionOf(blockDevice, blockDevice.Parent) {
+			ambiguous[blockDevice.Name] = id
+		}
+	}
+	duplicateStableIDs.Set(float64(len(ambiguous)))
+	return ambiguous
+}
+
+// excludeDuplicateIDs removes devices found by findDuplicateIDs from deviceSet
+func (d *DiskMaker) excludeDuplicateIDs(deviceSet map[string]BlockDevice, duplicateIDs map[string]string) {
+	for deviceName, id := range duplicateIDs {
+		if _, found := deviceSet[deviceName]; found {
+			d.Log.Infof("ignoring device %s because it shares id %s with another device", deviceName, id)
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipDuplicateID, id)
+		}
+	}
+}
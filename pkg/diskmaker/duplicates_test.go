@@ -0,0 +1,52 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateStableID(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"wwn-0x5000c500a1b2c3d4": "vdc", "virtio-serial-b": "vdb", "virtio-serial-e": "vde"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vdd, vdd1, vde]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	// vdd is a clone of vdc reporting the same WWN, vde shares its serial with the mounted vda
+	recorder := &fakeRecorder{}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: `
+NAME="vda" MAJ:MIN="252:0" TYPE="disk" SIZE="10737418240" MOUNTPOINT="/" SERIAL="serial-a"
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" SERIAL="serial-b"
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" WWN="0x5000c500a1b2c3d4" SERIAL="serial-c"
+NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" WWN="0x5000c500a1b2c3d4" SERIAL="serial-d"
+NAME="vdd1" MAJ:MIN="252:49" TYPE="part" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdd" WWN="0x5000c500a1b2c3d4" SERIAL="serial-d"
+NAME="vde" MAJ:MIN="252:64" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" SERIAL="serial-a"`}
+	d.Recorder = recorder
+	d.ProtectSwap = false
+	d.reconcile()
+
+	status := d.Status()
+	if claimed := status.Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected only vdb to be claimed, got %v", claimed)
+	}
+	for diskName, id := range map[string]string{"vdc": "0x5000c500a1b2c3d4", "vdd": "0x5000c500a1b2c3d4", "vdd1": "0x5000c500a1b2c3d4", "vde": "serial-a"} {
+		if _, err := os.Lstat(filepath.Join(tmpDir, "local-storage", "foo", diskName)); !os.IsNotExist(err) {
+			t.Errorf("expected ambiguous %s not to be symlinked", diskName)
+		}
+		if reason := status.SkipReasons[diskName]; reason != skipDuplicateID+": "+id {
+			t.Errorf("expected %s to be skipped for duplicate id %s, got %q", diskName, id, reason)
+		}
+	}
+	if events := recorder.withReason(duplicateStableIDReason); len(events) != 2 || !strings.Contains(events[0], "Warning DuplicateStableID stable id 0x5000c500a1b2c3d4 is shared by devices [vdc vdd]") {
+		t.Errorf("expected DuplicateStableID events, got %v", recorder.events)
+	}
+}
@@ -0,0 +1,48 @@
+package diskmaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxBatchedDevices is the number of devices listed by an aggregated event, the others
+// are only counted as the API server truncates long event messages
+const maxBatchedDevices = 20
+
+// eventBatch collects the devices of events of a reason emitted during a reconcile,
+// so that large nodes emit one event per reason instead of one per device
+type eventBatch struct {
+	eventType string
+	reason    string
+	// messageFmt formats the number of devices and their list
+	messageFmt string
+	devices    []string
+}
+
+// batchEvent adds device to the aggregated event of reason emitted by flushEvents at
+// the end of the reconcile
+func (d *DiskMaker) batchEvent(eventType, reason, messageFmt, device string) {
+	for _, batch := range d.eventBatches {
+		if batch.reason == reason && batch.eventType == eventType {
+			batch.devices = append(batch.devices, device)
+			return
+		}
+	}
+	d.eventBatches = append(d.eventBatches, &eventBatch{eventType, reason, messageFmt, []string{device}})
+}
+
+// flushEvents emits the events batched during the reconcile, in the order their
+// reasons were first batched, listing devices sorted by name
+func (d *DiskMaker) flushEvents() {
+	for _, batch := range d.eventBatches {
+		devices := batch.devices
+		sort.Strings(devices)
+		list := strings.Join(devices, ", ")
+		if len(devices) > maxBatchedDevices {
+			list = fmt.Sprintf("%s and %d more", strings.Join(devices[:maxBatchedDevices], ", "), len(devices)-maxBatchedDevices)
+		}
+		d.Recorder.Eventf(batch.eventType, batch.reason, batch.messageFmt, len(devices), list)
+	}
+	d.eventBatches = nil
+}
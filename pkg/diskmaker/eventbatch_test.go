@@ -0,0 +1,62 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDisksClaimedEvent(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\nbar:\n  disks: [vdd]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	recorder := &fakeRecorder{}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.Recorder = recorder
+	d.ProtectSwap = false
+	d.reconcile()
+	expected := "Normal DisksClaimed claimed 3 devices: bar/vdd, foo/vdb, foo/vdc"
+	if events := recorder.withReason(disksClaimedReason); len(events) != 1 || events[0] != expected {
+		t.Errorf("expected a single event %q, got %v", expected, recorder.events)
+	}
+
+	// devices already claimed are not reported again
+	d.reconcile()
+	if events := recorder.withReason(disksClaimedReason); len(events) != 1 {
+		t.Errorf("expected no new DisksClaimed event, got %v", recorder.events)
+	}
+}
+
+func TestFlushEventsLimit(t *testing.T) {
+	recorder := &fakeRecorder{}
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.Recorder = recorder
+	for i := 0; i < maxBatchedDevices+5; i++ {
+		d.batchEvent("Normal", disksClaimedReason, "claimed %d devices: %s", fmt.Sprintf("foo/sd%02d", i))
+	}
+	d.flushEvents()
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected a single event, got %v", recorder.events)
+	}
+	if event := recorder.events[0]; !strings.HasPrefix(event, "Normal DisksClaimed claimed 25 devices: foo/sd00, ") || !strings.HasSuffix(event, "foo/sd19 and 5 more") {
+		t.Errorf("expected 20 devices listed and 5 counted, got %q", event)
+	}
+
+	// batches are emptied once flushed
+	d.flushEvents()
+	if len(recorder.events) != 1 {
+		t.Errorf("expected no event after the batch was flushed, got %v", recorder.events)
+	}
+}
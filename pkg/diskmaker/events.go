@@ -0,0 +1,41 @@
+package diskmaker
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of events emitted by the DiskMaker
+const (
+	claimedDeviceLostReason = "ClaimedDeviceLost"
+	duplicateStableIDReason = "DuplicateStableID"
+	configChangedReason     = "ConfigChanged"
+	// symlinkLocationChangedReason is emitted when the volume of symlinkLocation was remounted
+	symlinkLocationChangedReason = "SymlinkLocationChanged"
+	// devicePartitionedReason is emitted when a disk was partitioned, see Disks.AutoPartition
+	devicePartitionedReason = "DevicePartitioned"
+	// disksClaimedReason is emitted when devices were symlinked for the first time
+	disksClaimedReason = "DisksClaimed"
+)
+
+// EventRecorder receives events about devices managed by the DiskMaker, such as a
+// claimed device disappearing. It matches the Eventf method of a Kubernetes
+// record.EventRecorder bound to an object, so either can be plugged in.
+type EventRecorder interface {
+	Eventf(eventType, reason, messageFmt string, args ...interface{})
+}
+
+// logEventRecorder is the default EventRecorder, which only logs events
+type logEventRecorder struct {
+	d *DiskMaker
+}
+
+func (r logEventRecorder) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if eventType == corev1.EventTypeWarning {
+		r.d.Log.Warningf("%s: %s", reason, message)
+		return
+	}
+	r.d.Log.Infof("%s: %s", reason, message)
+}
@@ -0,0 +1,50 @@
+package diskmaker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultFilterTimeout bounds a run of DiskMaker.FilterCommand unless FilterTimeout is set
+const defaultFilterTimeout = 10 * time.Second
+
+// filterDecision is the outcome of DiskMaker.FilterCommand for a storageclass and device
+type filterDecision struct {
+	allowed bool
+	reason  string
+}
+
+// filterCommandAllows runs DiskMaker.FilterCommand with the storageclass and device
+// path of a candidate device and returns whether it approved the device, which it
+// does by exiting with status 0, and why not otherwise. A command that hangs is killed
+// after FilterTimeout and excludes the device. The command runs once per storageclass
+// and device in a reconcile.
+func (d *DiskMaker) filterCommandAllows(storageClass, diskName string) (bool, string) {
+	key := storageClass + "/" + diskName
+	if decision, found := d.filterDecisions[key]; found {
+		return decision.allowed, decision.reason
+	}
+	allowed, reason := d.runFilterCommand(storageClass, diskName)
+	if d.filterDecisions == nil {
+		d.filterDecisions = make(map[string]filterDecision)
+	}
+	d.filterDecisions[key] = filterDecision{allowed: allowed, reason: reason}
+	return allowed, reason
+}
+
+func (d *DiskMaker) runFilterCommand(storageClass, diskName string) (bool, string) {
+	out, err := d.runWithTimeout(d.FilterTimeout, d.FilterCommand, storageClass, path.Join("/dev", diskName))
+	if err == nil {
+		return true, ""
+	}
+	if err == errCommandTimeout {
+		return false, fmt.Sprintf("%s timed out after %v", d.FilterCommand, d.FilterTimeout)
+	}
+	reason := fmt.Sprintf("%s failed with %v", d.FilterCommand, err)
+	if output := strings.TrimSpace(string(out)); output != "" {
+		reason = fmt.Sprintf("%s: %s", reason, output)
+	}
+	return false, reason
+}
@@ -0,0 +1,114 @@
+package diskmaker
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// deviceAllowed returns false if a device matched by a storageclass is excluded by
+// the additional filters configured for that storageclass.
+func (d *DiskMaker) deviceAllowed(storageClass string, disks *Disks, blockDevice BlockDevice) bool {
+	diskName := blockDevice.Name
+	if len(disks.Transports) > 0 && !sets.NewString(disks.Transports...).Has(blockDevice.Transport) {
+		transport := blockDevice.Transport
+		if transport == "" {
+			transport = "unknown"
+		}
+		d.Log.Infof("excluding device %s, transport %s is not one of %v", diskName, transport, disks.Transports)
+		d.skipDevice(diskName, skipExcluded, fmt.Sprintf("transport %s is not allowed", transport))
+		return false
+	}
+	if !disks.AllowRemovable && isRemovable(blockDevice) {
+		d.Log.Infof("excluding device %s, it is removable media", diskName)
+		d.skipDevice(diskName, skipExcluded, "removable")
+		return false
+	}
+	if zoned, wantZoned := isZoned(blockDevice), disks.Zoned != nil && *disks.Zoned; zoned != wantZoned {
+		if zoned {
+			d.Log.Infof("excluding device %s, it is a zoned drive", diskName)
+			d.skipDevice(diskName, skipExcluded, "zoned")
+		} else {
+			d.Log.Infof("excluding device %s, it is not a zoned drive", diskName)
+			d.skipDevice(diskName, skipExcluded, "not zoned")
+		}
+		return false
+	}
+	if len(disks.RequireFSOptions) > 0 || len(disks.ExcludeFSOptions) > 0 {
+		if allowed, reason := d.fsOptionsAllowed(disks, diskName); !allowed {
+			d.Log.Infof("excluding device %s, %s", diskName, reason)
+			d.skipDevice(diskName, skipExcluded, reason)
+			return false
+		}
+	}
+	if disks.SkipUnhealthy && d.smartHealth(diskName) == healthFailed {
+		d.Log.Warningf("excluding device %s, its SMART health check failed", diskName)
+		d.skipDevice(diskName, skipUnhealthy, "")
+		unhealthyDevicesSkipped.WithLabelValues(storageClass).Inc()
+		return false
+	}
+	if disks.SkipErroredDevices {
+		if count := ioErrorCount(blockDevice); count > disks.MaxIOErrors {
+			d.Log.Warningf("excluding device %s, it had %d I/O errors", diskName, count)
+			d.skipDevice(diskName, skipIOErrors, fmt.Sprintf("%d errors", count))
+			erroredDevicesSkipped.WithLabelValues(storageClass).Inc()
+			return false
+		}
+	}
+	if disks.MinQueueDepth > 0 {
+		// partitions share the request queue of their disk
+		queueDepth, err := readSysfsInt(sysfsDiskName(blockDevice), "queue/nr_requests")
+		if err != nil {
+			d.Log.Infof("excluding device %s, unable to read queue depth: %v", diskName, err)
+			d.skipDevice(diskName, skipExcluded, "queue depth unknown")
+			return false
+		}
+		if queueDepth < disks.MinQueueDepth {
+			d.Log.Infof("excluding device %s, queue depth %d is less than %d", diskName, queueDepth, disks.MinQueueDepth)
+			d.skipDevice(diskName, skipExcluded, fmt.Sprintf("queue depth %d is less than %d", queueDepth, disks.MinQueueDepth))
+			return false
+		}
+	}
+	// the filter command is the most expensive check, so it runs last
+	if d.FilterCommand != "" {
+		if allowed, reason := d.filterCommandAllows(storageClass, diskName); !allowed {
+			d.Log.Infof("excluding device %s for storageclass %s, %s", diskName, storageClass, reason)
+			d.skipDevice(diskName, skipFilterCommand, reason)
+			return false
+		}
+	}
+	return true
+}
+
+// excludeSmallDevices removes devices smaller than NodeSettings.GlobalMinSize from deviceSet
+func (d *DiskMaker) excludeSmallDevices(deviceSet map[string]BlockDevice) {
+	minSize := d.settings.GlobalMinSize
+	for diskName, blockDevice := range deviceSet {
+		size, err := blockDevice.sizeBytes()
+		if err != nil || size >= minSize.Value() {
+			continue
+		}
+		d.Log.Infof("excluding device %s, its size %d is less than globalMinSize %s", diskName, size, minSize.String())
+		d.skipDevice(diskName, skipExcluded, fmt.Sprintf("smaller than globalMinSize %s", minSize.String()))
+		delete(deviceSet, diskName)
+	}
+}
+
+// excludeEmptyDevices removes devices whose size is zero or can't be parsed from
+// deviceSet, such as empty card reader slots, as nothing can be stored on them
+func (d *DiskMaker) excludeEmptyDevices(deviceSet map[string]BlockDevice) {
+	for diskName, blockDevice := range deviceSet {
+		size, err := blockDevice.sizeBytes()
+		switch {
+		case err != nil:
+			d.Log.Debugf("excluding device %s, unable to parse its size %q: %v", diskName, blockDevice.Size, err)
+			d.skipDevice(diskName, skipExcluded, "size unknown")
+		case size == 0:
+			d.Log.Debugf("excluding device %s, its size is zero", diskName)
+			d.skipDevice(diskName, skipExcluded, "zero size")
+		default:
+			continue
+		}
+		delete(deviceSet, diskName)
+	}
+}
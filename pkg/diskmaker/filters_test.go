@@ -0,0 +1,334 @@
+package diskmaker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMinQueueDepth(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "queue/nr_requests", "128")
+	writeSysfsAttribute(t, "vdc", "queue/nr_requests", "32")
+	writeSysfsAttribute(t, "vde", "queue/nr_requests", "128")
+	writeSysfsAttribute(t, "vdf", "queue/nr_requests", "32")
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData() + `
+NAME="vde1" MAJ:MIN="252:65" TYPE="part" SIZE="10737418240" MOUNTPOINT="" PKNAME="vde"
+NAME="vdf1" MAJ:MIN="252:81" TYPE="part" SIZE="10737418240" MOUNTPOINT="" PKNAME="vdf"`)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	diskConfig := DiskConfig{
+		"foo": &Disks{
+			DiskNames:     []string{"vdb", "vdc", "vdd", "vde1", "vdf1"},
+			MinQueueDepth: 64,
+		},
+	}
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	// vdc has a shallow queue and vdd does not report one, partitions use the queue of
+	// their disk
+	matched := []string{}
+	for _, deviceLocation := range deviceMap["foo"] {
+		matched = append(matched, deviceLocation.diskName)
+	}
+	sort.Strings(matched)
+	if !equalStrings(matched, []string{"vdb", "vde1"}) {
+		t.Errorf("expected only vdb and vde1 to match, got %+v", deviceMap["foo"])
+	}
+}
+
+func TestGlobalMinSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+	d.ProtectSwap = false
+	d.runner = &fakeRunner{output: strings.Replace(getData(), `NAME="vdf" MAJ:MIN="252:80" TYPE="disk" SIZE="10737418240"`,
+		`NAME="vdf" MAJ:MIN="252:80" TYPE="disk" SIZE="1048576"`, 1)}
+	d.settings = NodeSettings{GlobalMinSize: quantity("1Gi")}
+	deviceMap := d.symLinkDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vde", "vdf"}}})
+
+	if len(deviceMap["foo"]) != 1 || deviceMap["foo"][0].diskName != "vde" {
+		t.Errorf("expected only vde to be claimed, got %+v", deviceMap["foo"])
+	}
+	if reason := d.skipReasons["vdf"]; reason != skipExcluded+": smaller than globalMinSize 1Gi" {
+		t.Errorf("expected tiny vdf to be excluded, got %q", reason)
+	}
+}
+
+// fakeSysfs points sysBlockPath to a new temporary directory and returns
+// a function restoring it
+func fakeSysfs(t *testing.T) func() {
+	tmpDir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	oldSysBlockPath := sysBlockPath
+	sysBlockPath = tmpDir
+	return func() {
+		sysBlockPath = oldSysBlockPath
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func writeSysfsAttribute(t *testing.T, diskName, attribute, value string) {
+	attributePath := filepath.Join(sysBlockPath, diskName, attribute)
+	if err := os.MkdirAll(filepath.Dir(attributePath), 0755); err != nil {
+		t.Fatalf("error creating sysfs dir %v", err)
+	}
+	if err := ioutil.WriteFile(attributePath, []byte(value+"\n"), 0644); err != nil {
+		t.Fatalf("error writing sysfs attribute %v", err)
+	}
+}
+
+func TestTransports(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(`
+NAME="sdb" MAJ:MIN="8:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" TRAN="sata"
+NAME="nvme0n1" MAJ:MIN="259:0" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" TRAN="nvme"
+NAME="sdc" MAJ:MIN="8:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" TRAN="usb"
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" TRAN=""`)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	diskConfig := DiskConfig{
+		"foo": &Disks{
+			DiskNames:  []string{"sdb", "nvme0n1", "sdc", "vdb"},
+			Transports: []string{"nvme", "sata"},
+		},
+	}
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	claimed := []string{}
+	for _, deviceLocation := range deviceMap["foo"] {
+		claimed = append(claimed, deviceLocation.diskName)
+	}
+	if !equalStrings(claimed, []string{"nvme0n1", "sdb"}) {
+		t.Errorf("expected only nvme and sata devices to match, got %v", claimed)
+	}
+	// usb is not allowed and vdb does not report a transport
+	for _, diskName := range []string{"sdc", "vdb"} {
+		if reason := d.skipReasons[diskName]; !strings.HasPrefix(reason, skipExcluded) {
+			t.Errorf("expected %s to be excluded, got %q", diskName, reason)
+		}
+	}
+}
+
+func TestRemovableExcluded(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "removable", "1")
+	writeSysfsAttribute(t, "vdc", "removable", "0")
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+
+	deviceMap, err := d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb", "vdc", "vdd"}}}, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if hasDevice(deviceMap["foo"], "vdb") || !hasDevice(deviceMap["foo"], "vdc") || !hasDevice(deviceMap["foo"], "vdd") {
+		t.Errorf("expected removable vdb to be excluded, got %v", deviceMap)
+	}
+	if reason := d.skipReasons["vdb"]; reason != skipExcluded+": removable" {
+		t.Errorf("expected vdb to be skipped as removable, got %q", reason)
+	}
+
+	deviceMap, err = d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb"}, AllowRemovable: true}}, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if !hasDevice(deviceMap["foo"], "vdb") {
+		t.Errorf("expected removable vdb to be allowed, got %v", deviceMap)
+	}
+
+	// partitions have no removable attribute of their own
+	deviceSet, err = d.findNewDisks(getData() + `
+NAME="vdb1" MAJ:MIN="252:17" TYPE="part" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdb"
+NAME="vdc1" MAJ:MIN="252:33" TYPE="part" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdc"`)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	deviceMap, err = d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb1", "vdc1"}}}, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if hasDevice(deviceMap["foo"], "vdb1") || !hasDevice(deviceMap["foo"], "vdc1") {
+		t.Errorf("expected partition vdb1 of removable vdb to be excluded, got %v", deviceMap)
+	}
+	if reason := d.skipReasons["vdb1"]; reason != skipExcluded+": removable" {
+		t.Errorf("expected vdb1 to be skipped as removable, got %q", reason)
+	}
+}
+
+func TestZoned(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "queue/zoned", "host-managed")
+	writeSysfsAttribute(t, "vdc", "queue/zoned", "host-aware")
+	writeSysfsAttribute(t, "vdd", "queue/zoned", "none")
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+
+	zoned := true
+	tests := []struct {
+		zoned    *bool
+		expected []string
+	}{
+		{nil, []string{"vdd", "vde"}},
+		{&zoned, []string{"vdb", "vdc"}},
+	}
+	for _, test := range tests {
+		diskConfig := DiskConfig{"foo": &Disks{DiskNames: []string{"vdb", "vdc", "vdd", "vde"}, Zoned: test.zoned}}
+		deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, nil)
+		if err != nil {
+			t.Fatalf("error finding matching device %v", err)
+		}
+		claimed := []string{}
+		for _, location := range deviceMap["foo"] {
+			claimed = append(claimed, location.diskName)
+		}
+		sort.Strings(claimed)
+		if !equalStrings(claimed, test.expected) {
+			t.Errorf("expected devices %v to be matched with zoned %v, got %v", test.expected, test.zoned, claimed)
+		}
+	}
+	if reason := d.skipReasons["vde"]; reason != skipExcluded+": not zoned" {
+		t.Errorf("expected vde to be skipped as not zoned, got %q", reason)
+	}
+}
+
+func TestScanPrefixes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"nvme-fast": "nvme0n1", "virtio-vdb": "vdb"})()
+	fs := &fakeFS{evalSymlinksErrors: map[string]error{
+		filepath.Join(tmpDir, "by-id", "virtio-vdb"): fmt.Errorf("vdb should not be resolved"),
+	}}
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+	d.fs = fs
+	d.settings = NodeSettings{ScanPrefixes: []string{"nvme"}}
+	deviceSet, err := d.findNewDisks(getData() + `
+NAME="nvme0n1" MAJ:MIN="259:0" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""
+NAME="nvme0n1p1" MAJ:MIN="259:1" TYPE="part" SIZE="1048576" MOUNTPOINT="/boot/efi" PKNAME="nvme0n1"`)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	if len(deviceSet) != 1 {
+		t.Errorf("expected only nvme0n1 to be discovered, got %v", deviceSet)
+	}
+	if _, ok := d.skipReasons["sda1"]; ok {
+		t.Errorf("expected devices without the prefix to be ignored altogether, got %v", d.skipReasons)
+	}
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+	byIDIndex, _ := d.buildByIDIndex(context.Background(), allDiskIds)
+	if len(byIDIndex) != 1 || len(byIDIndex["nvme0n1"]) != 1 {
+		t.Errorf("expected only the by-id entry of nvme0n1 to be resolved, got %v", byIDIndex)
+	}
+	if d.quarantine.isQuarantined(filepath.Join(tmpDir, "by-id", "virtio-vdb")) || len(d.quarantine.failures) != 0 {
+		t.Errorf("expected the by-id entry of vdb to be skipped, got failures %v", d.quarantine.failures)
+	}
+}
+
+func TestExcludeEmptyDevices(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = &fakeRunner{output: getData() + `
+NAME="sdb" MAJ:MIN="8:16" TYPE="disk" SIZE="0" MOUNTPOINT=""
+NAME="sdc" MAJ:MIN="8:32" TYPE="disk" SIZE="" MOUNTPOINT=""`}
+	d.ProtectSwap = false
+	_, deviceSet, ok := d.findCandidateDisks()
+	if !ok {
+		t.Fatalf("expected candidate disks to be found")
+	}
+	for diskName, expected := range map[string]string{"sdb": "excluded: zero size", "sdc": "excluded: size unknown"} {
+		if _, found := deviceSet[diskName]; found {
+			t.Errorf("expected %s to be excluded, got %v", diskName, deviceSet)
+		}
+		if reason := d.skipReasons[diskName]; reason != expected {
+			t.Errorf("expected %s to be skipped with %q, got %q", diskName, expected, reason)
+		}
+	}
+	if _, found := deviceSet["vdb"]; !found {
+		t.Errorf("expected vdb to be a candidate, got %v", deviceSet)
+	}
+}
+
+func TestFilterCommand(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.FilterCommand = "/usr/local/bin/vet-device"
+	d.FilterTimeout = 50 * time.Millisecond
+	runner := &hangingRunner{
+		fakeRunner: fakeRunner{errors: map[string]error{
+			"/usr/local/bin/vet-device foo /dev/vdc": fmt.Errorf("exit status 1"),
+		}},
+		hang: "/usr/local/bin/vet-device foo /dev/vdd",
+	}
+	d.runner = runner
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+
+	deviceMap, err := d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb", "vdc", "vdd"}}}, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if !hasDevice(deviceMap["foo"], "vdb") || hasDevice(deviceMap["foo"], "vdc") || hasDevice(deviceMap["foo"], "vdd") {
+		t.Errorf("expected only vdb to be approved by the filter command, got %v", deviceMap)
+	}
+	expected := map[string]string{
+		"vdc": skipFilterCommand + ": /usr/local/bin/vet-device failed with exit status 1",
+		"vdd": skipFilterCommand + ": /usr/local/bin/vet-device timed out after 50ms",
+	}
+	for diskName, reason := range expected {
+		if d.skipReasons[diskName] != reason {
+			t.Errorf("expected %s to be skipped with %q, got %q", diskName, reason, d.skipReasons[diskName])
+		}
+	}
+
+	// decisions are reused within a reconcile
+	calls := runner.count("/usr/local/bin/vet-device")
+	if _, err := d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb", "vdc", "vdd"}}}, deviceSet, nil); err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if runner.count("/usr/local/bin/vet-device") != calls {
+		t.Errorf("expected the filter command not to run again for the same devices")
+	}
+}
+
+func TestFilterCommandRunsLast(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "queue/nr_requests", "2")
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.skipReasons = make(map[string]string)
+	d.FilterCommand = "/usr/local/bin/vet-device"
+	runner := &fakeRunner{}
+	d.runner = runner
+	if d.deviceAllowed("foo", &Disks{MinQueueDepth: 32}, BlockDevice{Name: "vdb"}) {
+		t.Errorf("expected vdb to be excluded for its queue depth")
+	}
+	if runner.count("/usr/local/bin/vet-device") != 0 {
+		t.Errorf("expected the filter command not to run for excluded devices, got calls %v", runner.calls)
+	}
+}
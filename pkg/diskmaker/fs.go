@@ -0,0 +1,50 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// FileSystem wraps the filesystem operations the DiskMaker performs on devices and
+// symlinks, so that tests can substitute failures.
+type FileSystem interface {
+	MkdirAll(path string, perm os.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chmod(name string, mode os.FileMode) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Lstat(name string) (os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	EvalSymlinks(path string) (string, error)
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	Setxattr(path, attr string, data []byte) error
+	Walk(root string, walkFn filepath.WalkFunc) error
+}
+
+// osFileSystem implements FileSystem using the os package
+type osFileSystem struct{}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error  { return os.MkdirAll(path, perm) }
+func (osFileSystem) Chown(name string, uid, gid int) error         { return os.Chown(name, uid, gid) }
+func (osFileSystem) Chmod(name string, mode os.FileMode) error     { return os.Chmod(name, mode) }
+func (osFileSystem) Symlink(oldname, newname string) error         { return os.Symlink(oldname, newname) }
+func (osFileSystem) Readlink(name string) (string, error)          { return os.Readlink(name) }
+func (osFileSystem) Lstat(name string) (os.FileInfo, error)        { return os.Lstat(name) }
+func (osFileSystem) Stat(name string) (os.FileInfo, error)         { return os.Stat(name) }
+func (osFileSystem) Remove(name string) error                      { return os.Remove(name) }
+func (osFileSystem) EvalSymlinks(path string) (string, error)      { return filepath.EvalSymlinks(path) }
+func (osFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) { return ioutil.ReadDir(dirname) }
+func (osFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(filename, data, perm)
+}
+func (osFileSystem) Setxattr(path, attr string, data []byte) error {
+	return unix.Setxattr(path, attr, data, 0)
+}
+func (osFileSystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
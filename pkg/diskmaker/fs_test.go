@@ -0,0 +1,281 @@
+package diskmaker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestChownClassDirectory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldGeteuid := geteuid
+	defer func() { geteuid = oldGeteuid }()
+
+	fs := &fakeFS{}
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+	d.fs = fs
+	d.DirUID = 1000
+	d.DirGID = 2000
+	location := DiskLocation{diskName: "vdb"}
+
+	geteuid = func() int { return 1000 }
+	if err := d.createSymlink("foo", location); err != nil {
+		t.Fatalf("error creating symlink %v", err)
+	}
+	if len(fs.chowns) != 0 {
+		t.Errorf("expected chown to be skipped when not root, got %v", fs.chowns)
+	}
+
+	geteuid = func() int { return 0 }
+	if err := d.createSymlink("foo", location); err != nil {
+		t.Fatalf("error creating symlink %v", err)
+	}
+	expected := fmt.Sprintf("%s 1000:2000", filepath.Join(tmpDir, "local-storage", "foo"))
+	if len(fs.chowns) != 1 || fs.chowns[0] != expected {
+		t.Errorf("expected chown %q, got %v", expected, fs.chowns)
+	}
+}
+
+func TestByIDPermissionDenied(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	deniedPath := filepath.Join(tmpDir, "by-id", "virtio-vdb")
+	fs := &fakeFS{evalSymlinksErrors: map[string]error{
+		deniedPath: &os.PathError{Op: "lstat", Path: deniedPath, Err: syscall.EACCES},
+	}}
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+	d.fs = fs
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+
+	for i := 0; i < quarantineThreshold+1; i++ {
+		byIDIndex, denied := d.buildByIDIndex(context.Background(), allDiskIds)
+		if !denied || len(byIDIndex["vdb"]) != 0 || len(byIDIndex["vdc"]) != 1 {
+			t.Fatalf("expected only vdb to be unresolved for lack of permission, got %v", byIDIndex)
+		}
+	}
+	if d.quarantine.isQuarantined(deniedPath) {
+		t.Errorf("expected permission errors not to quarantine %s", deniedPath)
+	}
+	if value := gaugeValue(t, degraded, degradedByIDPermissionDenied); value != 1 {
+		t.Errorf("expected degraded metric to be set, got %v", value)
+	}
+
+	// vdb is still claimed, by name
+	deviceMap, err := d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb"}}}, deviceSet, allDiskIds)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if len(deviceMap["foo"]) != 1 || deviceMap["foo"][0].diskName != "vdb" || deviceMap["foo"][0].diskID != "" {
+		t.Errorf("expected vdb to be matched without a stable id, got %v", deviceMap)
+	}
+
+	fs.evalSymlinksErrors = nil
+	if _, denied := d.buildByIDIndex(context.Background(), allDiskIds); denied {
+		t.Errorf("expected no permission errors")
+	}
+	if value := gaugeValue(t, degraded, degradedByIDPermissionDenied); value != 0 {
+		t.Errorf("expected degraded metric to be cleared, got %v", value)
+	}
+}
+
+func fakeManyDiskByIDs(t testing.TB, dir string, count int) func() {
+	links := make(map[string]string)
+	for i := 0; i < count; i++ {
+		links[fmt.Sprintf("wwn-%04d", i)] = fmt.Sprintf("sd%d", i%100)
+	}
+	return fakeDiskByID(t, dir, links)
+}
+
+func TestParallelByIDIndex(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeManyDiskByIDs(t, tmpDir, 1000)()
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+
+	d.ResolveConcurrency = 1
+	sequential, _ := d.buildByIDIndex(context.Background(), allDiskIds)
+	d.ResolveConcurrency = 16
+	parallel, _ := d.buildByIDIndex(context.Background(), allDiskIds)
+	if len(sequential) != 100 || len(parallel) != len(sequential) {
+		t.Fatalf("expected 100 devices, got %d sequentially and %d in parallel", len(sequential), len(parallel))
+	}
+	for diskName, ids := range sequential {
+		if len(ids) != 10 || !equalStrings(parallel[diskName], ids) {
+			t.Errorf("expected ids %v of %s, got %v in parallel", ids, diskName, parallel[diskName])
+		}
+	}
+}
+
+func BenchmarkBuildByIDIndex(b *testing.B) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		b.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeManyDiskByIDs(b, tmpDir, 5000)()
+	allDiskIds, _ := filepath.Glob(diskByIDPath)
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+			d.ResolveConcurrency = concurrency
+			for i := 0; i < b.N; i++ {
+				d.buildByIDIndex(context.Background(), allDiskIds)
+			}
+		})
+	}
+}
+
+// fakeFS performs operations on the real filesystem except for the ones faked here
+type fakeFS struct {
+	osFileSystem
+	lock   sync.Mutex
+	chowns []string
+	// evalSymlinksErrors are returned by EvalSymlinks for the paths they are keyed by
+	evalSymlinksErrors map[string]error
+	// readDirErr and writeFileErr, if set, are returned by ReadDir and WriteFile
+	readDirErr   error
+	writeFileErr error
+	// writeFileHook, if set, is called with the name of every file written
+	writeFileHook func(filename string)
+	// symlinkErrors are returned by consecutive calls of Symlink, which succeeds once
+	// they are used up
+	symlinkErrors []error
+	symlinks      int
+	// lstatDelay slows down Lstat, as on stalling storage
+	lstatDelay time.Duration
+	// setxattrErr, if set, is returned by Setxattr
+	setxattrErr error
+	// evalSymlinksBlock, if set, blocks EvalSymlinks until it's closed, as on a hung device
+	evalSymlinksBlock chan struct{}
+	// removeErrors are returned by Remove for the paths they are keyed by
+	removeErrors map[string]error
+}
+
+func (f *fakeFS) Setxattr(path, attr string, data []byte) error {
+	if f.setxattrErr != nil {
+		return f.setxattrErr
+	}
+	return f.osFileSystem.Setxattr(path, attr, data)
+}
+
+func (f *fakeFS) Symlink(oldname, newname string) error {
+	f.lock.Lock()
+	f.symlinks++
+	if len(f.symlinkErrors) > 0 {
+		err := f.symlinkErrors[0]
+		f.symlinkErrors = f.symlinkErrors[1:]
+		f.lock.Unlock()
+		return err
+	}
+	f.lock.Unlock()
+	return f.osFileSystem.Symlink(oldname, newname)
+}
+
+func (f *fakeFS) Lstat(name string) (os.FileInfo, error) {
+	time.Sleep(f.lstatDelay)
+	return f.osFileSystem.Lstat(name)
+}
+
+func (f *fakeFS) Remove(name string) error {
+	if err, ok := f.removeErrors[name]; ok {
+		return err
+	}
+	return f.osFileSystem.Remove(name)
+}
+
+func (f *fakeFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if f.readDirErr != nil {
+		return nil, f.readDirErr
+	}
+	return f.osFileSystem.ReadDir(dirname)
+}
+
+func (f *fakeFS) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	if f.writeFileHook != nil {
+		f.writeFileHook(filename)
+	}
+	if f.writeFileErr != nil {
+		return f.writeFileErr
+	}
+	return f.osFileSystem.WriteFile(filename, data, perm)
+}
+
+func (f *fakeFS) EvalSymlinks(path string) (string, error) {
+	if f.evalSymlinksBlock != nil {
+		<-f.evalSymlinksBlock
+	}
+	if err, ok := f.evalSymlinksErrors[path]; ok {
+		return "", err
+	}
+	return f.osFileSystem.EvalSymlinks(path)
+}
+
+func (f *fakeFS) Chown(name string, uid, gid int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.chowns = append(f.chowns, fmt.Sprintf("%s %d:%d", name, uid, gid))
+	return nil
+}
+
+func TestSymlinkRetry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	transient := &os.LinkError{Op: "symlink", Err: syscall.ENOENT}
+	fs := &fakeFS{symlinkErrors: []error{transient, transient}}
+	d := NewDiskMaker("/tmp/foo", symlinkLocation)
+	d.fs = fs
+	d.SymlinkRetry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	if err := d.createSymlink("foo", DiskLocation{diskName: "vdb"}); err != nil {
+		t.Fatalf("expected transient failures to be retried, got %v", err)
+	}
+	if fs.symlinks != 3 {
+		t.Errorf("expected 3 attempts, got %d", fs.symlinks)
+	}
+	if target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", "vdb")); err != nil || target != "/dev/vdb" {
+		t.Errorf("expected symlink to /dev/vdb, got %s, %v", target, err)
+	}
+
+	// attempts are bounded
+	fs.symlinks = 0
+	fs.symlinkErrors = []error{transient, transient, transient, transient}
+	if err := d.createSymlink("foo", DiskLocation{diskName: "vdc"}); err == nil {
+		t.Errorf("expected symlink creation to fail once attempts are used up")
+	}
+	if fs.symlinks != 3 {
+		t.Errorf("expected 3 attempts, got %d", fs.symlinks)
+	}
+
+	// existing files are not retried
+	fs.symlinks = 0
+	fs.symlinkErrors = []error{&os.LinkError{Op: "symlink", Err: syscall.EEXIST}}
+	if err := d.createSymlink("foo", DiskLocation{diskName: "vdd"}); err == nil || fs.symlinks != 1 {
+		t.Errorf("expected an existing file to fail without retry, got %v after %d attempts", err, fs.symlinks)
+	}
+}
@@ -0,0 +1,112 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// defaultFstabPath is the fstab of the host, which the diskmaker DaemonSet mounts
+const defaultFstabPath = "/host/etc/fstab"
+
+// fstabCache holds fstab and the blkid tags of devices for the current reconcile, so
+// that they are read once rather than for every device of every storageclass
+type fstabCache struct {
+	// entries are the fields of fstab lines with at least the mount options
+	entries [][]string
+	err     error
+	tags    map[string]map[string]string
+}
+
+// loadFstab reads FstabPath. A missing fstab has no entries.
+func (d *DiskMaker) loadFstab() *fstabCache {
+	cache := &fstabCache{tags: make(map[string]map[string]string)}
+	content, err := ioutil.ReadFile(d.FstabPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			cache.err = fmt.Errorf("failed to read %s with %v", d.FstabPath, err)
+		}
+		return cache
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		// UUID=b5f9 /var/lib/data ext4 defaults,noatime 0 2
+		fields := strings.Fields(line)
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		cache.entries = append(cache.entries, fields)
+	}
+	return cache
+}
+
+// blkidTags returns the tags blkid reports for the filesystem of a device, such as
+// UUID and LABEL. Devices blkid finds nothing on have no tags.
+func (d *DiskMaker) blkidTags(diskName string) map[string]string {
+	if tags, found := d.fstab.tags[diskName]; found {
+		return tags
+	}
+	tags := make(map[string]string)
+	d.fstab.tags[diskName] = tags
+	out, err := d.run("blkid", "-o", "export", path.Join("/dev", diskName))
+	if err != nil {
+		return tags
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) == 2 {
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags
+}
+
+// fstabOptions returns the mount options fstab configures for the filesystem of a
+// device, or nil if fstab has no entry for it. Entries refer to the filesystem by a
+// tag such as UUID=, or by a device path.
+func (d *DiskMaker) fstabOptions(diskName string) ([]string, error) {
+	if d.fstab == nil {
+		d.fstab = d.loadFstab()
+	}
+	if d.fstab.err != nil {
+		return nil, d.fstab.err
+	}
+	for _, fields := range d.fstab.entries {
+		spec := fields[0]
+		if parts := strings.SplitN(spec, "=", 2); len(parts) == 2 {
+			tags := d.blkidTags(diskName)
+			if value, found := tags[parts[0]]; !found || value != strings.Trim(parts[1], `"`) {
+				continue
+			}
+		} else if devPath, err := d.fs.EvalSymlinks(spec); err != nil || filepath.Base(devPath) != diskName {
+			continue
+		}
+		return strings.Split(fields[3], ","), nil
+	}
+	return nil, nil
+}
+
+// fsOptionsAllowed returns whether the fstab mount options of a device satisfy
+// Disks.RequireFSOptions and Disks.ExcludeFSOptions, and the reason if not
+func (d *DiskMaker) fsOptionsAllowed(disks *Disks, diskName string) (bool, string) {
+	options, err := d.fstabOptions(diskName)
+	if err != nil {
+		return false, err.Error()
+	}
+	present := sets.NewString(options...)
+	for _, option := range disks.RequireFSOptions {
+		if !present.Has(option) {
+			return false, fmt.Sprintf("fs option %s is not set", option)
+		}
+	}
+	for _, option := range disks.ExcludeFSOptions {
+		if present.Has(option) {
+			return false, fmt.Sprintf("fs option %s is set", option)
+		}
+	}
+	return true, ""
+}
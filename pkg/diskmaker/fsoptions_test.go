@@ -0,0 +1,78 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFSOptions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	fstabPath := filepath.Join(tmpDir, "fstab")
+	fstab := `# data disks
+UUID=b5f9c1 /mnt/vdb ext4 defaults,noatime 0 2
+LABEL="scratch" /mnt/vdc xfs defaults 0 2
+`
+	if err := ioutil.WriteFile(fstabPath, []byte(fstab), 0644); err != nil {
+		t.Fatalf("error writing fstab %v", err)
+	}
+	configFile := filepath.Join(tmpDir, "config")
+	config := "foo:\n  disks: [vdb, vdc, vdd]\n  allowFormatted: true\n  requireFSOptions: [noatime]\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.FstabPath = fstabPath
+	runner := &fakeRunner{
+		output: `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" FSTYPE="ext4"
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" FSTYPE="xfs"
+NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" FSTYPE="xfs"`,
+		outputs: map[string]string{
+			"blkid -o export /dev/vdb": "DEVNAME=/dev/vdb\nUUID=b5f9c1\nTYPE=ext4\n",
+			"blkid -o export /dev/vdc": "DEVNAME=/dev/vdc\nLABEL=scratch\nTYPE=xfs\n",
+			"blkid -o export /dev/vdd": "DEVNAME=/dev/vdd\nTYPE=xfs\n",
+		},
+	}
+	d.runner = runner
+	d.ProtectSwap = false
+	d.reconcile()
+
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected only vdb mounted with noatime to be claimed, got %v", claimed)
+	}
+	// fstab and blkid are read once per reconcile, however often a device is checked
+	if err := os.Remove(fstabPath); err != nil {
+		t.Fatalf("error removing fstab %v", err)
+	}
+	if options, err := d.fstabOptions("vdb"); err != nil || !equalStrings(options, []string{"defaults", "noatime"}) {
+		t.Errorf("expected fstab to be read once per reconcile, got %v %v", options, err)
+	}
+	if calls := runner.count("blkid"); calls != 3 {
+		t.Errorf("expected blkid to run once per device, got %d calls", calls)
+	}
+	if err := ioutil.WriteFile(fstabPath, []byte(fstab), 0644); err != nil {
+		t.Fatalf("error writing fstab %v", err)
+	}
+	for _, name := range []string{"vdc", "vdd"} {
+		if reason := d.Status().SkipReasons[name]; reason != skipExcluded+": fs option noatime is not set" {
+			t.Errorf("expected %s to be excluded, got %q", name, reason)
+		}
+	}
+
+	config = "foo:\n  disks: [vdb, vdc, vdd]\n  allowFormatted: true\n  excludeFSOptions: [noatime]\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdc", "vdd"}) {
+		t.Errorf("expected vdc and vdd without noatime to be claimed, got %v", claimed)
+	}
+}
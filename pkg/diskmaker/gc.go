@@ -0,0 +1,106 @@
+package diskmaker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// removeOrphanedLinks removes every symlink under symlinkLocation whose target no
+// longer exists, even of storageclasses no longer in the configuration. This keeps
+// links of removed devices from piling up when a whole storageclass is dropped.
+// Only storageclasses configured with KeepDanglingLinks are left alone. It must not run
+// concurrently with reconcile, see collectOrphanedLinks.
+func (d *DiskMaker) removeOrphanedLinks() {
+	err := d.fs.Walk(d.symlinkLocation, func(linkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// sidecars of removed symlinks disappear during the walk
+			if !os.IsNotExist(err) {
+				d.Log.Errorf("error walking %s with %v", linkPath, err)
+			}
+			return nil
+		}
+		if info.IsDir() && filepath.Dir(linkPath) == filepath.Clean(d.symlinkLocation) && d.keepsDanglingLinks(info.Name()) {
+			return filepath.SkipDir
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		if _, err := d.fs.Stat(linkPath); !os.IsNotExist(err) {
+			d.forgetMissing(linkPath)
+			return nil
+		}
+		if !d.graceElapsed(linkPath) {
+			return nil
+		}
+		d.Log.Infof("removing orphaned symlink %s", linkPath)
+		if err := d.fs.Remove(linkPath); err != nil {
+			d.Log.Errorf("error removing orphaned symlink %s with %v", linkPath, err)
+			return nil
+		}
+		d.forgetMissing(linkPath)
+		d.removeMeta(linkPath)
+		return nil
+	})
+	if err != nil {
+		d.Log.Errorf("error collecting orphaned symlinks in %s with %v", d.symlinkLocation, err)
+	}
+}
+
+// removeClassLinks removes all symlinks created for storageClass. Errors are logged
+// and the first one is returned.
+func (d *DiskMaker) removeClassLinks(storageClass string) error {
+	var firstErr error
+	classDir := filepath.Join(d.symlinkLocation, storageClass)
+	err := d.fs.Walk(classDir, func(linkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				d.Log.Errorf("error reading %s with %v", linkPath, err)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("error reading %s with %v", linkPath, err)
+				}
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		d.Log.Infof("removing symlink %s of storageclass %s", linkPath, storageClass)
+		if err := d.fs.Remove(linkPath); err != nil {
+			d.Log.Errorf("error removing symlink %s with %v", linkPath, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error removing symlink %s with %v", linkPath, err)
+			}
+			return nil
+		}
+		d.removeMeta(linkPath)
+		return nil
+	})
+	if err != nil {
+		d.Log.Errorf("error removing symlinks in %s with %v", classDir, err)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("error removing symlinks in %s with %v", classDir, err)
+		}
+	}
+	return firstErr
+}
+
+// removeInactiveClassLinks removes the symlinks of storageclasses disabled with
+// RemoveOnDisable, of drained storageclasses and of released devices
+func (d *DiskMaker) removeInactiveClassLinks(diskConfig DiskConfig) {
+	for storageClass, disks := range diskConfig {
+		// links created by a reconcile racing with DrainClass are removed too
+		if (!disks.enabled() && disks.RemoveOnDisable) || d.isDrained(storageClass) {
+			d.removeClassLinks(storageClass)
+		}
+	}
+	if err := d.removeReleasedLinks(); err != nil {
+		d.reconcileErrorf("error removing symlinks of released devices: %v", err)
+	}
+}
+
+// keepsDanglingLinks returns whether symlinks of storageClass to missing devices are kept
+func (d *DiskMaker) keepsDanglingLinks(storageClass string) bool {
+	disks := d.diskConfig[storageClass]
+	return disks != nil && disks.KeepDanglingLinks
+}
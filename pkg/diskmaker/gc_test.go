@@ -0,0 +1,264 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRemoveOrphanedLinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	device := filepath.Join(tmpDir, "sdb")
+	if err := ioutil.WriteFile(device, []byte{}, 0644); err != nil {
+		t.Fatalf("error creating fake device %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	// a class that is no longer in config still gets its dead links removed
+	for _, dir := range []string{"foo", "removed-class"} {
+		if err := os.MkdirAll(filepath.Join(symlinkLocation, dir), 0755); err != nil {
+			t.Fatalf("error creating class dir %v", err)
+		}
+	}
+	liveLink := filepath.Join(symlinkLocation, "foo", "sdb")
+	orphans := []string{
+		filepath.Join(symlinkLocation, "foo", "sdc"),
+		filepath.Join(symlinkLocation, "removed-class", "sdd"),
+	}
+	os.Symlink(device, liveLink)
+	for _, orphan := range orphans {
+		os.Symlink(filepath.Join(tmpDir, "missing"), orphan)
+	}
+
+	d := NewDiskMaker("/tmp/foo", symlinkLocation)
+	d.removeOrphanedLinks()
+
+	if _, err := os.Lstat(liveLink); err != nil {
+		t.Errorf("expected link %s to be kept, got %v", liveLink, err)
+	}
+	for _, orphan := range orphans {
+		if _, err := os.Lstat(orphan); !os.IsNotExist(err) {
+			t.Errorf("expected orphaned link %s to be removed", orphan)
+		}
+	}
+}
+
+func TestRemoveOrphanedLinksFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	if err := os.MkdirAll(filepath.Join(symlinkLocation, "foo"), 0755); err != nil {
+		t.Fatalf("error creating class dir %v", err)
+	}
+	busy := filepath.Join(symlinkLocation, "foo", "sdc")
+	orphan := filepath.Join(symlinkLocation, "foo", "sdd")
+	for _, link := range []string{busy, orphan} {
+		os.Symlink(filepath.Join(tmpDir, "missing"), link)
+	}
+
+	d := NewDiskMaker("/tmp/foo", symlinkLocation)
+	d.fs = &fakeFS{removeErrors: map[string]error{busy: syscall.EBUSY}}
+	d.removeOrphanedLinks()
+
+	if _, err := os.Lstat(busy); err != nil {
+		t.Errorf("expected link %s failing removal to be kept, got %v", busy, err)
+	}
+	if _, err := os.Lstat(orphan); !os.IsNotExist(err) {
+		t.Errorf("expected orphaned link %s to be removed", orphan)
+	}
+}
+
+func TestDisabledStorageClass(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("error writing config %v", err)
+		}
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+
+	writeConfig("foo:\n  disks: [vdb]\n")
+	d.reconcile()
+	vdbLink := filepath.Join(symlinkLocation, "foo", "vdb")
+	if _, err := os.Lstat(vdbLink); err != nil {
+		t.Fatalf("expected vdb to be symlinked, got %v", err)
+	}
+
+	// disabling keeps existing symlinks but claims nothing new
+	writeConfig("foo:\n  disks: [vdb, vdc]\n  enabled: false\n")
+	d.reconcile()
+	if _, err := os.Lstat(vdbLink); err != nil {
+		t.Errorf("expected vdb symlink to be kept, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdc")); !os.IsNotExist(err) {
+		t.Errorf("expected vdc not to be symlinked for disabled storageclass")
+	}
+	if reason := d.Status().SkipReasons["vdc"]; reason != skipDisabled+": foo" {
+		t.Errorf("expected vdc to be skipped as disabled, got %q", reason)
+	}
+
+	writeConfig("foo:\n  disks: [vdb, vdc]\n  enabled: false\n  removeOnDisable: true\n")
+	// neither discovery nor shadow mode remove anything
+	d.Discover()
+	d.ShadowMode = true
+	d.ShadowReportPath = filepath.Join(tmpDir, "report.json")
+	d.reconcile()
+	if _, err := os.Lstat(vdbLink); err != nil {
+		t.Errorf("expected vdb symlink to be kept by discovery and shadow mode, got %v", err)
+	}
+	d.ShadowMode = false
+	d.reconcile()
+	if _, err := os.Lstat(vdbLink); !os.IsNotExist(err) {
+		t.Errorf("expected vdb symlink to be removed")
+	}
+
+	// enabling again claims both devices
+	writeConfig("foo:\n  disks: [vdb, vdc]\n  enabled: true\n")
+	d.reconcile()
+	for _, diskName := range []string{"vdb", "vdc"} {
+		if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", diskName)); err != nil {
+			t.Errorf("expected %s to be symlinked, got %v", diskName, err)
+		}
+	}
+}
+
+func TestKeepDanglingLinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  keepDanglingLinks: true\nbar:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	d.ProtectSwap = false
+	d.reconcile()
+
+	// both devices disappear
+	os.Remove(filepath.Join(tmpDir, "vdb"))
+	os.Remove(filepath.Join(tmpDir, "vdc"))
+	runner.output = ""
+	d.reconcile()
+	d.removeOrphanedLinks()
+
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); err != nil {
+		t.Errorf("expected dangling link of keep class foo to survive, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "bar", "vdc")); !os.IsNotExist(err) {
+		t.Errorf("expected dangling link of bar to be removed")
+	}
+}
+
+func TestDanglingLinkGracePeriod(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	if err := os.MkdirAll(filepath.Join(symlinkLocation, "foo"), 0755); err != nil {
+		t.Fatalf("error creating class dir %v", err)
+	}
+	lostLink := filepath.Join(symlinkLocation, "foo", "sdc")
+	orphan := filepath.Join(symlinkLocation, "foo", "sdd")
+	for _, link := range []string{lostLink, orphan} {
+		os.Symlink(filepath.Join(tmpDir, "missing"), link)
+	}
+
+	d := NewDiskMaker("/tmp/foo", symlinkLocation)
+	d.DanglingLinkGracePeriod = time.Hour
+	d.removeDanglingLink(lostLink)
+	d.removeOrphanedLinks()
+	for _, link := range []string{lostLink, orphan} {
+		if _, err := os.Lstat(link); err != nil {
+			t.Errorf("expected %s missing for less than the grace period to be kept, got %v", link, err)
+		}
+	}
+
+	// both have been missing for longer than the grace period
+	for _, link := range []string{lostLink, orphan} {
+		d.missingSince[link] = time.Now().Add(-2 * time.Hour)
+	}
+	d.removeDanglingLink(lostLink)
+	d.removeOrphanedLinks()
+	for _, link := range []string{lostLink, orphan} {
+		if _, err := os.Lstat(link); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed after the grace period, got %v", link, err)
+		}
+	}
+	if len(d.missingSince) != 0 {
+		t.Errorf("expected removed links to be forgotten, got %v", d.missingSince)
+	}
+}
+
+func TestOrphanCollectionDuringReconcile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  keepDanglingLinks: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+
+	// run with -race, collection and reconciles change symlinks and read the configuration
+	var ticks, wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			d.tick(&ticks)
+			d.runReconcile()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			d.collectOrphanedLinks()
+		}
+	}()
+	wg.Wait()
+	ticks.Wait()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.reconciling || d.reconcilePending {
+		t.Errorf("expected no reconcile to be left running or pending")
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); err != nil {
+		t.Errorf("expected vdb to be symlinked, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package diskmaker
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SMART health of a device, see smartHealth
+const (
+	healthUnknown = "unknown"
+	healthPassed  = "passed"
+	healthFailed  = "failed"
+)
+
+// smartHealth returns the health of a device according to smartctl -H. smartctl exits
+// with a non-zero status for failing devices, so its output is parsed regardless. The
+// health is unknown if smartctl is missing or doesn't support the device.
+func (d *DiskMaker) smartHealth(diskName string) string {
+	out, err := d.run("smartctl", "-H", path.Join("/dev", diskName))
+	for _, line := range strings.Split(string(out), "\n") {
+		// ATA devices report the self-assessment result, SCSI devices the health status
+		if strings.HasPrefix(line, "SMART overall-health self-assessment test result:") || strings.HasPrefix(line, "SMART Health Status:") {
+			result := strings.TrimSpace(line[strings.Index(line, ":")+1:])
+			if result == "PASSED" || result == "OK" {
+				return healthPassed
+			}
+			return healthFailed
+		}
+	}
+	if err != nil {
+		d.throttledWarningf("smartctl/"+diskName, "unable to check health of device %s, claiming it anyway: %v", diskName, err)
+	}
+	return healthUnknown
+}
+
+// ioErrorCount returns the number of I/O errors the SCSI layer counted for a device in
+// device/ioerr_cnt, the one of their disk for partitions. Devices without a readable
+// counter, such as virtio disks, have none.
+func ioErrorCount(blockDevice BlockDevice) int64 {
+	diskName := blockDevice.Name
+	if blockDevice.DiskType == "part" && blockDevice.Parent != "" {
+		diskName = blockDevice.Parent
+	}
+	value, err := readSysfsAttribute(diskName, "device/ioerr_cnt")
+	if err != nil {
+		return 0
+	}
+	// the counter is hexadecimal, such as 0x1a
+	count, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,91 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSkipUnhealthy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vde": "vde"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vde]\n  skipUnhealthy: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	runner := &fakeRunner{
+		output: getData(),
+		outputs: map[string]string{
+			"smartctl -H /dev/vdb": "=== START OF READ SMART DATA SECTION ===\nSMART overall-health self-assessment test result: PASSED\n",
+			"smartctl -H /dev/vdc": "=== START OF READ SMART DATA SECTION ===\nSMART overall-health self-assessment test result: FAILED!\n",
+		},
+		errors: map[string]error{
+			"smartctl -H /dev/vde": fmt.Errorf("exec: \"smartctl\": executable file not found in $PATH"),
+		},
+	}
+	d.runner = runner
+	d.ProtectSwap = false
+	skipped := counterValue(t, unhealthyDevicesSkipped, "foo")
+	d.reconcile()
+
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb", "vde"}) {
+		t.Errorf("expected healthy vdb and vde of unknown health to be claimed, got %v", claimed)
+	}
+	if reason := d.Status().SkipReasons["vdc"]; reason != skipUnhealthy {
+		t.Errorf("expected failing vdc to be skipped as unhealthy, got %q", reason)
+	}
+	if value := counterValue(t, unhealthyDevicesSkipped, "foo"); value != skipped+1 {
+		t.Errorf("expected one unhealthy device to be counted, got %v", value-skipped)
+	}
+
+	// health is not checked unless requested
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vde]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	calls := runner.count("smartctl")
+	d.reconcile()
+	if runner.count("smartctl") != calls {
+		t.Errorf("expected smartctl not to be run without skipUnhealthy")
+	}
+}
+
+func TestSkipErroredDevices(t *testing.T) {
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdb", "device/ioerr_cnt", "0x0")
+	writeSysfsAttribute(t, "vdc", "device/ioerr_cnt", "0x1a")
+	writeSysfsAttribute(t, "vdd", "device/ioerr_cnt", "0x2")
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd", "virtio-vde": "vde"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vdd, vde]\n  skipErroredDevices: true\n  maxIOErrors: 2\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	skipped := counterValue(t, erroredDevicesSkipped, "foo")
+	d.reconcile()
+
+	// vde has no readable counter
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb", "vdd", "vde"}) {
+		t.Errorf("expected vdb, vdd and vde to be claimed, got %v", claimed)
+	}
+	if reason := d.Status().SkipReasons["vdc"]; reason != skipIOErrors+": 26 errors" {
+		t.Errorf("expected vdc to be skipped for its I/O errors, got %q", reason)
+	}
+	if value := counterValue(t, erroredDevicesSkipped, "foo"); value != skipped+1 {
+		t.Errorf("expected one errored device to be counted, got %v", value-skipped)
+	}
+}
@@ -0,0 +1,154 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// historyFileName is the claim history kept in symlinkLocation, one JSON entry per line
+const historyFileName = ".claim-history.jsonl"
+
+// Events recorded in the claim history
+const (
+	historyClaim   = "claim"
+	historyRelease = "release"
+)
+
+// historyMaxBytes caps the size of the claim history. When exceeded the file is
+// rotated to historyFileName.1, replacing any previously rotated history.
+var historyMaxBytes int64 = 10 * 1024 * 1024
+
+// historyEntry is a line of the claim history
+type historyEntry struct {
+	Time         time.Time `json:"time"`
+	Event        string    `json:"event"`
+	Device       string    `json:"device"`
+	DeviceID     string    `json:"deviceID,omitempty"`
+	StorageClass string    `json:"storageClass"`
+}
+
+// recordHistory appends claim events for devices symlinked in current but not in previous
+// reconcile and release events for devices no longer symlinked, and publishes them as
+// ReconcileEvents.
+func (d *DiskMaker) recordHistory(previous, current map[string][]DiskLocation) {
+	now := time.Now()
+	entries := []historyEntry{}
+	for storageClass, deviceArray := range current {
+		for _, deviceLocation := range deviceArray {
+			if !hasLocation(previous[storageClass], deviceLocation) {
+				entries = append(entries, historyEntry{now, historyClaim, deviceLocation.diskName, deviceLocation.diskID, storageClass})
+				d.publishEvent(ReconcileEvent{Type: ReconcileEventClaimed, StorageClass: storageClass, Device: deviceLocation.diskName})
+				d.batchEvent(corev1.EventTypeNormal, disksClaimedReason, "claimed %d devices: %s", storageClass+"/"+deviceLocation.diskName)
+			}
+		}
+	}
+	for storageClass, deviceArray := range previous {
+		for _, deviceLocation := range deviceArray {
+			if !hasLocation(current[storageClass], deviceLocation) {
+				entries = append(entries, historyEntry{now, historyRelease, deviceLocation.diskName, deviceLocation.diskID, storageClass})
+				d.publishEvent(ReconcileEvent{Type: ReconcileEventReleased, StorageClass: storageClass, Device: deviceLocation.diskName})
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+	err := d.appendHistory(entries)
+	if err != nil {
+		d.Log.Errorf("error writing claim history with %v", err)
+	}
+}
+
+func (d *DiskMaker) appendHistory(entries []historyEntry) error {
+	content := []byte{}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		content = append(append(content, line...), '\n')
+	}
+
+	historyPath := path.Join(d.symlinkLocation, historyFileName)
+	if info, err := os.Stat(historyPath); err == nil && info.Size()+int64(len(content)) > historyMaxBytes {
+		err = os.Rename(historyPath, historyPath+".1")
+		if err != nil {
+			return fmt.Errorf("failed to rotate %s with %v", historyPath, err)
+		}
+	}
+	file, err := os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadClaims returns the devices symlinked under symlinkLocation by a previous run,
+// keyed by storageclass. Symlinks whose device no longer exists are left out.
+func (d *DiskMaker) loadClaims() map[string][]DiskLocation {
+	claimed := make(map[string][]DiskLocation)
+	classDirs, err := ioutil.ReadDir(d.symlinkLocation)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.Log.Errorf("error reading symlinks in %s with %v", d.symlinkLocation, err)
+		}
+		return claimed
+	}
+	for _, classDir := range classDirs {
+		if !classDir.IsDir() || strings.HasPrefix(classDir.Name(), ".") {
+			continue
+		}
+		storageClass := classDir.Name()
+		classPath := filepath.Join(d.symlinkLocation, storageClass)
+		filepath.Walk(classPath, func(linkPath string, info os.FileInfo, err error) error {
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+			target, err := d.fs.Readlink(linkPath)
+			if err != nil {
+				return nil
+			}
+			devicePath, err := d.fs.EvalSymlinks(linkPath)
+			if err != nil {
+				return nil
+			}
+			location := DiskLocation{diskName: filepath.Base(devicePath)}
+			if target != path.Join("/dev", location.diskName) {
+				location.diskID = target
+			}
+			relPath, _ := filepath.Rel(classPath, linkPath)
+			if subDir := filepath.Dir(relPath); subDir != "." {
+				location.subDir = subDir
+			}
+			if linkName := filepath.Base(relPath); linkName != location.diskName {
+				location.linkName = linkName
+			}
+			claimed[storageClass] = append(claimed[storageClass], location)
+			return nil
+		})
+	}
+	return claimed
+}
+
+// hasLocation returns whether deviceArray contains the device symlinked to the same
+// target as location
+func hasLocation(deviceArray []DiskLocation, location DiskLocation) bool {
+	for _, deviceLocation := range deviceArray {
+		if deviceLocation.diskName == location.diskName && deviceLocation.target() == location.target() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClaimHistory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	runner := &fakeRunner{output: getData()}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = runner
+	d.ProtectSwap = false
+	d.reconcile()
+	// an unchanged reconcile records nothing
+	d.reconcile()
+	entries := readHistory(t, d)
+	if len(entries) != 1 {
+		t.Fatalf("expected a single history entry, got %v", entries)
+	}
+	if e := entries[0]; e.Event != historyClaim || e.Device != "vdc" || e.StorageClass != "foo" || !strings.HasSuffix(e.DeviceID, "virtio-vdc") {
+		t.Errorf("unexpected claim entry %+v", e)
+	}
+
+	runner.output = strings.Replace(getData(), `MOUNTPOINT="" MODEL="FastSSD "`, `MOUNTPOINT="/mnt" MODEL="FastSSD "`, 1)
+	d.reconcile()
+	entries = readHistory(t, d)
+	if len(entries) != 2 {
+		t.Fatalf("expected two history entries, got %v", entries)
+	}
+	if e := entries[1]; e.Event != historyRelease || e.Device != "vdc" || e.StorageClass != "foo" {
+		t.Errorf("unexpected release entry %+v", e)
+	}
+	if entries[1].Time.Before(entries[0].Time) {
+		t.Errorf("expected entries in chronological order")
+	}
+}
+
+func TestClaimHistoryAfterRestart(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+	if entries := readHistory(t, d); len(entries) != 1 {
+		t.Fatalf("expected a claim entry, got %v", entries)
+	}
+
+	// a restarted diskmaker finds vdc symlinked already
+	recorder := &fakeRecorder{}
+	d = NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.Recorder = recorder
+	d.ProtectSwap = false
+	d.reconcile()
+	if entries := readHistory(t, d); len(entries) != 1 {
+		t.Errorf("expected no new history entries after a restart, got %v", entries)
+	}
+	if events := recorder.withReason(disksClaimedReason); len(events) != 0 {
+		t.Errorf("expected no DisksClaimed event after a restart, got %v", events)
+	}
+}
+
+func TestClaimHistoryRotation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer func(max int64) { historyMaxBytes = max }(historyMaxBytes)
+	historyMaxBytes = 200
+
+	d := NewDiskMaker("/tmp/foo", tmpDir)
+	claimed := map[string][]DiskLocation{"foo": {{diskName: "vdb", diskID: "/dev/disk/by-id/virtio-vdb"}}}
+	for i := 0; i < 3; i++ {
+		d.recordHistory(nil, claimed)
+		d.recordHistory(claimed, nil)
+	}
+	historyPath := filepath.Join(tmpDir, historyFileName)
+	info, err := os.Stat(historyPath)
+	if err != nil {
+		t.Fatalf("expected history file, got %v", err)
+	}
+	if info.Size() > historyMaxBytes {
+		t.Errorf("expected history to be capped at %d bytes, got %d", historyMaxBytes, info.Size())
+	}
+	if _, err := os.Stat(historyPath + ".1"); err != nil {
+		t.Errorf("expected rotated history file, got %v", err)
+	}
+}
+
+func readHistory(t *testing.T, d *DiskMaker) []historyEntry {
+	content, err := ioutil.ReadFile(filepath.Join(d.symlinkLocation, historyFileName))
+	if err != nil {
+		t.Fatalf("error reading history %v", err)
+	}
+	entries := []historyEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		entry := historyEntry{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("error parsing history line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,72 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// holders returns the devices stacked on top of a device according to sysfs, such as
+// md0 for a member of an MD array. Partitions live in the directory of their disk.
+func holders(blockDevice BlockDevice) []string {
+	holdersPath := filepath.Join(sysBlockPath, blockDevice.Name, "holders")
+	if blockDevice.DiskType == "part" && blockDevice.Parent != "" {
+		holdersPath = filepath.Join(sysBlockPath, blockDevice.Parent, blockDevice.Name, "holders")
+	}
+	entries, err := ioutil.ReadDir(holdersPath)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// findStackMembers returns devices that other devices such as MD arrays, DRBD or
+// device-mapper devices are built on, mapped to those devices. Members are found
+// through the parents lsblk reports for devices which are not partitions, and through
+// the holders of devices in sysfs.
+func findStackMembers(allDevices []BlockDevice) map[string][]string {
+	members := make(map[string][]string)
+	for _, blockDevice := range allDevices {
+		if blockDevice.Parent != "" && blockDevice.DiskType != "part" {
+			members[blockDevice.Parent] = append(members[blockDevice.Parent], blockDevice.Name)
+		}
+	}
+	for _, blockDevice := range allDevices {
+		if _, found := members[blockDevice.Name]; found {
+			continue
+		}
+		if names := holders(blockDevice); len(names) > 0 {
+			members[blockDevice.Name] = names
+		}
+	}
+	return members
+}
+
+// excludeStackMembers removes members of stacked devices from deviceSet, together with
+// the disks of member partitions. The stacked devices themselves are kept.
+func (d *DiskMaker) excludeStackMembers(deviceSet map[string]BlockDevice, allDevices []BlockDevice) {
+	members := findStackMembers(allDevices)
+	for _, blockDevice := range allDevices {
+		if blockDevice.DiskType != "part" || blockDevice.Parent == "" {
+			continue
+		}
+		if _, found := members[blockDevice.Name]; found {
+			if _, found := members[blockDevice.Parent]; !found {
+				members[blockDevice.Parent] = []string{blockDevice.Name}
+			}
+		}
+	}
+	for deviceName := range deviceSet {
+		stacked, found := members[deviceName]
+		if !found {
+			continue
+		}
+		d.Log.Infof("ignoring device %s because %s is built on it", deviceName, strings.Join(stacked, ", "))
+		delete(deviceSet, deviceName)
+		d.skipDevice(deviceName, skipStackMember, strings.Join(stacked, ", "))
+	}
+}
@@ -0,0 +1,54 @@
+package diskmaker
+
+import (
+	"testing"
+)
+
+func TestExcludeStackMembers(t *testing.T) {
+	defer fakeSysfs(t)()
+	// drbd0 on vdf and dm-0 on vde1 are only known from sysfs
+	writeSysfsAttribute(t, "vdf", "holders/drbd0", "")
+	writeSysfsAttribute(t, "vde/vde1", "holders/dm-0", "")
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = &fakeRunner{output: `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PKNAME=""
+NAME="md0" MAJ:MIN="9:0" TYPE="raid1" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdb"
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PKNAME=""
+NAME="md0" MAJ:MIN="9:0" TYPE="raid1" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdc"
+NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PKNAME=""
+NAME="vde" MAJ:MIN="252:64" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PKNAME=""
+NAME="vde1" MAJ:MIN="252:65" TYPE="part" SIZE="10736369664" MOUNTPOINT="" PKNAME="vde"
+NAME="vdf" MAJ:MIN="252:80" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PKNAME=""`}
+	d.ProtectSwap = false
+	_, deviceSet, ok := d.findCandidateDisks()
+	if !ok {
+		t.Fatalf("expected candidate disks to be found")
+	}
+	expected := map[string]string{
+		"vdb":  "md0",
+		"vdc":  "md0",
+		"vde1": "dm-0",
+		// the disk of a member partition
+		"vde": "vde1",
+		"vdf": "drbd0",
+	}
+	for diskName, holder := range expected {
+		if _, found := deviceSet[diskName]; found {
+			t.Errorf("expected member %s to be excluded", diskName)
+		}
+		if reason := d.skipReasons[diskName]; reason != skipStackMember+": "+holder {
+			t.Errorf("expected %s to be skipped as member of %s, got %q", diskName, holder, reason)
+		}
+	}
+	for _, diskName := range []string{"md0", "vdd"} {
+		if _, found := deviceSet[diskName]; !found {
+			t.Errorf("expected %s to be kept, got %v", diskName, deviceSet)
+		}
+	}
+
+	d.ExcludeStackMembers = false
+	_, deviceSet, _ = d.findCandidateDisks()
+	if _, found := deviceSet["vdb"]; !found {
+		t.Errorf("expected vdb to be kept when stack members are not excluded")
+	}
+}
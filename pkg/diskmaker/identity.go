@@ -0,0 +1,68 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Sources the node name can be resolved from, see IdentityProvider
+const (
+	IdentitySourceConfig      = "config"
+	IdentitySourceEnv         = "env"
+	IdentitySourceDownwardAPI = "downward-api"
+	IdentitySourceHostname    = "hostname"
+)
+
+// nodeNameEnvVars are the environment variables holding the node name, MY_NODE_NAME
+// being the one set by the daemonsets of the operator
+var nodeNameEnvVars = []string{"NODE_NAME", "MY_NODE_NAME"}
+
+// hostname returns the hostname of the node, it is replaced by tests
+var hostname = os.Hostname
+
+// IdentityProvider resolves the name of the node the diskmaker runs on, which names
+// the custom resource whose status is reported, see DiskMaker.NodeName
+type IdentityProvider struct {
+	// NodeName, if set, is the node name regardless of the other sources
+	NodeName string
+	// DownwardAPIPath is an optional file holding the node name, such as one mounted
+	// through the Downward API
+	DownwardAPIPath string
+}
+
+// Identity is the node name resolved by an IdentityProvider
+type Identity struct {
+	Name string
+	// Source is where the name was found, see IdentitySourceConfig
+	Source string
+}
+
+// Resolve returns the node name from, in order, IdentityProvider.NodeName, the
+// NODE_NAME or MY_NODE_NAME environment variables, the DownwardAPIPath file, or the
+// hostname. Sources which are unset or empty are skipped.
+func (p *IdentityProvider) Resolve() (Identity, error) {
+	if p.NodeName != "" {
+		return Identity{p.NodeName, IdentitySourceConfig}, nil
+	}
+	for _, envVar := range nodeNameEnvVars {
+		if name := strings.TrimSpace(os.Getenv(envVar)); name != "" {
+			return Identity{name, IdentitySourceEnv}, nil
+		}
+	}
+	if p.DownwardAPIPath != "" {
+		content, err := ioutil.ReadFile(p.DownwardAPIPath)
+		if err != nil && !os.IsNotExist(err) {
+			return Identity{}, fmt.Errorf("error reading node name from %s with %v", p.DownwardAPIPath, err)
+		}
+		if name := strings.TrimSpace(string(content)); name != "" {
+			return Identity{name, IdentitySourceDownwardAPI}, nil
+		}
+	}
+	name, err := hostname()
+	if err != nil {
+		return Identity{}, fmt.Errorf("error getting hostname with %v", err)
+	}
+	return Identity{name, IdentitySourceHostname}, nil
+}
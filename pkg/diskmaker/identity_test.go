@@ -0,0 +1,97 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityProvider(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	nodeNameFile := filepath.Join(tmpDir, "nodename")
+	if err := ioutil.WriteFile(nodeNameFile, []byte("node-file\n"), 0644); err != nil {
+		t.Fatalf("error writing node name %v", err)
+	}
+	oldHostname := hostname
+	hostname = func() (string, error) { return "node-hostname", nil }
+	defer func() { hostname = oldHostname }()
+
+	tests := []struct {
+		name     string
+		provider IdentityProvider
+		env      map[string]string
+		expected Identity
+	}{
+		{
+			name:     "config",
+			provider: IdentityProvider{NodeName: "node-config", DownwardAPIPath: nodeNameFile},
+			env:      map[string]string{"NODE_NAME": "node-env"},
+			expected: Identity{"node-config", IdentitySourceConfig},
+		},
+		{
+			name:     "env",
+			provider: IdentityProvider{DownwardAPIPath: nodeNameFile},
+			env:      map[string]string{"NODE_NAME": "node-env", "MY_NODE_NAME": "node-legacy"},
+			expected: Identity{"node-env", IdentitySourceEnv},
+		},
+		{
+			name:     "legacy env",
+			provider: IdentityProvider{DownwardAPIPath: nodeNameFile},
+			env:      map[string]string{"MY_NODE_NAME": "node-legacy"},
+			expected: Identity{"node-legacy", IdentitySourceEnv},
+		},
+		{
+			name:     "downward api",
+			provider: IdentityProvider{DownwardAPIPath: nodeNameFile},
+			expected: Identity{"node-file", IdentitySourceDownwardAPI},
+		},
+		{
+			name:     "missing downward api file",
+			provider: IdentityProvider{DownwardAPIPath: filepath.Join(tmpDir, "missing")},
+			expected: Identity{"node-hostname", IdentitySourceHostname},
+		},
+		{
+			name:     "hostname",
+			expected: Identity{"node-hostname", IdentitySourceHostname},
+		},
+	}
+	for _, envVar := range nodeNameEnvVars {
+		if oldValue, found := os.LookupEnv(envVar); found {
+			defer os.Setenv(envVar, oldValue)
+		} else {
+			defer os.Unsetenv(envVar)
+		}
+	}
+	for _, test := range tests {
+		for _, envVar := range nodeNameEnvVars {
+			os.Setenv(envVar, test.env[envVar])
+		}
+		identity, err := test.provider.Resolve()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if identity != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, identity)
+		}
+	}
+
+	hostname = func() (string, error) { return "", fmt.Errorf("no hostname") }
+	if _, err := (&IdentityProvider{}).Resolve(); err == nil {
+		t.Errorf("expected an error without any node name")
+	}
+}
+
+func TestStatusNodeName(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.NodeName = "node-1"
+	if name := d.Status().NodeName; name != "node-1" {
+		t.Errorf("expected node-1 in status, got %q", name)
+	}
+}
@@ -0,0 +1,49 @@
+package diskmaker
+
+// Device states of the inventory metrics
+const (
+	inventoryAvailable = "available"
+	inventoryInUse     = "in-use"
+)
+
+// takeInventory lists block devices to update the inventory metrics and status without
+// claiming any, see DiskMaker.MetricsOnly
+func (d *DiskMaker) takeInventory() {
+	_, _, ok := d.findCandidateDisks()
+	if !ok {
+		return
+	}
+	d.updateStatus(nil)
+}
+
+// recordInventory exports the number and size of scanned block devices by type, counting
+// the candidates for claiming in deviceSet as available and the others as in use, and
+// keeps their attributes for Status.Devices
+func (d *DiskMaker) recordInventory(allDevices []BlockDevice, deviceSet map[string]BlockDevice) {
+	inventoryDevices.Reset()
+	inventoryBytes.Reset()
+	d.devices = make(map[string]Device)
+	for _, blockDevice := range allDevices {
+		// stacked devices are listed once per parent
+		if _, seen := d.devices[blockDevice.Name]; seen || !d.settings.scanned(blockDevice.Name) {
+			continue
+		}
+		size, err := blockDevice.sizeBytes()
+		d.devices[blockDevice.Name] = Device{
+			Type:       blockDevice.DiskType,
+			Size:       size,
+			Model:      blockDevice.Model,
+			Transport:  blockDevice.Transport,
+			Rotational: blockDevice.Rotational,
+			FSType:     blockDevice.FSType,
+		}
+		state := inventoryInUse
+		if _, found := deviceSet[blockDevice.Name]; found {
+			state = inventoryAvailable
+		}
+		inventoryDevices.WithLabelValues(blockDevice.DiskType, state).Inc()
+		if err == nil {
+			inventoryBytes.WithLabelValues(blockDevice.DiskType, state).Add(float64(size))
+		}
+	}
+}
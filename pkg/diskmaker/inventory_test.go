@@ -0,0 +1,48 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetricsOnly(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.MetricsOnly = true
+	d.reconcile()
+
+	if _, err := os.Lstat(symlinkLocation); !os.IsNotExist(err) {
+		t.Errorf("expected no symlinks to be created, got %v", err)
+	}
+	// the partitions of sda are mounted, the disks are available
+	if value := gaugeValue(t, inventoryDevices, "disk", inventoryAvailable); value != 7 {
+		t.Errorf("expected 7 available disks, got %v", value)
+	}
+	if value := gaugeValue(t, inventoryDevices, "part", inventoryInUse); value != 3 {
+		t.Errorf("expected 3 partitions in use, got %v", value)
+	}
+	if value := gaugeValue(t, inventoryBytes, "disk", inventoryAvailable); value != 107374182400+5*10737418240+1099511627776 {
+		t.Errorf("unexpected size of available disks %v", value)
+	}
+	status := d.Status()
+	if len(status.Claimed) != 0 {
+		t.Errorf("expected nothing to be claimed, got %v", status.Claimed)
+	}
+	if status.LastReconcile.IsZero() || status.SkipReasons["sda1"] != skipMounted+": /boot" {
+		t.Errorf("expected status to be updated, got %+v", status)
+	}
+}
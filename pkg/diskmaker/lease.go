@@ -0,0 +1,33 @@
+package diskmaker
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// acquireLease takes an exclusive flock on LeasePath, creating the file if needed, so
+// that only one of several diskmakers sharing it reconciles at a time. It returns a
+// function releasing the lease, or false if another diskmaker holds it or it could not
+// be taken.
+func (d *DiskMaker) acquireLease() (func(), bool) {
+	file, err := os.OpenFile(d.LeasePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		d.Log.Errorf("skipping reconcile, error opening lease %s with %v", d.LeasePath, err)
+		return nil, false
+	}
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err != nil {
+		file.Close()
+		if err == unix.EWOULDBLOCK {
+			d.throttledWarningf("lease-held", "skipping reconcile, lease %s is held by another diskmaker", d.LeasePath)
+		} else {
+			d.Log.Errorf("skipping reconcile, error locking lease %s with %v", d.LeasePath, err)
+		}
+		return nil, false
+	}
+	return func() {
+		unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		file.Close()
+	}, true
+}
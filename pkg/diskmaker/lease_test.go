@@ -0,0 +1,61 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLease(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	leasePath := filepath.Join(tmpDir, "lease")
+	newDiskMaker := func() (*DiskMaker, *fakeRunner) {
+		d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+		runner := &fakeRunner{output: getData()}
+		d.runner = runner
+		d.ProtectSwap = false
+		d.LeasePath = leasePath
+		return d, runner
+	}
+	first, _ := newDiskMaker()
+	second, secondRunner := newDiskMaker()
+
+	// the first diskmaker holds the lease while the second one reconciles
+	release, acquired := first.acquireLease()
+	if !acquired {
+		t.Fatalf("expected the first diskmaker to acquire the lease")
+	}
+	second.reconcile()
+	if secondRunner.count("lsblk") != 0 {
+		t.Errorf("expected the second diskmaker to skip reconcile while the lease is held, got calls %v", secondRunner.calls)
+	}
+	if !second.Status().LastReconcile.IsZero() {
+		t.Errorf("expected status of the second diskmaker not to be updated")
+	}
+
+	release()
+	second.reconcile()
+	if secondRunner.count("lsblk") != 1 {
+		t.Errorf("expected the second diskmaker to reconcile once the lease is released, got calls %v", secondRunner.calls)
+	}
+	if claimed := second.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected vdb to be claimed, got %v", claimed)
+	}
+
+	// the lease is released at the end of every reconcile
+	release, acquired = first.acquireLease()
+	if !acquired {
+		t.Fatalf("expected the lease to be released after reconcile")
+	}
+	release()
+}
@@ -0,0 +1,90 @@
+package diskmaker
+
+import (
+	"os"
+	"path"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// handleLostDevices reports devices that were symlinked in the previous reconcile but
+// are no longer present on the node, since PVs backed by them may be at risk, and
+// removes their dangling symlinks.
+func (d *DiskMaker) handleLostDevices(presentDevices sets.String) {
+	for storageClass, deviceArray := range d.claimed {
+		for _, deviceLocation := range deviceArray {
+			diskName := deviceLocation.diskName
+			if presentDevices.Has(diskName) {
+				continue
+			}
+			d.batchEvent(corev1.EventTypeWarning, claimedDeviceLostReason, "%d claimed devices are no longer present: %s", storageClass+"/"+diskName)
+			claimedDeviceLost.WithLabelValues(storageClass).Inc()
+			d.publishEvent(ReconcileEvent{Type: ReconcileEventMissing, StorageClass: storageClass, Device: diskName})
+			if !d.keepsDanglingLinks(storageClass) {
+				d.removeDanglingLink(path.Join(d.symlinkLocation, storageClass, deviceLocation.symlinkName()))
+			}
+		}
+	}
+}
+
+// removeDanglingLink removes symlink linkPath if its target does not exist
+func (d *DiskMaker) removeDanglingLink(linkPath string) {
+	if _, err := d.fs.Lstat(linkPath); err != nil {
+		d.forgetMissing(linkPath)
+		return
+	}
+	if _, err := os.Stat(linkPath); !os.IsNotExist(err) {
+		d.forgetMissing(linkPath)
+		return
+	}
+	if !d.graceElapsed(linkPath) {
+		return
+	}
+	d.Log.Infof("removing dangling symlink %s", linkPath)
+	err := d.fs.Remove(linkPath)
+	if err != nil {
+		d.Log.Errorf("error removing dangling symlink %s with %v", linkPath, err)
+		return
+	}
+	d.forgetMissing(linkPath)
+	d.removeMeta(linkPath)
+}
+
+// graceElapsed records since when symlink linkPath is dangling and returns whether it
+// has been for DanglingLinkGracePeriod, so that devices briefly disappearing keep
+// their symlinks
+func (d *DiskMaker) graceElapsed(linkPath string) bool {
+	if d.DanglingLinkGracePeriod <= 0 {
+		return true
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	since, ok := d.missingSince[linkPath]
+	if !ok {
+		since = time.Now()
+		d.missingSince[linkPath] = since
+	}
+	if missing := time.Since(since); missing < d.DanglingLinkGracePeriod {
+		d.Log.Infof("keeping dangling symlink %s, its device is missing for %v only", linkPath, missing.Round(time.Second))
+		return false
+	}
+	return true
+}
+
+// forgetMissing stops tracking symlink linkPath as dangling
+func (d *DiskMaker) forgetMissing(linkPath string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.missingSince, linkPath)
+}
+
+// presentDeviceNames returns names of all devices listed by lsblk, mounted or not
+func presentDeviceNames(content string) sets.String {
+	names := sets.NewString()
+	for _, blockDevice := range parseBlockDevices(content) {
+		names.Insert(blockDevice.Name)
+	}
+	return names
+}
@@ -0,0 +1,102 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestClaimedDeviceLost(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	recorder := &fakeRecorder{}
+	runner := &fakeRunner{output: getData()}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = runner
+	d.Recorder = recorder
+	d.ProtectSwap = false
+	d.reconcile()
+	linkPath := filepath.Join(tmpDir, "local-storage", "foo", "vdc")
+	if _, err := os.Stat(linkPath); err != nil {
+		t.Fatalf("expected vdc to be symlinked, got %v", err)
+	}
+
+	lostBefore := counterValue(t, claimedDeviceLost, "foo")
+	// pull the drive
+	os.Remove(filepath.Join(tmpDir, "vdc"))
+	runner.output = strings.Replace(getData(), `NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" MODEL="FastSSD "`, "", 1)
+	d.reconcile()
+
+	if events := recorder.withReason(claimedDeviceLostReason); len(events) != 1 || events[0] != "Warning ClaimedDeviceLost 1 claimed devices are no longer present: foo/vdc" {
+		t.Errorf("expected a ClaimedDeviceLost event for vdc, got %v", recorder.events)
+	}
+	if lost := counterValue(t, claimedDeviceLost, "foo") - lostBefore; lost != 1 {
+		t.Errorf("expected lost counter to increase by 1, got %v", lost)
+	}
+	if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+		t.Errorf("expected dangling symlink %s to be removed", linkPath)
+	}
+
+	// the device is reported once, not on every reconcile
+	d.reconcile()
+	if events := recorder.withReason(claimedDeviceLostReason); len(events) != 1 {
+		t.Errorf("expected a single event, got %v", recorder.events)
+	}
+}
+
+func counterValue(t *testing.T, counter *prometheus.CounterVec, labels ...string) float64 {
+	metric := &dto.Metric{}
+	if err := counter.WithLabelValues(labels...).Write(metric); err != nil {
+		t.Fatalf("error reading metric %v", err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func gaugeValue(t *testing.T, gauge *prometheus.GaugeVec, labels ...string) float64 {
+	metric := &dto.Metric{}
+	if err := gauge.WithLabelValues(labels...).Write(metric); err != nil {
+		t.Fatalf("error reading metric %v", err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+// fakeRecorder records events as "type reason message"
+type fakeRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (f *fakeRecorder) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.events = append(f.events, fmt.Sprintf("%s %s %s", eventType, reason, fmt.Sprintf(messageFmt, args...)))
+}
+
+// withReason returns the recorded events of reason
+func (f *fakeRecorder) withReason(reason string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	events := []string{}
+	for _, event := range f.events {
+		if strings.Fields(event)[1] == reason {
+			events = append(events, event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,82 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// markerSuffix is appended to the symlink name of a device to name its marker file
+const markerSuffix = ".marker"
+
+// marker is the content of the file written instead of a symlink in marker claim mode
+type marker struct {
+	Name string `json:"name"`
+	ID   string `json:"id,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+func (d *DiskMaker) markerPath(storageClass string, location DiskLocation) string {
+	return path.Join(d.symlinkLocation, storageClass, location.symlinkName()+markerSuffix)
+}
+
+// writeMarker records a device matched by a storageclass in marker claim mode
+func (d *DiskMaker) writeMarker(storageClass string, location DiskLocation) error {
+	markerPath := d.markerPath(storageClass, location)
+	err := d.fs.MkdirAll(path.Dir(markerPath), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating marker directory %s with %v", path.Dir(markerPath), err)
+	}
+	content, err := json.Marshal(marker{Name: location.diskName, ID: location.diskID, Size: location.size})
+	if err != nil {
+		return err
+	}
+	if existing, err := ioutil.ReadFile(markerPath); err == nil && string(existing) == string(content) {
+		return nil
+	}
+	d.Log.Infof("writing marker %s for device %s", markerPath, location.diskName)
+	err = d.fs.WriteFile(markerPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing marker %s with %v", markerPath, err)
+	}
+	return nil
+}
+
+// marksDevices returns whether storageClass is in marker claim mode. Marked devices are
+// not claimed, they count neither towards MaxTotalSize nor in the claim history.
+func (d *DiskMaker) marksDevices(storageClass string) bool {
+	disks := d.diskConfig[storageClass]
+	return disks != nil && disks.ClaimMode == ClaimModeMarker
+}
+
+// removeStaleMarkers removes marker files of devices no longer matched by storageclasses
+// in marker claim mode, and all marker files of storageclasses in symlink claim mode.
+// Symlinks of storageclasses switched to marker claim mode are removed too.
+func (d *DiskMaker) removeStaleMarkers(diskConfig DiskConfig, marked map[string][]DiskLocation) {
+	for storageClass := range diskConfig {
+		if d.marksDevices(storageClass) {
+			d.removeClassLinks(storageClass)
+		}
+		current := sets.NewString()
+		for _, deviceLocation := range marked[storageClass] {
+			current.Insert(d.markerPath(storageClass, deviceLocation))
+		}
+		classDir := path.Join(d.symlinkLocation, storageClass)
+		filepath.Walk(classDir, func(markerPath string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || !strings.HasSuffix(markerPath, markerSuffix) || current.Has(markerPath) {
+				return nil
+			}
+			d.Log.Infof("removing stale marker %s", markerPath)
+			if err := d.fs.Remove(markerPath); err != nil {
+				d.Log.Errorf("error removing marker %s with %v", markerPath, err)
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarkerClaimMode(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n  claimMode: marker\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	for _, diskName := range []string{"vdb", "vdc"} {
+		if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", diskName)); !os.IsNotExist(err) {
+			t.Errorf("expected no symlink for %s in marker mode, got %v", diskName, err)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(symlinkLocation, "foo", diskName+markerSuffix))
+		if err != nil {
+			t.Errorf("expected marker for %s, got %v", diskName, err)
+			continue
+		}
+		var m marker
+		if err := json.Unmarshal(content, &m); err != nil {
+			t.Fatalf("error parsing marker %v", err)
+		}
+		if m.Name != diskName || m.ID != filepath.Join(tmpDir, "by-id", "virtio-"+diskName) || m.Size != 10737418240 {
+			t.Errorf("unexpected marker of %s: %+v", diskName, m)
+		}
+	}
+	// marked devices are not claimed
+	status := d.Status()
+	if len(status.Claimed) != 0 || status.SkipReasons["vdb"] != skipMarked+": foo" {
+		t.Errorf("expected marked devices not to be claimed, got %v %v", status.Claimed, status.SkipReasons)
+	}
+	if _, err := os.Stat(filepath.Join(symlinkLocation, historyFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected no claim history of marked devices, got %v", err)
+	}
+
+	// markers of devices no longer matched are removed
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  claimMode: marker\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	if _, err := os.Stat(filepath.Join(symlinkLocation, "foo", "vdc"+markerSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected stale marker of vdc to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(symlinkLocation, "foo", "vdb"+markerSuffix)); err != nil {
+		t.Errorf("expected marker of vdb to be kept, got %v", err)
+	}
+}
+
+func TestClaimModeChange(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("error writing config %v", err)
+		}
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	linkPath := filepath.Join(symlinkLocation, "foo", "vdb")
+	markerPath := linkPath + markerSuffix
+
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	writeConfig("foo:\n  disks: [vdb]\n  claimMode: marker\n")
+	d.reconcile()
+	if _, err := os.Stat(markerPath); err != nil {
+		t.Fatalf("expected marker of vdb, got %v", err)
+	}
+
+	// switching to symlink mode removes the marker
+	writeConfig("foo:\n  disks: [vdb]\n")
+	d.reconcile()
+	if _, err := os.Lstat(linkPath); err != nil {
+		t.Errorf("expected vdb to be symlinked, got %v", err)
+	}
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("expected marker of vdb to be removed, got %v", err)
+	}
+
+	// switching back to marker mode removes the symlink
+	writeConfig("foo:\n  disks: [vdb]\n  claimMode: marker\n")
+	d.reconcile()
+	if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+		t.Errorf("expected symlink of vdb to be removed, got %v", err)
+	}
+	if _, err := os.Stat(markerPath); err != nil {
+		t.Errorf("expected marker of vdb, got %v", err)
+	}
+}
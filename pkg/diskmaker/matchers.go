@@ -0,0 +1,306 @@
+package diskmaker
+
+import (
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Matcher decides whether a candidate device belongs to a storageclass
+type Matcher interface {
+	Matches(device BlockDevice) bool
+}
+
+// matchContext holds what matcher factories need to know about the node
+type matchContext struct {
+	d *DiskMaker
+	// deviceSet contains candidate devices keyed by name
+	deviceSet map[string]BlockDevice
+	// byIDIndex maps device names to their /dev/disk/by-id paths
+	byIDIndex map[string][]string
+}
+
+// matcherFactory builds a Matcher for one match criterion.
+// It returns nil if the criterion is not set.
+type matcherFactory struct {
+	build func(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher
+	// allowsFormatted is set for criteria that target formatted devices by definition
+	allowsFormatted bool
+}
+
+// matcherRegistry holds all known match criteria
+var matcherRegistry = map[string]matcherFactory{}
+
+func registerMatcher(name string, factory matcherFactory) {
+	matcherRegistry[name] = factory
+}
+
+func init() {
+	registerMatcher("disks", matcherFactory{build: newNameMatcher})
+	registerMatcher("deviceIDs", matcherFactory{build: newDeviceIDMatcher})
+	registerMatcher("deviceNumbers", matcherFactory{build: newDeviceNumberMatcher})
+	registerMatcher("fsLabels", matcherFactory{build: newLabelMatcher, allowsFormatted: true})
+	registerMatcher("hctl", matcherFactory{build: newHCTLMatcher})
+	registerMatcher("models", matcherFactory{build: newModelMatcher})
+	registerMatcher("size", matcherFactory{build: newSizeMatcher})
+}
+
+// classMatcher combines the matchers of all criteria configured for a storageclass
+type classMatcher struct {
+	any Matcher
+	// formatted matches devices that may be claimed despite having a filesystem
+	formatted Matcher
+}
+
+// newClassMatcher returns a matcher for a storageclass. The flat criteria of the
+// storageclass and its match expression are alternatives, any of which selects a device.
+func (ctx *matchContext) newClassMatcher(storageClass string, disks *Disks) classMatcher {
+	matchers, formattedMatchers := ctx.newCriteriaMatchers(storageClass, disks.criteria())
+	any := anyMatcher(matchers)
+	formatted := anyMatcher(formattedMatchers)
+	if disks.MatchExpression != nil {
+		expressionMatcher, expressionFormatted := ctx.newExpressionMatcher(storageClass, disks.MatchExpression)
+		any = append(any, expressionMatcher)
+		formatted = append(formatted, expressionFormatted...)
+	}
+	return classMatcher{any: any, formatted: formatted}
+}
+
+// newCriteriaMatchers returns matchers of all set criteria, and separately
+// those which allow formatted devices
+func (ctx *matchContext) newCriteriaMatchers(storageClass string, criteria *MatchCriteria) ([]Matcher, []Matcher) {
+	matchers := []Matcher{}
+	formattedMatchers := []Matcher{}
+	names := []string{}
+	for name := range matcherRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		factory := matcherRegistry[name]
+		matcher := factory.build(ctx, storageClass, criteria)
+		if matcher == nil {
+			continue
+		}
+		matchers = append(matchers, matcher)
+		if factory.allowsFormatted {
+			formattedMatchers = append(formattedMatchers, matcher)
+		}
+	}
+	return matchers, formattedMatchers
+}
+
+// newExpressionMatcher builds a matcher for a match expression, see MatchExpression
+func (ctx *matchContext) newExpressionMatcher(storageClass string, expression *MatchExpression) (Matcher, []Matcher) {
+	matchers, formattedMatchers := ctx.newCriteriaMatchers(storageClass, &expression.MatchCriteria)
+	all := allMatcher(matchers)
+	for _, and := range expression.And {
+		matcher, formatted := ctx.newExpressionMatcher(storageClass, and)
+		all = append(all, matcher)
+		formattedMatchers = append(formattedMatchers, formatted...)
+	}
+	if len(expression.Or) > 0 {
+		any := anyMatcher{}
+		for _, or := range expression.Or {
+			matcher, formatted := ctx.newExpressionMatcher(storageClass, or)
+			any = append(any, matcher)
+			formattedMatchers = append(formattedMatchers, formatted...)
+		}
+		all = append(all, any)
+	}
+	return all, formattedMatchers
+}
+
+// anyMatcher matches devices matched by at least one of its matchers
+type anyMatcher []Matcher
+
+func (m anyMatcher) Matches(device BlockDevice) bool {
+	for _, matcher := range m {
+		if matcher.Matches(device) {
+			return true
+		}
+	}
+	return false
+}
+
+// allMatcher matches devices matched by all of its matchers
+type allMatcher []Matcher
+
+func (m allMatcher) Matches(device BlockDevice) bool {
+	for _, matcher := range m {
+		if !matcher.Matches(device) {
+			return false
+		}
+	}
+	return len(m) > 0
+}
+
+// nameSetMatcher matches devices by kernel name
+type nameSetMatcher struct {
+	names sets.String
+}
+
+func (m nameSetMatcher) Matches(device BlockDevice) bool {
+	return m.names.Has(device.Name)
+}
+
+func newNameMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if len(criteria.DiskNames) == 0 {
+		return nil
+	}
+	return nameSetMatcher{sets.NewString(criteria.DiskNames...)}
+}
+
+// deviceIDMatcher matches devices having a /dev/disk/by-id entry matching one of
+// the patterns. Patterns without wildcards match exactly.
+type deviceIDMatcher struct {
+	patterns  []string
+	byIDIndex map[string][]string
+}
+
+func (m deviceIDMatcher) Matches(device BlockDevice) bool {
+	return m.matchingID(device.Name) != ""
+}
+
+// matchingID returns the first by-id path of device matching one of the patterns
+func (m deviceIDMatcher) matchingID(diskName string) string {
+	for _, idPath := range m.byIDIndex[diskName] {
+		for _, pattern := range m.patterns {
+			if matched, _ := filepath.Match(pattern, filepath.Base(idPath)); matched {
+				return idPath
+			}
+		}
+	}
+	return ""
+}
+
+func newDeviceIDMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if len(criteria.DeviceIDs) == 0 {
+		return nil
+	}
+	matcher := deviceIDMatcher{patterns: idPatterns(criteria.DeviceIDs), byIDIndex: ctx.byIDIndex}
+	for _, pattern := range matcher.patterns {
+		if !ctx.anyIDMatches(pattern) {
+			ctx.d.throttledErrorf("device-id/"+pattern, "unable to find disk-ids matching %s for storageclass %s", pattern, storageClass)
+		}
+	}
+	return matcher
+}
+
+// idPatterns returns by-id patterns for deviceIDs, which may be complete
+// /dev/disk/by-id paths
+func idPatterns(deviceIDs []string) []string {
+	patterns := []string{}
+	for _, deviceID := range deviceIDs {
+		patterns = append(patterns, filepath.Base(deviceID))
+	}
+	return patterns
+}
+
+func (ctx *matchContext) anyIDMatches(pattern string) bool {
+	matcher := deviceIDMatcher{patterns: []string{pattern}, byIDIndex: ctx.byIDIndex}
+	for diskName := range ctx.byIDIndex {
+		if matcher.matchingID(diskName) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func newDeviceNumberMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if len(criteria.DeviceNumbers) == 0 {
+		return nil
+	}
+	names := sets.NewString()
+	for _, deviceNumber := range criteria.DeviceNumbers {
+		diskName := findDeviceByNumber(ctx.deviceSet, deviceNumber)
+		if diskName == "" {
+			ctx.d.throttledErrorf("device-number/"+deviceNumber, "unable to find device with number %s", deviceNumber)
+			continue
+		}
+		names.Insert(diskName)
+	}
+	return nameSetMatcher{names}
+}
+
+func newLabelMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if len(criteria.FSLabels) == 0 {
+		return nil
+	}
+	names := sets.NewString()
+	for _, label := range criteria.FSLabels {
+		diskName, err := ctx.d.findDeviceByLabel(label)
+		if err != nil {
+			ctx.d.throttledErrorf("label/"+label, "unable to add device with label %s to local disk pool %v", label, err)
+			continue
+		}
+		names.Insert(diskName)
+	}
+	return nameSetMatcher{names}
+}
+
+// hctlMatcher matches devices by their SCSI address
+type hctlMatcher struct {
+	addresses sets.String
+}
+
+func (m hctlMatcher) Matches(device BlockDevice) bool {
+	return device.HCTL != "" && m.addresses.Has(device.HCTL)
+}
+
+func newHCTLMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if len(criteria.HCTL) == 0 {
+		return nil
+	}
+	return hctlMatcher{sets.NewString(criteria.HCTL...)}
+}
+
+// modelMatcher matches devices by model, patterns may contain wildcards
+type modelMatcher struct {
+	patterns []string
+}
+
+func (m modelMatcher) Matches(device BlockDevice) bool {
+	for _, pattern := range m.patterns {
+		if matched, _ := filepath.Match(pattern, device.Model); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func newModelMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if len(criteria.Models) == 0 {
+		return nil
+	}
+	return modelMatcher{criteria.Models}
+}
+
+// sizeMatcher matches devices whose size is within a range, either bound may be nil
+type sizeMatcher struct {
+	minSize *resource.Quantity
+	maxSize *resource.Quantity
+}
+
+func (m sizeMatcher) Matches(device BlockDevice) bool {
+	size, err := device.sizeBytes()
+	if err != nil {
+		return false
+	}
+	if m.minSize != nil && size < m.minSize.Value() {
+		return false
+	}
+	if m.maxSize != nil && size > m.maxSize.Value() {
+		return false
+	}
+	return true
+}
+
+func newSizeMatcher(ctx *matchContext, storageClass string, criteria *MatchCriteria) Matcher {
+	if criteria.MinSize == nil && criteria.MaxSize == nil {
+		return nil
+	}
+	return sizeMatcher{criteria.MinSize, criteria.MaxSize}
+}
@@ -0,0 +1,155 @@
+package diskmaker
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestMatchers(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = &fakeRunner{outputs: map[string]string{"blkid -o device -t LABEL=scratch": "/dev/vdf\n"}}
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	ctx := &matchContext{
+		d:         d,
+		deviceSet: deviceSet,
+		byIDIndex: map[string][]string{
+			"vdc": {"/dev/disk/by-id/virtio-serial-c"},
+			"vdd": {"/dev/disk/by-id/wwn-0x5000", "/dev/disk/by-id/virtio-serial-d"},
+		},
+	}
+	tests := []struct {
+		name     string
+		factory  string
+		criteria *MatchCriteria
+		expected []string
+	}{
+		{"names", "disks", &MatchCriteria{DiskNames: []string{"vdb", "sdz"}}, []string{"vdb"}},
+		{"exact id", "deviceIDs", &MatchCriteria{DeviceIDs: []string{"virtio-serial-c"}}, []string{"vdc"}},
+		{"id path", "deviceIDs", &MatchCriteria{DeviceIDs: []string{"/dev/disk/by-id/wwn-0x5000"}}, []string{"vdd"}},
+		{"id glob", "deviceIDs", &MatchCriteria{DeviceIDs: []string{"virtio-serial-*"}}, []string{"vdc", "vdd"}},
+		{"device numbers", "deviceNumbers", &MatchCriteria{DeviceNumbers: []string{"252:64"}}, []string{"vde"}},
+		{"labels", "fsLabels", &MatchCriteria{FSLabels: []string{"scratch"}}, []string{"vdf"}},
+		{"hctl", "hctl", &MatchCriteria{HCTL: []string{"0:0:1:0", "1:0:0:0"}}, []string{"vde"}},
+		{"models", "models", &MatchCriteria{Models: []string{"Fast*"}}, []string{"vdc", "vdd"}},
+		{"min size", "size", &MatchCriteria{MinSize: quantity("500Gi")}, []string{"vdd"}},
+		{"max size", "size", &MatchCriteria{MaxSize: quantity("10Gi")}, []string{"vda", "vdb", "vdc", "vde", "vdf"}},
+	}
+	for _, test := range tests {
+		matcher := matcherRegistry[test.factory].build(ctx, "foo", test.criteria)
+		if matcher == nil {
+			t.Fatalf("%s: expected a matcher", test.name)
+		}
+		matched := []string{}
+		for _, diskName := range []string{"sda", "vda", "vdb", "vdc", "vdd", "vde", "vdf"} {
+			if matcher.Matches(deviceSet[diskName]) {
+				matched = append(matched, diskName)
+			}
+		}
+		if !equalStrings(matched, test.expected) {
+			t.Errorf("%s: expected %v to match, got %v", test.name, test.expected, matched)
+		}
+	}
+
+	// criteria which are not configured produce no matcher
+	for name, factory := range matcherRegistry {
+		if factory.build(ctx, "foo", &MatchCriteria{}) != nil {
+			t.Errorf("expected no %s matcher for empty config", name)
+		}
+	}
+}
+
+func TestCompositeMatchers(t *testing.T) {
+	vdb := nameSetMatcher{names: sets.NewString("vdb")}
+	vdbOrVdc := nameSetMatcher{names: sets.NewString("vdb", "vdc")}
+	tests := []struct {
+		name     string
+		matcher  Matcher
+		device   string
+		expected bool
+	}{
+		{"any first", anyMatcher{vdb, vdbOrVdc}, "vdb", true},
+		{"any second", anyMatcher{vdb, vdbOrVdc}, "vdc", true},
+		{"any none", anyMatcher{vdb, vdbOrVdc}, "vdd", false},
+		{"any empty", anyMatcher{}, "vdb", false},
+		{"all both", allMatcher{vdb, vdbOrVdc}, "vdb", true},
+		{"all one", allMatcher{vdb, vdbOrVdc}, "vdc", false},
+		{"all empty", allMatcher{}, "vdb", false},
+		{"nested", anyMatcher{allMatcher{vdb, vdbOrVdc}, nameSetMatcher{names: sets.NewString("vdd")}}, "vdd", true},
+	}
+	for _, test := range tests {
+		if test.matcher.Matches(BlockDevice{Name: test.device}) != test.expected {
+			t.Errorf("%s: expected match of %s to be %v", test.name, test.device, test.expected)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	ctx := &matchContext{d: d, deviceSet: deviceSet, byIDIndex: map[string][]string{}}
+
+	// (model=FastSSD AND size>500Gi) OR name=vdb
+	config := `
+foo:
+  matchExpression:
+    or:
+    - models: [FastSSD]
+      minSize: 500Gi
+    - disks: [vdb]
+`
+	diskConfig := DiskConfig{}
+	if err := yaml.Unmarshal([]byte(config), &diskConfig); err != nil {
+		t.Fatalf("error parsing config: %v", err)
+	}
+	if err := diskConfig.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	matcher := ctx.newClassMatcher("foo", diskConfig["foo"])
+	matched := []string{}
+	for _, diskName := range []string{"sda", "vda", "vdb", "vdc", "vdd", "vde", "vdf"} {
+		if matcher.any.Matches(deviceSet[diskName]) {
+			matched = append(matched, diskName)
+		}
+	}
+	if expected := []string{"vdb", "vdd"}; !equalStrings(matched, expected) {
+		t.Errorf("expected %v to match, got %v", expected, matched)
+	}
+
+	// flat criteria are alternatives to the expression
+	diskConfig["foo"].DiskNames = []string{"vde"}
+	matcher = ctx.newClassMatcher("foo", diskConfig["foo"])
+	if !matcher.any.Matches(deviceSet["vde"]) || !matcher.any.Matches(deviceSet["vdd"]) {
+		t.Errorf("expected both flat criteria and expression to match")
+	}
+
+	invalid := &MatchExpression{And: []*MatchExpression{{MatchCriteria: MatchCriteria{DeviceNumbers: []string{"foo"}}}}}
+	if err := (DiskConfig{"foo": &Disks{MatchExpression: invalid}}).validate(); err == nil {
+		t.Errorf("expected invalid nested device number to fail validation")
+	}
+}
+
+func quantity(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/sys/unix"
+)
+
+// metaSuffix is appended to the path of a symlink to name its metadata sidecar
+const metaSuffix = ".meta"
+
+// Extended attributes set on metadata sidecars, see DiskMaker.XattrTags
+const (
+	xattrStorageClass = "user.diskmaker.storageclass"
+	xattrDeviceID     = "user.diskmaker.deviceid"
+	xattrSizeBytes    = "user.diskmaker.sizebytes"
+)
+
+var annotationsPath = "/etc/diskmaker/annotations"
+
+// deviceMeta is the content of the sidecar next to the symlink of a claimed device
+type deviceMeta struct {
+	// Annotations are copied from <device-id>.yaml in the annotations directory
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// SizeBytes is the size of the device reported by lsblk --bytes, which is the one
+	// blockdev --getsize64 reports, as both read it from sysfs
+	SizeBytes int64 `json:"sizeBytes,omitempty"`
+}
+
+// readAnnotations reads the optional annotation file of a device, named after the
+// basename of its stable id. A missing file is not an error.
+func (d *DiskMaker) readAnnotations(location DiskLocation) (map[string]string, error) {
+	if location.diskID == "" {
+		return nil, nil
+	}
+	annotationFile := path.Join(annotationsPath, path.Base(location.diskID)+".yaml")
+	content, err := ioutil.ReadFile(annotationFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s with %v", annotationFile, err)
+	}
+	annotations := make(map[string]string)
+	err = yaml.Unmarshal(content, &annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s with %v", annotationFile, err)
+	}
+	return annotations, nil
+}
+
+// writeMeta writes the sidecar of a symlinked device, or removes it if there is no
+// metadata to record
+func (d *DiskMaker) writeMeta(storageClass string, location DiskLocation) error {
+	annotations, err := d.readAnnotations(location)
+	if err != nil {
+		return err
+	}
+	linkPath := path.Join(d.symlinkLocation, storageClass, location.symlinkName())
+	if len(annotations) == 0 && !d.XattrTags && !d.SizeHints {
+		d.removeMeta(linkPath)
+		return nil
+	}
+	content, err := json.Marshal(deviceMeta{Annotations: annotations, SizeBytes: location.size})
+	if err != nil {
+		return err
+	}
+	metaPath := linkPath + metaSuffix
+	if existing, err := ioutil.ReadFile(metaPath); err != nil || string(existing) != string(content) {
+		err = d.fs.WriteFile(metaPath, content, metaFileMode)
+		if err != nil {
+			return fmt.Errorf("failed to write %s with %v", metaPath, err)
+		}
+	}
+	if d.XattrTags {
+		return d.setXattrTags(metaPath, storageClass, location)
+	}
+	return nil
+}
+
+// setXattrTags sets the storageclass, device id and size in bytes, if known, of a
+// symlinked device as extended attributes of its sidecar. Lack of xattr support is
+// only logged.
+func (d *DiskMaker) setXattrTags(metaPath, storageClass string, location DiskLocation) error {
+	deviceID := location.diskID
+	if deviceID == "" {
+		deviceID = path.Join("/dev", location.diskName)
+	}
+	xattrs := []struct{ name, value string }{
+		{xattrStorageClass, storageClass},
+		{xattrDeviceID, deviceID},
+	}
+	if location.size > 0 {
+		xattrs = append(xattrs, struct{ name, value string }{xattrSizeBytes, strconv.FormatInt(location.size, 10)})
+	}
+	for _, xattr := range xattrs {
+		err := d.fs.Setxattr(metaPath, xattr.name, []byte(xattr.value))
+		if err == unix.ENOTSUP {
+			d.throttledWarningf("xattr-unsupported", "not tagging %s, the filesystem of %s does not support extended attributes", metaPath, d.symlinkLocation)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set %s on %s with %v", xattr.name, metaPath, err)
+		}
+	}
+	return nil
+}
+
+// removeMeta removes the sidecar of a symlink, if any
+func (d *DiskMaker) removeMeta(linkPath string) {
+	metaPath := linkPath + metaSuffix
+	if _, err := d.fs.Lstat(metaPath); err != nil {
+		return
+	}
+	err := d.fs.Remove(metaPath)
+	if err != nil {
+		d.Log.Errorf("error removing %s with %v", metaPath, err)
+	}
+}
@@ -0,0 +1,163 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAnnotationsInMeta(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	oldAnnotationsPath := annotationsPath
+	annotationsPath = filepath.Join(tmpDir, "annotations")
+	defer func() { annotationsPath = oldAnnotationsPath }()
+	if err := os.MkdirAll(annotationsPath, 0755); err != nil {
+		t.Fatalf("error creating annotations dir %v", err)
+	}
+	// vdc has no annotation file
+	if err := ioutil.WriteFile(filepath.Join(annotationsPath, "virtio-vdb.yaml"), []byte("rack: r12\ntier: fast\n"), 0644); err != nil {
+		t.Fatalf("error writing annotations %v", err)
+	}
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+	if errs := d.reconcileResult().Errors; len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(symlinkLocation, "foo", "vdb"+metaSuffix))
+	if err != nil {
+		t.Fatalf("error reading sidecar of vdb %v", err)
+	}
+	var meta deviceMeta
+	if err := json.Unmarshal(content, &meta); err != nil {
+		t.Fatalf("error parsing sidecar of vdb %v", err)
+	}
+	if len(meta.Annotations) != 2 || meta.Annotations["rack"] != "r12" || meta.Annotations["tier"] != "fast" {
+		t.Errorf("expected annotations of vdb in its sidecar, got %v", meta.Annotations)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdc"+metaSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected no sidecar for vdc without annotations, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdc")); err != nil {
+		t.Errorf("expected vdc to be symlinked, got %v", err)
+	}
+}
+
+func TestXattrTags(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	if err := os.MkdirAll(symlinkLocation, 0755); err != nil {
+		t.Fatalf("error creating symlink location %v", err)
+	}
+	if err := unix.Setxattr(configFile, "user.test", []byte("test"), 0); err == unix.ENOTSUP {
+		t.Skipf("%s does not support extended attributes", tmpDir)
+	}
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.XattrTags = true
+	d.reconcile()
+
+	getxattr := func(path, name string) string {
+		value := make([]byte, 256)
+		size, err := unix.Getxattr(path, name, value)
+		if err != nil {
+			t.Fatalf("error reading %s of %s: %v", name, path, err)
+		}
+		return string(value[:size])
+	}
+	expected := map[string]string{
+		"vdb": filepath.Join(tmpDir, "by-id", "virtio-vdb"),
+		// without a stable id, the device is referenced by its name
+		"vdc": "/dev/vdc",
+	}
+	for diskName, deviceID := range expected {
+		metaPath := filepath.Join(symlinkLocation, "foo", diskName+metaSuffix)
+		if value := getxattr(metaPath, xattrStorageClass); value != "foo" {
+			t.Errorf("expected storageclass xattr of %s to be foo, got %q", diskName, value)
+		}
+		if value := getxattr(metaPath, xattrDeviceID); value != deviceID {
+			t.Errorf("expected device id xattr of %s to be %s, got %q", diskName, deviceID, value)
+		}
+		if value := getxattr(metaPath, xattrSizeBytes); value != "10737418240" {
+			t.Errorf("expected size xattr of %s to be 10737418240, got %q", diskName, value)
+		}
+	}
+
+	// filesystems without xattrs still get the sidecar
+	if err := os.RemoveAll(symlinkLocation); err != nil {
+		t.Fatalf("error removing symlinks %v", err)
+	}
+	d.fs = &fakeFS{setxattrErr: unix.ENOTSUP}
+	d.reconcile()
+	if errs := d.reconcileErrors; len(errs) != 0 {
+		t.Errorf("expected missing xattr support not to fail the reconcile, got %v", errs)
+	}
+	if _, err := os.Stat(filepath.Join(symlinkLocation, "foo", "vdb"+metaSuffix)); err != nil {
+		t.Errorf("expected sidecar of vdb without xattrs, got %v", err)
+	}
+}
+
+func TestSizeHints(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdd]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.SizeHints = true
+	d.reconcile()
+	if errs := d.reconcileResult().Errors; len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+
+	// sizes of the devices in the lsblk fixture
+	expected := map[string]int64{"vdb": 10737418240, "vdd": 1099511627776}
+	for diskName, size := range expected {
+		content, err := ioutil.ReadFile(filepath.Join(symlinkLocation, "foo", diskName+metaSuffix))
+		if err != nil {
+			t.Fatalf("error reading sidecar of %s %v", diskName, err)
+		}
+		var meta deviceMeta
+		if err := json.Unmarshal(content, &meta); err != nil {
+			t.Fatalf("error parsing sidecar of %s %v", diskName, err)
+		}
+		if meta.SizeBytes != size {
+			t.Errorf("expected size of %s in its sidecar to be %d, got %d", diskName, size, meta.SizeBytes)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package diskmaker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons for which the diskmaker reports being degraded
+const (
+	degradedByIDPermissionDenied = "by-id-permission-denied"
+)
+
+var (
+	claimedDeviceLost = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "diskmaker_claimed_device_lost_total",
+			Help: "Number of symlinked devices that disappeared from the node",
+		},
+		[]string{"storageclass"},
+	)
+	claimedBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_claimed_bytes",
+			Help: "Total size of devices symlinked for a storageclass",
+		},
+		[]string{"storageclass"},
+	)
+	configReloads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diskmaker_config_reloads_total",
+			Help: "Number of times a changed configuration was loaded",
+		},
+	)
+	configLastReload = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_config_last_reload_timestamp",
+			Help: "Unix time at which a changed configuration was last loaded",
+		},
+	)
+	degraded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_degraded",
+			Help: "Whether the diskmaker runs degraded for the given reason, 1 if so",
+		},
+		[]string{"reason"},
+	)
+	duplicateStableIDs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_duplicate_stable_id_devices",
+			Help: "Number of devices not claimed because they share a stable id with another device",
+		},
+	)
+	thinDevicesClaimed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_thin_devices",
+			Help: "Number of thin provisioned devices matched for claiming",
+		},
+	)
+	unhealthyDevicesSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "diskmaker_unhealthy_devices_skipped_total",
+			Help: "Number of times a device was not claimed because smartctl reported it failing",
+		},
+		[]string{"storageclass"},
+	)
+	reconcileTimeouts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diskmaker_reconcile_timeouts_total",
+			Help: "Number of reconciles aborted because they exceeded the reconcile timeout",
+		},
+	)
+	erroredDevicesSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "diskmaker_errored_devices_skipped_total",
+			Help: "Number of times a device was not claimed because sysfs counted more I/O errors than allowed",
+		},
+		[]string{"storageclass"},
+	)
+	inventoryDevices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_devices",
+			Help: "Number of block devices on the node by type and whether they are available for claiming or in use",
+		},
+		[]string{"type", "state"},
+	)
+	inventoryBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "diskmaker_device_bytes",
+			Help: "Total size of block devices on the node by type and whether they are available for claiming or in use",
+		},
+		[]string{"type", "state"},
+	)
+	reconcileEventsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diskmaker_reconcile_events_dropped_total",
+			Help: "Number of reconcile events dropped because the consumer of the event channel fell behind",
+		},
+	)
+	skippedTicks = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "diskmaker_skipped_ticks_total",
+			Help: "Number of periodic reconciles skipped because the previous reconcile was still running",
+		},
+	)
+)
+
+func collectors() []prometheus.Collector {
+	return []prometheus.Collector{claimedDeviceLost, claimedBytes, configReloads, configLastReload, degraded, duplicateStableIDs, thinDevicesClaimed, unhealthyDevicesSkipped, erroredDevicesSkipped, inventoryDevices, inventoryBytes, reconcileEventsDropped, skippedTicks, reconcileTimeouts}
+}
+
+func init() {
+	prometheus.MustRegister(collectors()...)
+}
+
+// registerMetrics registers the metrics of the diskmaker with registerer. Metrics it
+// already has, such as ones registered by an earlier DiskMaker, are left alone.
+func registerMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range collectors() {
+		err := registerer.Register(collector)
+		if _, ok := err.(prometheus.AlreadyRegisteredError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
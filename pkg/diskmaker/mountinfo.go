@@ -0,0 +1,82 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// hostMountInfoPath lists mounts of the host when the diskmaker shares its PID namespace
+var hostMountInfoPath = "/proc/1/mountinfo"
+
+// mountInfoEntry is a mount listed in /proc/<pid>/mountinfo
+type mountInfoEntry struct {
+	// majMin is the major:minor number of the mounted device
+	majMin string
+	// root is the directory of the device mounted, which is not / for bind mounts
+	root       string
+	mountPoint string
+}
+
+// readMountInfo parses a mountinfo file
+func readMountInfo(mountInfoPath string) ([]mountInfoEntry, error) {
+	content, err := ioutil.ReadFile(mountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s with %v", mountInfoPath, err)
+	}
+	entries := []mountInfoEntry{}
+	for _, line := range strings.Split(string(content), "\n") {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		entries = append(entries, mountInfoEntry{majMin: fields[2], root: fields[3], mountPoint: fields[4]})
+	}
+	return entries, nil
+}
+
+// findMountedNumbers returns major:minor numbers of mounted devices mapped to one of
+// their mount points, according to the mountinfo of the diskmaker and, with
+// HostMountInfo, of the host. Virtual filesystems, which have major number 0, are skipped.
+func (d *DiskMaker) findMountedNumbers() (map[string]string, error) {
+	mountInfoPaths := []string{procMountInfoPath}
+	if d.HostMountInfo {
+		mountInfoPaths = append(mountInfoPaths, hostMountInfoPath)
+	}
+	mounted := make(map[string]string)
+	for _, mountInfoPath := range mountInfoPaths {
+		entries, err := readMountInfo(mountInfoPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.majMin, "0:") {
+				continue
+			}
+			if _, found := mounted[entry.majMin]; !found {
+				mounted[entry.majMin] = entry.mountPoint
+			}
+		}
+	}
+	return mounted, nil
+}
+
+// excludeMountedDevices removes devices mounted according to mountinfo from deviceSet.
+// Unlike the MOUNTPOINT reported by lsblk, this catches bind mounts and mounts of
+// other mount namespaces visible in the mountinfo read.
+func (d *DiskMaker) excludeMountedDevices(deviceSet map[string]BlockDevice) error {
+	mounted, err := d.findMountedNumbers()
+	if err != nil {
+		return err
+	}
+	for deviceName, blockDevice := range deviceSet {
+		if mountPoint, found := mounted[blockDevice.MajMin]; found {
+			d.Log.Infof("ignoring device %s because it is mounted at %s", deviceName, mountPoint)
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipMounted, mountPoint)
+			d.mountedDevices[deviceName] = blockDevice
+		}
+	}
+	return nil
+}
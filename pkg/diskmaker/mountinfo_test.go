@@ -0,0 +1,69 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludeMountedDevices(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mountinfo")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	// a directory of vdb is bind mounted into the container, lsblk doesn't report it
+	selfMountInfo := filepath.Join(tmpDir, "self")
+	if err := ioutil.WriteFile(selfMountInfo, []byte(`1562 1477 0:340 / / rw,relatime master:555 - overlay overlay rw,lowerdir=/var/lib/containers/l/A
+1563 1562 0:343 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+1590 1562 252:16 /exports/data /data rw,relatime - xfs /dev/vdb rw
+`), 0644); err != nil {
+		t.Fatalf("error writing mountinfo %v", err)
+	}
+	// vdc is only mounted on the host
+	hostMountInfo := filepath.Join(tmpDir, "host")
+	if err := ioutil.WriteFile(hostMountInfo, []byte(`22 1 252:0 / / rw,relatime shared:1 - xfs /dev/vda rw
+95 22 252:32 / /var/mnt/scratch rw,relatime shared:40 - ext4 /dev/vdc rw
+`), 0644); err != nil {
+		t.Fatalf("error writing mountinfo %v", err)
+	}
+	oldProcMountInfoPath, oldHostMountInfoPath := procMountInfoPath, hostMountInfoPath
+	procMountInfoPath, hostMountInfoPath = selfMountInfo, hostMountInfo
+	defer func() { procMountInfoPath, hostMountInfoPath = oldProcMountInfoPath, oldHostMountInfoPath }()
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	_, deviceSet, ok := d.findCandidateDisks()
+	if !ok {
+		t.Fatalf("expected candidate disks to be found")
+	}
+	if _, found := deviceSet["vdb"]; found {
+		t.Errorf("expected bind mounted vdb to be excluded")
+	}
+	if reason := d.skipReasons["vdb"]; reason != skipMounted+": /data" {
+		t.Errorf("expected vdb to be skipped as mounted, got %q", reason)
+	}
+	if _, found := deviceSet["vdc"]; !found {
+		t.Errorf("expected vdc to be kept without HostMountInfo")
+	}
+
+	d.HostMountInfo = true
+	d.skipReasons = make(map[string]string)
+	_, deviceSet, ok = d.findCandidateDisks()
+	if !ok {
+		t.Fatalf("expected candidate disks to be found")
+	}
+	for diskName, mountPoint := range map[string]string{"vda": "/", "vdc": "/var/mnt/scratch"} {
+		if _, found := deviceSet[diskName]; found {
+			t.Errorf("expected %s mounted on the host to be excluded", diskName)
+		}
+		if reason := d.skipReasons[diskName]; reason != skipMounted+": "+mountPoint {
+			t.Errorf("expected %s to be skipped as mounted at %s, got %q", diskName, mountPoint, reason)
+		}
+	}
+	if _, found := deviceSet["vdd"]; !found {
+		t.Errorf("expected unmounted vdd to be kept")
+	}
+}
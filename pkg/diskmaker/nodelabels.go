@@ -0,0 +1,151 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeLabelSource returns the labels of the node that Disks.NodeLabelSelector is
+// evaluated against
+type NodeLabelSource interface {
+	NodeLabels() (map[string]string, error)
+}
+
+// FileNodeLabelSource reads node labels from a file in the format of Downward API
+// label files, one key="value" per line, such as one written by an init container
+type FileNodeLabelSource struct {
+	Path string
+}
+
+func (s *FileNodeLabelSource) NodeLabels() (map[string]string, error) {
+	content, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading node labels from %s with %v", s.Path, err)
+	}
+	return parseLabelsFile(string(content))
+}
+
+// parseLabelsFile parses labels in the format of Downward API label files
+func parseLabelsFile(content string) (map[string]string, error) {
+	nodeLabels := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid label %q, expected key=\"value\"", line)
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label %s with %v", parts[0], err)
+		}
+		nodeLabels[parts[0]] = value
+	}
+	return nodeLabels, nil
+}
+
+// defaultNodeLabelTTL is how long KubeNodeLabelSource caches labels unless TTL is set
+const defaultNodeLabelTTL = time.Minute
+
+// KubeNodeLabelSource gets the labels of node NodeName from the API server. They are
+// cached for TTL rather than fetched on every reconcile, label changes are noticed
+// once it expired.
+type KubeNodeLabelSource struct {
+	Client   kubernetes.Interface
+	NodeName string
+	TTL      time.Duration
+
+	lock      sync.Mutex
+	labels    map[string]string
+	fetchedAt time.Time
+	// now and getNode are replaced by tests
+	now     func() time.Time
+	getNode func() (map[string]string, error)
+}
+
+func (s *KubeNodeLabelSource) NodeLabels() (map[string]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = defaultNodeLabelTTL
+	}
+	if s.labels != nil && now().Sub(s.fetchedAt) < ttl {
+		return s.labels, nil
+	}
+	getNode := s.getNodeLabels
+	if s.getNode != nil {
+		getNode = s.getNode
+	}
+	nodeLabels, err := getNode()
+	if err != nil {
+		return nil, err
+	}
+	if nodeLabels == nil {
+		nodeLabels = make(map[string]string)
+	}
+	s.labels = nodeLabels
+	s.fetchedAt = now()
+	return nodeLabels, nil
+}
+
+func (s *KubeNodeLabelSource) getNodeLabels() (map[string]string, error) {
+	node, err := s.Client.CoreV1().Nodes().Get(s.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting node %s with %v", s.NodeName, err)
+	}
+	return node.Labels, nil
+}
+
+// unselectedClasses returns the storageclasses whose NodeLabelSelector does not select
+// the node. Node labels are only fetched if some storageclass has a selector, and all
+// storageclasses with one are returned if they can't be.
+func (d *DiskMaker) unselectedClasses(diskConfig DiskConfig) sets.String {
+	withSelector := sets.NewString()
+	for storageClass, disks := range diskConfig {
+		if disks.NodeLabelSelector != nil {
+			withSelector.Insert(storageClass)
+		}
+	}
+	if withSelector.Len() == 0 {
+		return withSelector
+	}
+	if d.NodeLabels == nil {
+		d.reconcileErrorf("storageclasses %v have a nodeLabelSelector but no node label source is configured", withSelector.List())
+		return withSelector
+	}
+	nodeLabels, err := d.NodeLabels.NodeLabels()
+	if err != nil {
+		d.reconcileErrorf("error getting node labels, skipping storageclasses %v: %v", withSelector.List(), err)
+		return withSelector
+	}
+	unselected := sets.NewString()
+	for _, storageClass := range withSelector.List() {
+		selector, err := metav1.LabelSelectorAsSelector(diskConfig[storageClass].NodeLabelSelector)
+		if err != nil {
+			d.reconcileErrorf("invalid nodeLabelSelector of storageclass %s: %v", storageClass, err)
+			unselected.Insert(storageClass)
+			continue
+		}
+		if !selector.Matches(labels.Set(nodeLabels)) {
+			d.Log.Debugf("skipping storageclass %s, its nodeLabelSelector %s does not select the node", storageClass, selector.String())
+			unselected.Insert(storageClass)
+		}
+	}
+	return unselected
+}
@@ -0,0 +1,115 @@
+package diskmaker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeNodeLabels is a NodeLabelSource returning fixed labels or an error
+type fakeNodeLabels struct {
+	labels map[string]string
+	err    error
+}
+
+func (f *fakeNodeLabels) NodeLabels() (map[string]string, error) {
+	return f.labels, f.err
+}
+
+func TestNodeLabelSelector(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.NodeLabels = &fakeNodeLabels{labels: map[string]string{"node-role": "storage"}}
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	diskConfig := DiskConfig{
+		"storage": &Disks{DiskNames: []string{"vdb"}, NodeLabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"node-role": "storage"}}},
+		"compute": &Disks{DiskNames: []string{"vdc"}, NodeLabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"node-role": "compute"}}},
+		"any":     &Disks{DiskNames: []string{"vdd"}},
+	}
+	deviceMap, err := d.findMatchingDisks(context.Background(), diskConfig, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if !hasDevice(deviceMap["storage"], "vdb") || !hasDevice(deviceMap["any"], "vdd") {
+		t.Errorf("expected storage and any to be processed, got %v", deviceMap)
+	}
+	if len(deviceMap["compute"]) != 0 {
+		t.Errorf("expected compute not to be processed, got %v", deviceMap["compute"])
+	}
+	if reason := d.skipReasons["vdc"]; reason != skipNodeLabels+": compute" {
+		t.Errorf("expected vdc to be skipped for node labels, got %q", reason)
+	}
+
+	// storageclasses with a selector are skipped when labels are unavailable
+	d.NodeLabels = &fakeNodeLabels{err: fmt.Errorf("api server unavailable")}
+	d.reconcileErrors = nil
+	deviceMap, err = d.findMatchingDisks(context.Background(), diskConfig, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if len(deviceMap["storage"]) != 0 || !hasDevice(deviceMap["any"], "vdd") {
+		t.Errorf("expected only any to be processed, got %v", deviceMap)
+	}
+	if len(d.reconcileErrors) != 1 {
+		t.Errorf("expected the label error to be reported, got %v", d.reconcileErrors)
+	}
+}
+
+func TestNodeLabelSelectorValidation(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "node-role", Operator: "Bogus"}}}
+	diskConfig := DiskConfig{"foo": &Disks{DiskNames: []string{"vdb"}, NodeLabelSelector: selector}}
+	if diskConfig.validate() == nil {
+		t.Errorf("expected an invalid nodeLabelSelector to be rejected")
+	}
+}
+
+func TestParseLabelsFile(t *testing.T) {
+	nodeLabels, err := parseLabelsFile("kubernetes.io/hostname=\"node-1\"\nnode-role=\"storage\"\n")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(nodeLabels) != 2 || nodeLabels["kubernetes.io/hostname"] != "node-1" || nodeLabels["node-role"] != "storage" {
+		t.Errorf("unexpected labels %v", nodeLabels)
+	}
+	if _, err := parseLabelsFile("node-role=storage\n"); err == nil {
+		t.Errorf("expected an unquoted value to be rejected")
+	}
+}
+
+func TestKubeNodeLabelSourceCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	gets := 0
+	var getErr error
+	source := &KubeNodeLabelSource{
+		NodeName: "node-1",
+		now:      func() time.Time { return now },
+		getNode: func() (map[string]string, error) {
+			gets++
+			return map[string]string{"gets": fmt.Sprint(gets)}, getErr
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		nodeLabels, err := source.NodeLabels()
+		if err != nil || nodeLabels["gets"] != "1" {
+			t.Errorf("expected cached labels of the first get, got %v %v", nodeLabels, err)
+		}
+	}
+
+	// labels are fetched again once the TTL expired
+	now = now.Add(defaultNodeLabelTTL)
+	if nodeLabels, err := source.NodeLabels(); err != nil || nodeLabels["gets"] != "2" {
+		t.Errorf("expected labels to be fetched again, got %v %v", nodeLabels, err)
+	}
+
+	now = now.Add(defaultNodeLabelTTL)
+	getErr = fmt.Errorf("api server unavailable")
+	if _, err := source.NodeLabels(); err == nil {
+		t.Errorf("expected the error getting the node to be returned")
+	}
+}
@@ -0,0 +1,67 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// NodeStatusClient patches the status of the custom resource reporting what the
+// diskmaker of a node claimed, such as a LocalVolumeNodeStatus named after the node
+type NodeStatusClient interface {
+	PatchStatus(nodeName string, patch []byte) error
+}
+
+// DynamicNodeStatusClient is a NodeStatusClient patching resources of any type with
+// a dynamic client. Namespace is empty for cluster scoped resources.
+type DynamicNodeStatusClient struct {
+	Client    dynamic.Interface
+	Resource  schema.GroupVersionResource
+	Namespace string
+}
+
+func (c *DynamicNodeStatusClient) PatchStatus(nodeName string, patch []byte) error {
+	var resource dynamic.ResourceInterface = c.Client.Resource(c.Resource)
+	if c.Namespace != "" {
+		resource = c.Client.Resource(c.Resource).Namespace(c.Namespace)
+	}
+	_, err := resource.Patch(nodeName, types.MergePatchType, patch, "status")
+	return err
+}
+
+// nodeStatusPatch is a merge patch of the status of the node's resource
+type nodeStatusPatch struct {
+	Status struct {
+		// Claimed maps storageclasses to their devices, nil for storageclasses
+		// reported before that no longer claim anything, so the patch removes them
+		Claimed map[string][]string `json:"claimed"`
+	} `json:"status"`
+}
+
+// reportNodeStatus patches the status of the node's resource with the devices claimed
+// per storageclass, unless they didn't change since the last successful patch
+func (d *DiskMaker) reportNodeStatus(claimed map[string][]string) {
+	if d.NodeStatusClient == nil || reflect.DeepEqual(claimed, d.reportedClaimed) {
+		return
+	}
+	patch := nodeStatusPatch{}
+	patch.Status.Claimed = make(map[string][]string)
+	for storageClass := range d.reportedClaimed {
+		patch.Status.Claimed[storageClass] = nil
+	}
+	for storageClass, devices := range claimed {
+		patch.Status.Claimed[storageClass] = devices
+	}
+	content, err := json.Marshal(patch)
+	if err == nil {
+		err = d.NodeStatusClient.PatchStatus(d.NodeName, content)
+	}
+	if err != nil {
+		d.reconcileErrorf("error reporting status of node %s: %v", d.NodeName, err)
+		return
+	}
+	d.reportedClaimed = claimed
+}
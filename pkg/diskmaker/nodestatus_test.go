@@ -0,0 +1,72 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeNodeStatusClient records the patches it receives
+type fakeNodeStatusClient struct {
+	nodeNames []string
+	patches   []string
+	err       error
+}
+
+func (f *fakeNodeStatusClient) PatchStatus(nodeName string, patch []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.nodeNames = append(f.nodeNames, nodeName)
+	f.patches = append(f.patches, string(patch))
+	return nil
+}
+
+func TestReportNodeStatus(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\nbar:\n  disks: [vdd]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	client := &fakeNodeStatusClient{}
+	d.NodeStatusClient = client
+	d.NodeName = "worker-0"
+
+	d.reconcile()
+	expected := `{"status":{"claimed":{"bar":["vdd"],"foo":["vdb","vdc"]}}}`
+	if len(client.patches) != 1 || client.patches[0] != expected || client.nodeNames[0] != "worker-0" {
+		t.Fatalf("expected worker-0 to be patched with %s, got %v %v", expected, client.nodeNames, client.patches)
+	}
+
+	// unchanged claims are not reported again
+	d.reconcile()
+	if len(client.patches) != 1 {
+		t.Errorf("expected no patch without changes, got %v", client.patches[1:])
+	}
+
+	// storageclasses no longer claiming anything are removed from the status
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	client.err = fmt.Errorf("connection refused")
+	d.reconcile()
+	if len(d.reconcileErrors) != 1 {
+		t.Errorf("expected failed patch to be reported, got %v", d.reconcileErrors)
+	}
+	client.err = nil
+	d.reconcile()
+	expected = `{"status":{"claimed":{"bar":null,"foo":["vdb"]}}}`
+	if len(client.patches) != 2 || client.patches[1] != expected {
+		t.Errorf("expected failed patch to be retried as %s, got %v", expected, client.patches)
+	}
+}
@@ -0,0 +1,64 @@
+package diskmaker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var procPath = "/proc"
+
+// findOpenDevices returns names of block devices that some process has open according
+// to the file descriptors in /proc/<pid>/fd. Processes that exit or whose descriptors
+// can't be read meanwhile are ignored.
+func findOpenDevices() (sets.String, error) {
+	openDevices := sets.NewString()
+	fdPaths, err := filepath.Glob(filepath.Join(procPath, "[0-9]*", "fd", "*"))
+	if err != nil {
+		return openDevices, err
+	}
+	for _, fdPath := range fdPaths {
+		target, err := os.Readlink(fdPath)
+		if err != nil || !strings.HasPrefix(target, "/dev/") {
+			continue
+		}
+		openDevices.Insert(filepath.Base(target))
+	}
+	return openDevices, nil
+}
+
+// excludeOpenDevices removes devices some process has open, such as a formatting job,
+// from deviceSet, together with partitions of open disks and disks of open partitions.
+// Devices claimed by the previous reconcile are kept, they are opened by their consumers.
+func (d *DiskMaker) excludeOpenDevices(deviceSet map[string]BlockDevice, allDevices []BlockDevice) error {
+	openDevices, err := findOpenDevices()
+	if err != nil {
+		return err
+	}
+	claimed := sets.NewString()
+	for _, deviceArray := range d.claimed {
+		for _, deviceLocation := range deviceArray {
+			claimed.Insert(deviceLocation.diskName)
+		}
+	}
+	devicesByName := make(map[string]BlockDevice, len(allDevices))
+	for _, blockDevice := range allDevices {
+		devicesByName[blockDevice.Name] = blockDevice
+	}
+	for deviceName, blockDevice := range deviceSet {
+		if claimed.Has(deviceName) {
+			continue
+		}
+		for _, openDevice := range openDevices.List() {
+			if deviceName == openDevice || isPartitionOf(blockDevice, openDevice) || isPartitionOf(devicesByName[openDevice], deviceName) {
+				d.Log.Infof("ignoring device %s because %s is open by a process", deviceName, openDevice)
+				delete(deviceSet, deviceName)
+				d.skipDevice(deviceName, skipOpen, openDevice)
+				break
+			}
+		}
+	}
+	return nil
+}
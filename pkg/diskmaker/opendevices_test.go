@@ -0,0 +1,102 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExcludeOpenDevices(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldProcPath := procPath
+	procPath = tmpDir
+	defer func() { procPath = oldProcPath }()
+	// a formatting job has vdb open, a consumer of claimed vdd has it open too
+	for fdPath, target := range map[string]string{
+		"4242/fd/0": "/dev/null",
+		"4242/fd/3": "/dev/vdb",
+		"4343/fd/5": "/dev/vdd",
+		"self/fd/7": "/dev/vdc",
+	} {
+		fdPath = filepath.Join(tmpDir, fdPath)
+		if err := os.MkdirAll(filepath.Dir(fdPath), 0755); err != nil {
+			t.Fatalf("error creating fd dir %v", err)
+		}
+		if err := os.Symlink(target, fdPath); err != nil {
+			t.Fatalf("error creating fd %v", err)
+		}
+	}
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	data := getData() + `
+NAME="vdb1" MAJ:MIN="252:17" TYPE="part" SIZE="1048576" MOUNTPOINT="" PKNAME="vdb"`
+	deviceSet, err := d.findNewDisks(data)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	d.claimed = map[string][]DiskLocation{"foo": {{diskName: "vdd"}}}
+	if err := d.excludeOpenDevices(deviceSet, parseBlockDevices(data)); err != nil {
+		t.Fatalf("error excluding open devices %v", err)
+	}
+	for _, deviceName := range []string{"vdb", "vdb1"} {
+		if _, ok := deviceSet[deviceName]; ok {
+			t.Errorf("expected open %s to be excluded", deviceName)
+		}
+		if reason := d.skipReasons[deviceName]; !strings.HasPrefix(reason, skipOpen) {
+			t.Errorf("expected %s to be skipped as open, got %q", deviceName, reason)
+		}
+	}
+	// vdc is only open by a path that is not a process, vdd is already claimed
+	for _, deviceName := range []string{"vdc", "vdd"} {
+		if _, ok := deviceSet[deviceName]; !ok {
+			t.Errorf("expected %s to be kept", deviceName)
+		}
+	}
+}
+
+func TestOpenDevicesClaimedBeforeRestart(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdd": "vdd"})()
+	oldProcPath := procPath
+	procPath = filepath.Join(tmpDir, "proc")
+	defer func() { procPath = oldProcPath }()
+	// the consumer of vdd has it open
+	fdPath := filepath.Join(procPath, "4343", "fd", "5")
+	if err := os.MkdirAll(filepath.Dir(fdPath), 0755); err != nil {
+		t.Fatalf("error creating fd dir %v", err)
+	}
+	if err := os.Symlink("/dev/vdd", fdPath); err != nil {
+		t.Fatalf("error creating fd %v", err)
+	}
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdd]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	// vdd was symlinked before the diskmaker restarted
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	if err := os.MkdirAll(filepath.Join(symlinkLocation, "foo"), 0755); err != nil {
+		t.Fatalf("error creating class dir %v", err)
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "by-id", "virtio-vdd"), filepath.Join(symlinkLocation, "foo", "vdd")); err != nil {
+		t.Fatalf("error creating symlink %v", err)
+	}
+
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.ExcludeOpenDevices = true
+	d.reconcile()
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdd"}) {
+		t.Errorf("expected open vdd to stay claimed after a restart, got %v", claimed)
+	}
+}
@@ -0,0 +1,75 @@
+package diskmaker
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var procMountInfoPath = "/proc/self/mountinfo"
+
+// ownMountPoints are mounts of the diskmaker container that are backed by the pod's
+// own storage on the node, such as its root filesystem and files written by kubelet.
+var ownMountPoints = sets.NewString("/", "/etc/hosts", "/etc/hostname", "/etc/resolv.conf", "/dev/termination-log")
+
+// findOwnStorageNumbers returns major:minor numbers of devices backing the container's
+// own storage according to mountinfo. Overlay and other virtual filesystems, which
+// have major number 0, are skipped.
+func findOwnStorageNumbers() (sets.String, error) {
+	numbers := sets.NewString()
+	entries, err := readMountInfo(procMountInfoPath)
+	if err != nil {
+		return numbers, err
+	}
+	for _, entry := range entries {
+		if !ownMountPoints.Has(entry.mountPoint) || strings.HasPrefix(entry.majMin, "0:") {
+			continue
+		}
+		numbers.Insert(entry.majMin)
+	}
+	return numbers, nil
+}
+
+// excludeOwnStorage removes the devices backing the container's own storage from
+// deviceSet, together with the disks they are partitions of and the other partitions
+// of those disks. Claiming them would destroy the diskmaker pod's storage.
+func (d *DiskMaker) excludeOwnStorage(deviceSet map[string]BlockDevice, allDevices []BlockDevice) error {
+	numbers, err := findOwnStorageNumbers()
+	if err != nil {
+		return err
+	}
+	for deviceName := range backingDevices(numbers, allDevices) {
+		if _, found := deviceSet[deviceName]; found {
+			d.Log.Infof("ignoring device %s because it backs the diskmaker's own storage", deviceName)
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipOwnStorage, "")
+		}
+	}
+	return nil
+}
+
+// backingDevices returns the devices with the given major:minor numbers, the disks they
+// are partitions of and the other partitions of those disks, each mapped to the number
+// of the device it's returned for
+func backingDevices(numbers sets.String, allDevices []BlockDevice) map[string]string {
+	backing := make(map[string]string)
+	for _, blockDevice := range allDevices {
+		if numbers.Has(blockDevice.MajMin) {
+			backing[blockDevice.Name] = blockDevice.MajMin
+		}
+	}
+	// the whole disk is off limits when a partition of it is
+	for _, blockDevice := range allDevices {
+		if number, found := backing[blockDevice.Name]; found && isPartitionOf(blockDevice, blockDevice.Parent) {
+			backing[blockDevice.Parent] = number
+		}
+	}
+	for _, blockDevice := range allDevices {
+		for deviceName, number := range backing {
+			if isPartitionOf(blockDevice, deviceName) {
+				backing[blockDevice.Name] = number
+			}
+		}
+	}
+	return backing
+}
@@ -0,0 +1,53 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExcludeOwnStorage(t *testing.T) {
+	mountInfo, err := ioutil.TempFile("", "mountinfo")
+	if err != nil {
+		t.Fatalf("error creating mountinfo %v", err)
+	}
+	defer os.Remove(mountInfo.Name())
+	// the container root is overlay, kubelet managed files live on vdc1
+	mountInfo.WriteString(`1562 1477 0:340 / / rw,relatime master:555 - overlay overlay rw,lowerdir=/var/lib/containers/l/A,upperdir=/var/lib/containers/diff
+1563 1562 0:343 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+1580 1562 252:33 /var/lib/kubelet/pods/8e0c/etc-hosts /etc/hosts rw,relatime - xfs /dev/vdc1 rw
+1581 1562 252:33 /var/lib/kubelet/pods/8e0c/containers/diskmaker/0 /dev/termination-log rw,relatime - xfs /dev/vdc1 rw
+1590 1562 252:48 / /mnt/data rw,relatime - xfs /dev/vdd rw
+`)
+	mountInfo.Close()
+	oldProcMountInfoPath := procMountInfoPath
+	procMountInfoPath = mountInfo.Name()
+	defer func() { procMountInfoPath = oldProcMountInfoPath }()
+
+	content := getData() + `
+NAME="vdc1" MAJ:MIN="252:33" TYPE="part" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdc"
+NAME="vdc2" MAJ:MIN="252:34" TYPE="part" SIZE="1048576" MOUNTPOINT="" PKNAME="vdc"`
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(content)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	if err := d.excludeOwnStorage(deviceSet, parseBlockDevices(content)); err != nil {
+		t.Fatalf("error excluding own storage %v", err)
+	}
+	// vdc1 backs the pod, so its disk and sibling partitions are excluded too
+	for _, deviceName := range []string{"vdc", "vdc1", "vdc2"} {
+		if _, ok := deviceSet[deviceName]; ok {
+			t.Errorf("expected %s to be excluded", deviceName)
+		}
+		if reason := d.skipReasons[deviceName]; reason != skipOwnStorage {
+			t.Errorf("expected %s to be skipped as own storage, got %q", deviceName, reason)
+		}
+	}
+	// vdd is mounted, but not one of the container's own mounts
+	for _, deviceName := range []string{"vdb", "vdd"} {
+		if _, ok := deviceSet[deviceName]; !ok {
+			t.Errorf("expected %s to be kept", deviceName)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package diskmaker
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// errPartitioned is returned by autoPartition for disks that have partitions it did not create
+var errPartitioned = errors.New("disk is already partitioned")
+
+// autoPartitionLabel names the GPT partition autoPartition creates, only partitions
+// carrying it are taken for ones of an earlier reconcile
+const autoPartitionLabel = "local-storage"
+
+// errFormatted is returned by autoPartition for disks that contain a filesystem
+var errFormatted = errors.New("disk contains a filesystem")
+
+// partitionName returns the name of a partition of diskName, such as sdb1 or nvme0n1p1
+func partitionName(diskName string, number int) string {
+	if diskName != "" && unicode.IsDigit(rune(diskName[len(diskName)-1])) {
+		return fmt.Sprintf("%sp%d", diskName, number)
+	}
+	return fmt.Sprintf("%s%d", diskName, number)
+}
+
+// partitionLocation returns the location of the first partition of the disk at location.
+// Stable ids of partitions are the ones of their disk suffixed by udev with -part<N>.
+func partitionLocation(location DiskLocation) DiskLocation {
+	location.diskName = partitionName(location.diskName, 1)
+	if location.diskID != "" {
+		location.diskID += "-part1"
+	}
+	if location.linkName != "" {
+		location.linkName += "-part1"
+	}
+	return location
+}
+
+// diskByPartUUIDPath holds links to GPT partitions named after their unique id
+var diskByPartUUIDPath = "/dev/disk/by-partuuid"
+
+// partitionTarget sets what the symlink of partition location, returned by autoPartition,
+// points at according to Disks.PartitionSymlinkTarget. The unique id of a partition
+// created by this reconcile is not listed by lsblk yet, so it's read with blkid.
+func (d *DiskMaker) partitionTarget(disks *Disks, location DiskLocation, allDevices []BlockDevice) DiskLocation {
+	switch disks.PartitionSymlinkTarget {
+	case PartitionSymlinkTargetRaw:
+		location.raw = true
+	case PartitionSymlinkTargetPartUUID:
+		partUUID := ""
+		for _, blockDevice := range allDevices {
+			if blockDevice.Name == location.diskName {
+				partUUID = blockDevice.PartUUID
+			}
+		}
+		if partUUID == "" {
+			out, err := d.run("blkid", "-s", "PARTUUID", "-o", "value", path.Join("/dev", location.diskName))
+			if err != nil {
+				d.Log.Warningf("unable to read unique id of partition %s, symlinking it through /dev: %v", location.diskName, err)
+			}
+			partUUID = strings.TrimSpace(string(out))
+		}
+		if partUUID == "" {
+			location.raw = true
+		} else {
+			location.diskID = path.Join(diskByPartUUIDPath, partUUID)
+			location.raw = false
+		}
+	}
+	return location
+}
+
+// autoPartition returns the location of the partition to symlink instead of a disk
+// claimed with Disks.AutoPartition. A disk without partition table gets a single GPT
+// partition spanning it, named autoPartitionLabel. A disk whose only partition is an
+// unformatted first partition with that name was partitioned by an earlier reconcile and
+// is left alone, see isAutoPartitioned. Any other disk is refused
+// with errPartitioned, a disk containing a filesystem with errFormatted. Devices which
+// are not disks are returned unchanged.
+func (d *DiskMaker) autoPartition(location DiskLocation, allDevices []BlockDevice) (DiskLocation, error) {
+	var disk *BlockDevice
+	partitions := []BlockDevice{}
+	for i := range allDevices {
+		if allDevices[i].Name == location.diskName {
+			disk = &allDevices[i]
+		} else if isPartitionOf(allDevices[i], location.diskName) {
+			partitions = append(partitions, allDevices[i])
+		}
+	}
+	if disk == nil || disk.DiskType != "disk" {
+		return location, nil
+	}
+	partLocation := partitionLocation(location)
+	if isAutoPartitioned(location.diskName, allDevices) {
+		return partLocation, nil
+	}
+	if disk.PartTable != "" || len(partitions) > 0 {
+		return location, errPartitioned
+	}
+	if disk.FSType != "" {
+		return location, errFormatted
+	}
+
+	devicePath := path.Join("/dev", location.diskName)
+	d.Log.Infof("creating partition %s spanning disk %s", partLocation.diskName, location.diskName)
+	_, err := d.run("sgdisk", "--new=1:0:0", "--change-name=1:"+autoPartitionLabel, devicePath)
+	if err != nil {
+		return location, fmt.Errorf("error partitioning %s with %v", devicePath, err)
+	}
+	// wait for udev to create the device and by-id links of the partition
+	_, err = d.run("udevadm", "settle")
+	if err != nil {
+		return location, fmt.Errorf("error waiting for partition of %s with %v", devicePath, err)
+	}
+	d.batchEvent(corev1.EventTypeNormal, devicePartitionedReason, "created %d partitions spanning their disk: %s", partLocation.diskName)
+	return partLocation, nil
+}
+
+// isAutoPartitioned returns whether the only partition of disk diskName is an unformatted
+// first partition created by autoPartition. Other tools' partitions, such as raw database
+// volumes, may look the same to lsblk but lack its name.
+func isAutoPartitioned(diskName string, devices []BlockDevice) bool {
+	partitions := []BlockDevice{}
+	for _, device := range devices {
+		if isPartitionOf(device, diskName) {
+			partitions = append(partitions, device)
+		}
+	}
+	return len(partitions) == 1 && partitions[0].Name == partitionName(diskName, 1) &&
+		partitions[0].FSType == "" && partitions[0].PartLabel == autoPartitionLabel
+}
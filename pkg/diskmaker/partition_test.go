@@ -0,0 +1,252 @@
+package diskmaker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartitionName(t *testing.T) {
+	tests := map[string]string{
+		"sdb":     "sdb1",
+		"vdb":     "vdb1",
+		"nvme0n1": "nvme0n1p1",
+		"mmcblk0": "mmcblk0p1",
+	}
+	for diskName, expected := range tests {
+		if name := partitionName(diskName, 1); name != expected {
+			t.Errorf("expected first partition of %s to be %s, got %s", diskName, expected, name)
+		}
+	}
+}
+
+func TestAutoPartitionRequiresForce(t *testing.T) {
+	diskConfig := DiskConfig{"foo": &Disks{DiskNames: []string{"vdb"}, AutoPartition: true}}
+	if diskConfig.validate() == nil {
+		t.Errorf("expected autoPartition without force to be invalid")
+	}
+	diskConfig["foo"].Force = true
+	if err := diskConfig.validate(); err != nil {
+		t.Errorf("expected autoPartition with force to be valid, got %v", err)
+	}
+}
+
+func TestAutoPartitionRejectsFormatted(t *testing.T) {
+	tests := map[string]*Disks{
+		"allowFormatted": {DiskNames: []string{"vdb"}, AutoPartition: true, Force: true, AllowFormatted: true},
+		"fsLabels":       {FSLabels: []string{"data"}, AutoPartition: true, Force: true},
+		"expression fsLabels": {AutoPartition: true, Force: true, MatchExpression: &MatchExpression{
+			Or: []*MatchExpression{{MatchCriteria: MatchCriteria{FSLabels: []string{"data"}}}},
+		}},
+	}
+	for name, disks := range tests {
+		if (DiskConfig{"foo": disks}).validate() == nil {
+			t.Errorf("%s: expected autoPartition to be invalid", name)
+		}
+	}
+}
+
+func TestAutoPartitionSkipsFormattedDisk(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	runner := &fakeRunner{}
+	d.runner = runner
+	allDevices := []BlockDevice{{Name: "vdb", DiskType: "disk", FSType: "xfs"}}
+	location, err := d.autoPartition(DiskLocation{diskName: "vdb"}, allDevices)
+	if err != errFormatted || location.diskName != "vdb" {
+		t.Errorf("expected formatted vdb to be refused, got %v %v", location, err)
+	}
+	if runner.count("sgdisk") != 0 {
+		t.Errorf("expected formatted vdb not to be partitioned, got calls %v", runner.calls)
+	}
+}
+
+func TestAutoPartitionMarkerMode(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	config := "foo:\n  disks: [vdb]\n  autoPartition: true\n  force: true\n  claimMode: marker\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE=""`}
+	d.runner = runner
+	d.ProtectSwap = false
+	d.reconcile()
+
+	if runner.count("sgdisk") != 0 {
+		t.Errorf("expected marker class not to partition vdb, got calls %v", runner.calls)
+	}
+	if reason := d.Status().SkipReasons["vdb"]; reason != skipMarked+": foo" {
+		t.Errorf("expected vdb to be marked, got %q", reason)
+	}
+}
+
+func TestAutoPartition(t *testing.T) {
+	defer fakeRoot()()
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	config := "foo:\n  disks: [vdb, vdc]\n  autoPartition: true\n  force: true\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	runner := &fakeRunner{output: `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE=""
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE="gpt"
+NAME="vdc1" MAJ:MIN="252:33" TYPE="part" SIZE="5368709120" MOUNTPOINT="" PKNAME="vdc" PTTYPE="gpt"
+NAME="vdc2" MAJ:MIN="252:34" TYPE="part" SIZE="5368709120" MOUNTPOINT="" PKNAME="vdc" PTTYPE="gpt"`}
+	d.runner = runner
+	d.ProtectSwap = false
+	d.reconcile()
+
+	if runner.count("sgdisk") != 1 || runner.count("udevadm") != 1 {
+		t.Fatalf("expected one disk to be partitioned, got calls %v", runner.calls)
+	}
+	for _, call := range runner.calls {
+		if call[0] == "sgdisk" && !equalStrings(call, []string{"sgdisk", "--new=1:0:0", "--change-name=1:local-storage", "/dev/vdb"}) {
+			t.Errorf("unexpected partitioning command %v", call)
+		}
+	}
+	target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", "vdb1"))
+	if err != nil {
+		t.Fatalf("expected partition vdb1 to be symlinked, got %v", err)
+	}
+	if expected := filepath.Join(tmpDir, "by-id", "virtio-vdb-part1"); target != expected {
+		t.Errorf("expected symlink of vdb1 to point at %s, got %s", expected, target)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); !os.IsNotExist(err) {
+		t.Errorf("expected no symlink of the partitioned disk, got %v", err)
+	}
+	if reason := d.Status().SkipReasons["vdc"]; reason != skipPartitioned+": gpt" {
+		t.Errorf("expected vdc to be skipped as partitioned, got %q", reason)
+	}
+
+	// the partition created before is claimed again without partitioning the disk, an
+	// unformatted single partition of another tool is left alone
+	runner.output = `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE="gpt"
+NAME="vdb1" MAJ:MIN="252:17" TYPE="part" SIZE="10736352768" MOUNTPOINT="" PKNAME="vdb" PTTYPE="gpt" PARTLABEL="local-storage"
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE="gpt"
+NAME="vdc1" MAJ:MIN="252:33" TYPE="part" SIZE="10736352768" MOUNTPOINT="" PKNAME="vdc" PTTYPE="gpt" PARTLABEL="db-data"`
+	d.reconcile()
+	if runner.count("sgdisk") != 1 {
+		t.Errorf("expected vdb not to be partitioned again, got calls %v", runner.calls)
+	}
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb1"}) {
+		t.Errorf("expected vdb1 to be claimed, got %v", claimed)
+	}
+	if reason := d.Status().SkipReasons["vdc"]; reason != skipPartitioned+": gpt" {
+		t.Errorf("expected vdc with a foreign partition to be skipped as partitioned, got %q", reason)
+	}
+}
+
+func TestPartitionedExcluded(t *testing.T) {
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	// vdb has a partition table and an unmounted partition, vdc is blank
+	deviceSet, err := d.findNewDisks(`
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE="gpt"
+NAME="vdb1" MAJ:MIN="252:17" TYPE="part" SIZE="10736352768" MOUNTPOINT="" PTTYPE="gpt" PKNAME="vdb"
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE=""`)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+
+	deviceMap, err := d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb", "vdc"}}}, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if hasDevice(deviceMap["foo"], "vdb") || !hasDevice(deviceMap["foo"], "vdc") {
+		t.Errorf("expected partitioned vdb to be excluded, got %v", deviceMap)
+	}
+	if reason := d.skipReasons["vdb"]; reason != skipPartitioned+": gpt" {
+		t.Errorf("expected vdb to be skipped as partitioned, got %q", reason)
+	}
+
+	deviceMap, err = d.findMatchingDisks(context.Background(), DiskConfig{"foo": &Disks{DiskNames: []string{"vdb"}, AllowPartitioned: true}}, deviceSet, nil)
+	if err != nil {
+		t.Fatalf("error finding matching device %v", err)
+	}
+	if !hasDevice(deviceMap["foo"], "vdb") {
+		t.Errorf("expected partitioned vdb to be allowed, got %v", deviceMap)
+	}
+}
+
+func TestPartitionSymlinkTarget(t *testing.T) {
+	defer fakeRoot()()
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	oldDiskByPartUUIDPath := diskByPartUUIDPath
+	diskByPartUUIDPath = filepath.Join(tmpDir, "by-partuuid")
+	defer func() { diskByPartUUIDPath = oldDiskByPartUUIDPath }()
+
+	// vdb was partitioned by an earlier reconcile, vdc is partitioned by this one
+	lsblkOutput := `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE="gpt"
+NAME="vdb1" MAJ:MIN="252:17" TYPE="part" SIZE="10736352768" MOUNTPOINT="" PTTYPE="gpt" PKNAME="vdb" PARTUUID="11111111-aaaa" PARTLABEL="local-storage"
+NAME="vdc" MAJ:MIN="252:32" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" PTTYPE=""`
+	tests := []struct {
+		target   string
+		expected map[string]string
+	}{
+		{PartitionSymlinkTargetRaw, map[string]string{"vdb1": "/dev/vdb1", "vdc1": "/dev/vdc1"}},
+		{PartitionSymlinkTargetPartUUID, map[string]string{
+			"vdb1": filepath.Join(diskByPartUUIDPath, "11111111-aaaa"),
+			"vdc1": filepath.Join(diskByPartUUIDPath, "22222222-bbbb"),
+		}},
+	}
+	for _, test := range tests {
+		configFile := filepath.Join(tmpDir, "config")
+		config := "foo:\n  disks: [vdb, vdc]\n  autoPartition: true\n  force: true\n  partitionSymlinkTarget: " + test.target + "\n"
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("error writing config %v", err)
+		}
+		symlinkLocation := filepath.Join(tmpDir, test.target)
+		d := NewDiskMaker(configFile, symlinkLocation)
+		d.runner = &fakeRunner{output: lsblkOutput, outputs: map[string]string{"blkid": "22222222-bbbb\n"}}
+		d.ProtectSwap = false
+		d.reconcile()
+
+		for linkName, expected := range test.expected {
+			target, err := os.Readlink(filepath.Join(symlinkLocation, "foo", linkName))
+			if err != nil {
+				t.Errorf("%s: expected partition %s to be symlinked, got %v", test.target, linkName, err)
+				continue
+			}
+			if target != expected {
+				t.Errorf("%s: expected symlink of %s to point at %s, got %s", test.target, linkName, expected, target)
+			}
+		}
+	}
+}
+
+func TestPartitionSymlinkTargetValidation(t *testing.T) {
+	tests := map[string]*Disks{
+		"unknown target":     {DiskNames: []string{"vdb"}, AutoPartition: true, Force: true, PartitionSymlinkTarget: "by-label"},
+		"without partitions": {DiskNames: []string{"vdb"}, PartitionSymlinkTarget: PartitionSymlinkTargetRaw},
+	}
+	for name, disks := range tests {
+		if (DiskConfig{"foo": disks}).validate() == nil {
+			t.Errorf("%s: expected configuration to be invalid", name)
+		}
+	}
+}
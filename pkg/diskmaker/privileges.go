@@ -0,0 +1,106 @@
+package diskmaker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Linux capabilities needed by privileged features when not running as root
+const (
+	capDACOverride = 1
+	capSysAdmin    = 21
+)
+
+var capabilityNames = map[uint]string{
+	capDACOverride: "CAP_DAC_OVERRIDE",
+	capSysAdmin:    "CAP_SYS_ADMIN",
+}
+
+var procStatusPath = "/proc/self/status"
+
+// effectiveCapabilities returns the effective capability set of the process
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open(procStatusPath)
+	if err != nil {
+		return 0, fmt.Errorf("error reading capabilities with %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			return strconv.ParseUint(fields[1], 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("no effective capabilities in %s", procStatusPath)
+}
+
+// privilegedFeatures returns the enabled features which write to devices or sysfs,
+// mapped to the capabilities they need without root
+func (d *DiskMaker) privilegedFeatures(diskConfig DiskConfig) map[string][]uint {
+	features := make(map[string][]uint)
+	if d.RescanSCSI {
+		// the scan files of SCSI hosts are only writable by root
+		features["rescanSCSI"] = []uint{capDACOverride}
+	}
+	if d.MetricsOnly || d.ShadowMode {
+		return features
+	}
+	// marker classes never partition, see symLinkDisks
+	for storageClass, disks := range diskConfig {
+		if disks.AutoPartition && disks.ClaimMode != ClaimModeMarker {
+			// writing the partition table through the device node owned by root, and
+			// having the kernel re-read it
+			features[fmt.Sprintf("autoPartition of storageclass %s", storageClass)] = []uint{capDACOverride, capSysAdmin}
+		}
+	}
+	return features
+}
+
+// startupConfig returns the configuration as of startup for checkPrivileges. An
+// unreadable one is checked when a reconcile loads it.
+func (d *DiskMaker) startupConfig() DiskConfig {
+	content, err := d.ConfigSource.Read()
+	if err != nil {
+		return nil
+	}
+	diskConfig, _, err := parseConfig(content)
+	if err != nil {
+		return nil
+	}
+	return diskConfig
+}
+
+// checkPrivileges returns an error if privileged features are enabled but the process
+// is neither root nor has the capabilities they need, rather than having them fail
+// during reconciles. It runs at startup and whenever the configuration is loaded.
+func (d *DiskMaker) checkPrivileges(diskConfig DiskConfig) error {
+	if geteuid() == 0 {
+		return nil
+	}
+	features := d.privilegedFeatures(diskConfig)
+	if len(features) == 0 {
+		return nil
+	}
+	capabilities, err := effectiveCapabilities()
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	for feature, needed := range features {
+		for _, capability := range needed {
+			if capabilities&(1<<capability) == 0 {
+				missing = append(missing, fmt.Sprintf("%s needs %s", feature, capabilityNames[capability]))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("not running as root and missing capabilities: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
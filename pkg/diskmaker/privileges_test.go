@@ -0,0 +1,91 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRoot makes the test run as root, so that privileged features are not checked
+func fakeRoot() func() {
+	oldGeteuid := geteuid
+	geteuid = func() int { return 0 }
+	return func() { geteuid = oldGeteuid }
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldGeteuid := geteuid
+	defer func() { geteuid = oldGeteuid }()
+	oldProcStatusPath := procStatusPath
+	procStatusPath = filepath.Join(tmpDir, "status")
+	defer func() { procStatusPath = oldProcStatusPath }()
+	setCapabilities := func(capEff string) {
+		if err := ioutil.WriteFile(procStatusPath, []byte("Name:\tdiskmaker\nCapEff:\t"+capEff+"\n"), 0644); err != nil {
+			t.Fatalf("error writing status %v", err)
+		}
+	}
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  autoPartition: true\n  force: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.RescanSCSI = true
+	geteuid = func() int { return 1000 }
+	setCapabilities("0000000000000000")
+	err = d.Run(make(chan struct{}))
+	if err == nil {
+		t.Fatalf("expected Run to refuse privileged features without privileges")
+	}
+	for _, expected := range []string{"rescanSCSI needs CAP_DAC_OVERRIDE", "autoPartition of storageclass foo needs CAP_DAC_OVERRIDE", "autoPartition of storageclass foo needs CAP_SYS_ADMIN"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error, got %v", expected, err)
+		}
+	}
+
+	// autoPartition needs CAP_DAC_OVERRIDE on top of CAP_SYS_ADMIN
+	d.RescanSCSI = false
+	setCapabilities("0000000000200000")
+	if err := d.checkPrivileges(d.startupConfig()); err == nil || !strings.Contains(err.Error(), "autoPartition of storageclass foo needs CAP_DAC_OVERRIDE") {
+		t.Errorf("expected autoPartition to need CAP_DAC_OVERRIDE, got %v", err)
+	}
+	d.RescanSCSI = true
+
+	// CAP_DAC_OVERRIDE and CAP_SYS_ADMIN are enough
+	setCapabilities("0000000000200002")
+	if err := d.checkPrivileges(d.startupConfig()); err != nil {
+		t.Errorf("expected capabilities to be sufficient, got %v", err)
+	}
+
+	// root needs no capabilities
+	setCapabilities("0000000000000000")
+	geteuid = func() int { return 0 }
+	if err := d.checkPrivileges(d.startupConfig()); err != nil {
+		t.Errorf("expected root to be sufficient, got %v", err)
+	}
+
+	// without privileged features nothing is checked
+	geteuid = func() int { return 1000 }
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.RescanSCSI = false
+	if err := d.checkPrivileges(d.startupConfig()); err != nil {
+		t.Errorf("expected no privileges to be needed, got %v", err)
+	}
+
+	// a reloaded configuration enabling autoPartition is checked too
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n  autoPartition: true\n  force: true\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	if _, _, err := d.loadConfig(); err == nil || !strings.Contains(err.Error(), "autoPartition of storageclass foo needs CAP_SYS_ADMIN") {
+		t.Errorf("expected the reloaded configuration to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package diskmaker
+
+import (
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// defaultProtectedPaths are the paths whose devices are never claimed by default
+var defaultProtectedPaths = []string{"/var/lib/kubelet"}
+
+// findProtectedNumbers returns major:minor numbers of the devices backing ProtectedPaths
+// mapped to the path, according to the mountinfo of the diskmaker and of the host,
+// whose PID namespace the DaemonSet shares. A path is backed by the device of its
+// closest ancestor mount, paths on virtual filesystems such as the overlay root of a
+// container aren't backed by any device, which is logged.
+func (d *DiskMaker) findProtectedNumbers() (map[string]string, error) {
+	mountInfoPaths := []string{procMountInfoPath, hostMountInfoPath}
+	protected := make(map[string]string)
+	resolved := sets.NewString()
+	for _, mountInfoPath := range mountInfoPaths {
+		entries, err := readMountInfo(mountInfoPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, protectedPath := range d.ProtectedPaths {
+			var closest *mountInfoEntry
+			for i, entry := range entries {
+				if isPathAncestor(entry.mountPoint, path.Clean(protectedPath)) && (closest == nil || len(entry.mountPoint) >= len(closest.mountPoint)) {
+					closest = &entries[i]
+				}
+			}
+			if closest == nil || strings.HasPrefix(closest.majMin, "0:") {
+				continue
+			}
+			resolved.Insert(protectedPath)
+			if _, found := protected[closest.majMin]; !found {
+				protected[closest.majMin] = protectedPath
+			}
+		}
+	}
+	for _, protectedPath := range d.ProtectedPaths {
+		if !resolved.Has(protectedPath) {
+			d.throttledWarningf("protected-path/"+protectedPath, "protected path %s is not backed by any device according to %s, no device is protected for it", protectedPath, strings.Join(mountInfoPaths, " and "))
+		}
+	}
+	return protected, nil
+}
+
+// isPathAncestor returns whether dir is filePath or one of its parent directories
+func isPathAncestor(dir, filePath string) bool {
+	return dir == "/" || filePath == dir || strings.HasPrefix(filePath, dir+"/")
+}
+
+// excludeProtectedPaths removes the devices backing ProtectedPaths from deviceSet,
+// together with the disks they are partitions of and the other partitions of those
+// disks, like the devices of the diskmaker's own storage
+func (d *DiskMaker) excludeProtectedPaths(deviceSet map[string]BlockDevice, allDevices []BlockDevice) error {
+	protected, err := d.findProtectedNumbers()
+	if err != nil {
+		return err
+	}
+	numbers := sets.NewString()
+	for number := range protected {
+		numbers.Insert(number)
+	}
+	for deviceName, number := range backingDevices(numbers, allDevices) {
+		if _, found := deviceSet[deviceName]; found {
+			d.Log.Infof("ignoring device %s because it backs protected path %s", deviceName, protected[number])
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipProtectedPath, protected[number])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludeProtectedPaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "mountinfo")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	// the container root is overlay, the kubelet directory of the host is on vdc1
+	ownMountInfo := filepath.Join(tmpDir, "self")
+	if err := ioutil.WriteFile(ownMountInfo, []byte(`1562 1477 0:340 / / rw,relatime master:555 - overlay overlay rw,lowerdir=/var/lib/containers/l/A
+1563 1562 0:343 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+`), 0644); err != nil {
+		t.Fatalf("error writing mountinfo %v", err)
+	}
+	hostMountInfo := filepath.Join(tmpDir, "host")
+	if err := ioutil.WriteFile(hostMountInfo, []byte(`1 0 8:3 / / rw,relatime shared:1 - xfs /dev/sda3 rw
+60 1 252:33 / /var/lib/kubelet rw,relatime shared:30 - xfs /dev/vdc1 rw
+61 1 252:48 / /var/lib/kubeletdata rw,relatime shared:31 - xfs /dev/vdd rw
+`), 0644); err != nil {
+		t.Fatalf("error writing mountinfo %v", err)
+	}
+	oldProcMountInfoPath, oldHostMountInfoPath := procMountInfoPath, hostMountInfoPath
+	procMountInfoPath, hostMountInfoPath = ownMountInfo, hostMountInfo
+	defer func() { procMountInfoPath, hostMountInfoPath = oldProcMountInfoPath, oldHostMountInfoPath }()
+
+	content := getData() + `
+NAME="vdc1" MAJ:MIN="252:33" TYPE="part" SIZE="10736369664" MOUNTPOINT="" PKNAME="vdc"
+NAME="vdc2" MAJ:MIN="252:34" TYPE="part" SIZE="1048576" MOUNTPOINT="" PKNAME="vdc"`
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	deviceSet, err := d.findNewDisks(content)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	// the kubelet directory is on the overlay root of the container, but the host
+	// mountinfo is read without HostMountInfo
+	if err := d.excludeProtectedPaths(deviceSet, parseBlockDevices(content)); err != nil {
+		t.Fatalf("error excluding protected paths %v", err)
+	}
+	// vdc1 backs /var/lib/kubelet, so its disk and sibling partitions are excluded too
+	for _, deviceName := range []string{"vdc", "vdc1", "vdc2"} {
+		if _, found := deviceSet[deviceName]; found {
+			t.Errorf("expected %s to be excluded", deviceName)
+		}
+		if reason := d.skipReasons[deviceName]; reason != skipProtectedPath+": /var/lib/kubelet" {
+			t.Errorf("expected %s to be skipped as protected, got %q", deviceName, reason)
+		}
+	}
+	// /var/lib/kubeletdata is not an ancestor of /var/lib/kubelet
+	if _, found := deviceSet["vdd"]; !found {
+		t.Errorf("expected vdd to be kept")
+	}
+
+	// paths without mount of their own are backed by the device of their ancestor
+	d.ProtectedPaths = []string{"/var/lib/containers"}
+	deviceSet, _ = d.findNewDisks(content)
+	if err := d.excludeProtectedPaths(deviceSet, parseBlockDevices(content)); err != nil {
+		t.Fatalf("error excluding protected paths %v", err)
+	}
+	if _, found := deviceSet["sda"]; found {
+		t.Errorf("expected the root disk sda to be excluded")
+	}
+	if _, found := deviceSet["vdc"]; !found {
+		t.Errorf("expected vdc to be kept")
+	}
+
+	// a path on virtual filesystems only protects no device
+	hostMountInfoPath = ownMountInfo
+	d.ProtectedPaths = []string{"/var/lib/kubelet"}
+	deviceSet, _ = d.findNewDisks(content)
+	protected, err := d.findProtectedNumbers()
+	if err != nil || len(protected) != 0 {
+		t.Errorf("expected no device to back the overlay root, got %v %v", protected, err)
+	}
+}
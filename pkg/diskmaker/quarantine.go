@@ -0,0 +1,62 @@
+package diskmaker
+
+import (
+	"time"
+)
+
+var (
+	// quarantineThreshold is the number of consecutive failures after which a device is quarantined
+	quarantineThreshold = 3
+	// quarantineCooldown is how long a quarantined device is left alone before it is retried
+	quarantineCooldown = 10 * time.Minute
+)
+
+// quarantine tracks devices that keep failing to be symlinked, so that they are not
+// retried on every reconcile. It is only used from the reconcile loop.
+type quarantine struct {
+	threshold int
+	cooldown  time.Duration
+	failures  map[string]int
+	until     map[string]time.Time
+	now       func() time.Time
+}
+
+func newQuarantine(threshold int, cooldown time.Duration) *quarantine {
+	return &quarantine{
+		threshold: threshold,
+		cooldown:  cooldown,
+		failures:  make(map[string]int),
+		until:     make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// isQuarantined returns true if device is quarantined. A device whose cooldown
+// elapsed is released and gets a fresh set of attempts.
+func (q *quarantine) isQuarantined(device string) bool {
+	until, ok := q.until[device]
+	if !ok {
+		return false
+	}
+	if q.now().Before(until) {
+		return true
+	}
+	delete(q.until, device)
+	delete(q.failures, device)
+	return false
+}
+
+// recordFailure counts a failure for device and returns true if it got quarantined
+func (q *quarantine) recordFailure(device string) bool {
+	q.failures[device]++
+	if q.failures[device] < q.threshold {
+		return false
+	}
+	q.until[device] = q.now().Add(q.cooldown)
+	return true
+}
+
+// recordSuccess resets the failure count of device
+func (q *quarantine) recordSuccess(device string) {
+	delete(q.failures, device)
+}
@@ -0,0 +1,61 @@
+package diskmaker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuarantineFailingDevice(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{})()
+
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	now := time.Now()
+	d.quarantine.now = func() time.Time { return now }
+
+	// a directory in place of the symlink makes symlinking vdc fail
+	blocker := filepath.Join(tmpDir, "local-storage", "foo", "vdc")
+	if err := os.MkdirAll(blocker, 0755); err != nil {
+		t.Fatalf("error creating blocker %v", err)
+	}
+	diskConfig := DiskConfig{"foo": &Disks{DiskNames: []string{"vdc"}}}
+
+	for i := 0; i < quarantineThreshold; i++ {
+		d.skipReasons = make(map[string]string)
+		d.symLinkDisks(context.Background(), diskConfig)
+		if !strings.HasPrefix(d.skipReasons["vdc"], skipFailed) {
+			t.Fatalf("expected vdc to fail in cycle %d, got %q", i, d.skipReasons["vdc"])
+		}
+	}
+
+	os.RemoveAll(blocker)
+	d.skipReasons = make(map[string]string)
+	d.symLinkDisks(context.Background(), diskConfig)
+	if d.skipReasons["vdc"] != skipQuarantined {
+		t.Errorf("expected vdc to be quarantined, got %q", d.skipReasons["vdc"])
+	}
+	if _, err := os.Lstat(blocker); !os.IsNotExist(err) {
+		t.Errorf("expected quarantined vdc not to be symlinked")
+	}
+
+	now = now.Add(quarantineCooldown)
+	d.skipReasons = make(map[string]string)
+	linked := d.symLinkDisks(context.Background(), diskConfig)
+	if len(linked["foo"]) != 1 {
+		t.Errorf("expected vdc to be symlinked after cooldown, got %v, skipped %v", linked, d.skipReasons)
+	}
+	if _, err := os.Readlink(blocker); err != nil {
+		t.Errorf("expected symlink for vdc after cooldown, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Release removes the symlink of a device claimed for storageClass and stops the device
+// from being claimed for it again until Unrelease is called, for example during
+// maintenance of the disk. Released devices are kept in memory and, if
+// ReleasedDevicesPath is set, persisted there.
+func (d *DiskMaker) Release(storageClass, deviceName string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	released, err := d.releasedDevices()
+	if err != nil {
+		return err
+	}
+	if released[storageClass] == nil {
+		released[storageClass] = sets.NewString()
+	}
+	released[storageClass].Insert(deviceName)
+	err = d.saveReleased()
+	if err != nil {
+		return err
+	}
+
+	err = d.removeReleasedLinkLocked(storageClass, deviceName)
+	if err != nil {
+		return err
+	}
+	d.Log.Infof("released device %s of storageclass %s", deviceName, storageClass)
+	return nil
+}
+
+// Unrelease allows a device released by Release to be claimed for storageClass again
+func (d *DiskMaker) Unrelease(storageClass, deviceName string) error {
+	d.lock.Lock()
+	released, err := d.releasedDevices()
+	if err == nil && released[storageClass].Has(deviceName) {
+		released[storageClass].Delete(deviceName)
+		if released[storageClass].Len() == 0 {
+			delete(released, storageClass)
+		}
+		err = d.saveReleased()
+	}
+	d.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	d.Trigger()
+	return nil
+}
+
+// isReleased returns whether the device must not be claimed for storageClass. It fails
+// if the released devices can't be loaded, as any device may be released then.
+func (d *DiskMaker) isReleased(storageClass, deviceName string) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	released, err := d.releasedDevices()
+	if err != nil {
+		return false, err
+	}
+	return released[storageClass].Has(deviceName), nil
+}
+
+// removeReleasedLinks removes the symlinks of released devices. Links created by a
+// reconcile racing with Release are removed too.
+func (d *DiskMaker) removeReleasedLinks() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	released, err := d.releasedDevices()
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for storageClass, devices := range released {
+		for _, deviceName := range devices.List() {
+			err := d.removeReleasedLinkLocked(storageClass, deviceName)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// removeReleasedLinkLocked removes the symlink of a released device and its metadata.
+// d.lock must be held.
+func (d *DiskMaker) removeReleasedLinkLocked(storageClass, deviceName string) error {
+	linkPath := path.Join(d.symlinkLocation, storageClass, d.linkNameLocked(storageClass, deviceName))
+	err := d.fs.Remove(linkPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error removing symlink %s with %v", linkPath, err)
+	}
+	d.removeMeta(linkPath)
+	return nil
+}
+
+// linkNameLocked returns name of the symlink of a device claimed by the previous reconcile
+func (d *DiskMaker) linkNameLocked(storageClass, deviceName string) string {
+	for _, deviceLocation := range d.claimed[storageClass] {
+		if deviceLocation.diskName == deviceName {
+			return deviceLocation.symlinkName()
+		}
+	}
+	return deviceName
+}
+
+// releasedDevices returns released devices keyed by storageclass, loading them from
+// ReleasedDevicesPath on first use. Failing loads are retried on the next use rather
+// than cached, so that the persisted releases aren't overwritten by saveReleased.
+// d.lock must be held.
+func (d *DiskMaker) releasedDevices() (map[string]sets.String, error) {
+	if d.released != nil {
+		return d.released, nil
+	}
+	released := make(map[string]sets.String)
+	if d.ReleasedDevicesPath != "" {
+		content, err := ioutil.ReadFile(d.ReleasedDevicesPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read released devices %s with %v", d.ReleasedDevicesPath, err)
+		}
+		persisted := make(map[string][]string)
+		if err == nil {
+			err = json.Unmarshal(content, &persisted)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling released devices %s with %v", d.ReleasedDevicesPath, err)
+			}
+		}
+		for storageClass, devices := range persisted {
+			released[storageClass] = sets.NewString(devices...)
+		}
+	}
+	d.released = released
+	return d.released, nil
+}
+
+// saveReleased persists released devices to ReleasedDevicesPath. d.lock must be held.
+func (d *DiskMaker) saveReleased() error {
+	if d.ReleasedDevicesPath == "" {
+		return nil
+	}
+	persisted := make(map[string][]string)
+	for storageClass, devices := range d.released {
+		persisted[storageClass] = devices.List()
+	}
+	content, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(d.ReleasedDevicesPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write released devices %s with %v", d.ReleasedDevicesPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelease(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	releasedPath := filepath.Join(tmpDir, "released")
+	newDiskMaker := func() *DiskMaker {
+		d := NewDiskMaker(configFile, symlinkLocation)
+		d.runner = &fakeRunner{output: getData()}
+		d.ProtectSwap = false
+		d.ReleasedDevicesPath = releasedPath
+		return d
+	}
+	d := newDiskMaker()
+	d.reconcile()
+	vdbLink := filepath.Join(symlinkLocation, "foo", "vdb")
+	if _, err := os.Lstat(vdbLink); err != nil {
+		t.Fatalf("expected vdb to be symlinked, got %v", err)
+	}
+
+	if err := d.Release("foo", "vdb"); err != nil {
+		t.Fatalf("error releasing vdb %v", err)
+	}
+	if _, err := os.Lstat(vdbLink); !os.IsNotExist(err) {
+		t.Errorf("expected symlink of released vdb to be removed")
+	}
+	if _, err := os.Lstat(vdbLink + metaSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected metadata of released vdb to be removed")
+	}
+	// a reconcile racing with Release may have symlinked vdb again
+	if err := os.Symlink("/dev/vdb", vdbLink); err != nil {
+		t.Fatalf("error symlinking vdb %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		d.reconcile()
+	}
+	if _, err := os.Lstat(vdbLink); !os.IsNotExist(err) {
+		t.Errorf("expected released vdb not to be claimed again")
+	}
+	if reason := d.Status().SkipReasons["vdb"]; reason != skipReleased {
+		t.Errorf("expected vdb to be skipped as released, got %q", reason)
+	}
+
+	// the release survives a restart
+	d = newDiskMaker()
+	d.reconcile()
+	if _, err := os.Lstat(vdbLink); !os.IsNotExist(err) {
+		t.Errorf("expected persisted release of vdb to be honored")
+	}
+
+	if err := d.Unrelease("foo", "vdb"); err != nil {
+		t.Fatalf("error unreleasing vdb %v", err)
+	}
+	d.reconcile()
+	if _, err := os.Lstat(vdbLink); err != nil {
+		t.Errorf("expected unreleased vdb to be symlinked again, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdc")); err != nil {
+		t.Errorf("expected vdc to stay symlinked, got %v", err)
+	}
+}
+
+func TestReleasedDevicesLoadFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	releasedPath := filepath.Join(tmpDir, "released")
+	if err := ioutil.WriteFile(releasedPath, []byte("{\"foo\": [\"vdb\""), 0644); err != nil {
+		t.Fatalf("error writing released devices %v", err)
+	}
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.ReleasedDevicesPath = releasedPath
+
+	d.reconcile()
+	for _, diskName := range []string{"vdb", "vdc"} {
+		if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", diskName)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be claimed while released devices can't be loaded", diskName)
+		}
+	}
+	if err := d.Release("foo", "vdc"); err == nil {
+		t.Errorf("expected release to fail while released devices can't be loaded")
+	}
+
+	if err := ioutil.WriteFile(releasedPath, []byte("{\"foo\": [\"vdb\"]}"), 0644); err != nil {
+		t.Fatalf("error writing released devices %v", err)
+	}
+	d.reconcile()
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdb")); !os.IsNotExist(err) {
+		t.Errorf("expected released vdb not to be claimed once loaded")
+	}
+	if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", "vdc")); err != nil {
+		t.Errorf("expected vdc to be symlinked once released devices are loaded, got %v", err)
+	}
+}
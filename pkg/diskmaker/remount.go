@@ -0,0 +1,63 @@
+package diskmaker
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fileID identifies a directory across reconciles, it changes when the volume the
+// directory lives on is remounted
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+var statFileID = func(path string) (fileID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileID{}, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fmt.Errorf("unable to get device and inode of %s", path)
+	}
+	return fileID{dev: uint64(stat.Dev), ino: stat.Ino}, nil
+}
+
+// checkSymlinkLocation re-creates the symlinks of all claimed devices if symlinkLocation
+// is no longer the directory it was in the previous reconcile, as after a remount of
+// its volume which loses the symlinks created so far
+func (d *DiskMaker) checkSymlinkLocation() {
+	id, err := statFileID(d.symlinkLocation)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.Log.Errorf("error checking %s with %v", d.symlinkLocation, err)
+		}
+		return
+	}
+	previous := d.symlinkLocationID
+	d.symlinkLocationID = id
+	if previous == (fileID{}) || previous == id {
+		return
+	}
+	d.Recorder.Eventf(corev1.EventTypeWarning, symlinkLocationChangedReason, "%s changed from device %d inode %d to device %d inode %d, re-creating all symlinks", d.symlinkLocation, previous.dev, previous.ino, id.dev, id.ino)
+	d.lock.Lock()
+	claimed := d.claimed
+	d.lock.Unlock()
+	for storageClass, deviceArray := range claimed {
+		for _, deviceLocation := range deviceArray {
+			err := d.createSymlink(storageClass, deviceLocation)
+			if err != nil {
+				d.reconcileErrorf("error re-creating symlink of device %s for storageclass %s: %v", deviceLocation.diskName, storageClass, err)
+				continue
+			}
+			err = d.writeMeta(storageClass, deviceLocation)
+			if err != nil {
+				d.reconcileErrorf("error writing metadata of device %s for storageclass %s: %v", deviceLocation.diskName, storageClass, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelinkAfterRemount(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	remounted := false
+	oldStatFileID := statFileID
+	statFileID = func(path string) (fileID, error) {
+		id, err := oldStatFileID(path)
+		if remounted {
+			id.dev++
+		}
+		return id, err
+	}
+	defer func() { statFileID = oldStatFileID }()
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	recorder := &fakeRecorder{}
+	runner := &fakeRunner{output: getData()}
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = runner
+	d.Recorder = recorder
+	d.ProtectSwap = false
+	d.reconcile()
+	d.reconcile()
+	if events := recorder.withReason(symlinkLocationChangedReason); len(events) != 0 {
+		t.Fatalf("expected no events without a remount, got %v", recorder.events)
+	}
+
+	// the remounted volume is empty, and lsblk fails so only the remount check relinks
+	if err := os.RemoveAll(symlinkLocation); err != nil {
+		t.Fatalf("error removing symlinks %v", err)
+	}
+	if err := os.MkdirAll(symlinkLocation, 0755); err != nil {
+		t.Fatalf("error creating symlink location %v", err)
+	}
+	remounted = true
+	runner.errors = map[string]error{"lsblk": fmt.Errorf("lsblk failed")}
+	d.reconcile()
+	for _, diskName := range []string{"vdb", "vdc"} {
+		if _, err := os.Lstat(filepath.Join(symlinkLocation, "foo", diskName)); err != nil {
+			t.Errorf("expected %s to be symlinked again, got %v", diskName, err)
+		}
+	}
+	if events := recorder.withReason(symlinkLocationChangedReason); len(events) != 1 {
+		t.Errorf("expected a %s event, got %v", symlinkLocationChangedReason, recorder.events)
+	}
+}
@@ -0,0 +1,29 @@
+package diskmaker
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var scsiHostPath = "/sys/class/scsi_host"
+
+// rescanSCSI asks every SCSI host to scan all channels, targets and LUNs, so that LUNs
+// newly attached to a SAN show up before devices are listed, see DiskMaker.RescanSCSI.
+// Failures are only logged as discovery can proceed with the devices already known.
+func (d *DiskMaker) rescanSCSI() {
+	hosts, err := d.fs.ReadDir(scsiHostPath)
+	if err != nil {
+		d.throttledWarningf("rescan-scsi", "unable to list SCSI hosts in %s: %v", scsiHostPath, err)
+		return
+	}
+	for _, host := range hosts {
+		if !strings.HasPrefix(host.Name(), "host") {
+			continue
+		}
+		scanPath := filepath.Join(scsiHostPath, host.Name(), "scan")
+		err = d.fs.WriteFile(scanPath, []byte("- - -"), 0644)
+		if err != nil {
+			d.throttledWarningf("rescan-scsi/"+host.Name(), "unable to rescan SCSI host %s: %v", host.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRescanSCSI(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldScsiHostPath := scsiHostPath
+	defer func() { scsiHostPath = oldScsiHostPath }()
+	scsiHostPath = filepath.Join(tmpDir, "scsi_host")
+	for _, host := range []string{"host0", "host1"} {
+		if err := os.MkdirAll(filepath.Join(scsiHostPath, host), 0755); err != nil {
+			t.Fatalf("error creating scsi host %v", err)
+		}
+	}
+
+	d := NewDiskMaker("/tmp/foo", filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	// lsblk calls made by the time each file was written
+	scans := map[string]int{}
+	d.fs = &fakeFS{writeFileHook: func(filename string) {
+		scans[filename] = runner.count("lsblk")
+	}}
+
+	d.findCandidateDisks()
+	if len(scans) != 0 {
+		t.Errorf("expected no rescan unless enabled, got %v", scans)
+	}
+
+	d.RescanSCSI = true
+	d.findCandidateDisks()
+	for _, host := range []string{"host0", "host1"} {
+		scanPath := filepath.Join(scsiHostPath, host, "scan")
+		calls, found := scans[scanPath]
+		if !found {
+			t.Errorf("expected %s to be written", scanPath)
+			continue
+		}
+		if calls != 1 {
+			t.Errorf("expected %s to be written before devices are listed", scanPath)
+		}
+		content, err := ioutil.ReadFile(scanPath)
+		if err != nil || string(content) != "- - -" {
+			t.Errorf("expected wildcard scan of %s, got %q, %v", host, content, err)
+		}
+	}
+	if runner.count("lsblk") != 2 {
+		t.Errorf("expected devices to be listed after the rescan, got calls %v", runner.calls)
+	}
+}
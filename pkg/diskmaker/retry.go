@@ -0,0 +1,31 @@
+package diskmaker
+
+import (
+	"time"
+)
+
+// defaultSymlinkRetry is the RetryPolicy of symlink creation unless configured otherwise
+var defaultSymlinkRetry = RetryPolicy{Attempts: 3, Backoff: 100 * time.Millisecond}
+
+// RetryPolicy bounds the retries of an operation that may fail transiently
+type RetryPolicy struct {
+	// Attempts is the number of times the operation is tried, including the first one
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles before every further one
+	Backoff time.Duration
+}
+
+// retry runs op until it succeeds, retryable returns false for its error or the
+// attempts of the policy are used up, and returns the last error
+func (p RetryPolicy) retry(op func() error, retryable func(error) bool) error {
+	backoff := p.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,64 @@
+package diskmaker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// errCommandTimeout is returned by runWithTimeout for commands killed after their timeout
+var errCommandTimeout = errors.New("command timed out")
+
+// CommandRunner runs external commands such as lsblk on behalf of the DiskMaker.
+// It exists so that tests can substitute canned command output.
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+	// RunContext is Run, killing the command once ctx is done
+	RunContext(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// execRunner runs commands on the host using os/exec
+type execRunner struct{}
+
+func (r execRunner) Run(name string, args ...string) ([]byte, error) {
+	return r.RunContext(context.Background(), name, args...)
+}
+
+func (execRunner) RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return out.Bytes(), ctxErr
+	}
+	return out.Bytes(), err
+}
+
+// run runs a command on behalf of the current reconcile, killing it once the reconcile
+// is aborted, see ReconcileTimeout
+func (d *DiskMaker) run(name string, args ...string) ([]byte, error) {
+	ctx := d.reconcileCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return d.runner.RunContext(ctx, name, args...)
+}
+
+// runWithTimeout is run, killing the command once timeout passed too. Commands killed
+// for the timeout return errCommandTimeout.
+func (d *DiskMaker) runWithTimeout(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	parent := d.reconcileCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	out, err := d.runner.RunContext(ctx, name, args...)
+	if err != nil && parent.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+		return out, errCommandTimeout
+	}
+	return out, err
+}
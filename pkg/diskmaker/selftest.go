@@ -0,0 +1,54 @@
+package diskmaker
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// selfTestFileName is written to and removed from symlinkLocation by SelfTest
+const selfTestFileName = ".diskmaker-self-test"
+
+// SelfTest checks that the DiskMaker has the access it needs on the node: listing
+// /dev/disk/by-id, running lsblk and writing to symlinkLocation. It changes nothing
+// but a temporary file. Every check is logged, the returned error lists failed checks.
+func (d *DiskMaker) SelfTest() error {
+	byIDDir := filepath.Dir(diskByIDPath)
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"read " + byIDDir, func() error {
+			_, err := d.fs.ReadDir(byIDDir)
+			return err
+		}},
+		{"run " + d.LsblkPath, func() error {
+			_, err := d.runner.Run(d.LsblkPath, "--pairs", "-o", "NAME")
+			return err
+		}},
+		{"write to " + d.symlinkLocation, func() error {
+			testFile := path.Join(d.symlinkLocation, selfTestFileName)
+			err := d.fs.WriteFile(testFile, []byte{}, 0644)
+			if err != nil {
+				return err
+			}
+			return d.fs.Remove(testFile)
+		}},
+	}
+
+	failed := []string{}
+	for _, c := range checks {
+		err := c.check()
+		if err != nil {
+			d.Log.Errorf("self-test %s: failed with %v", c.name, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, err))
+			continue
+		}
+		d.Log.Infof("self-test %s: ok", c.name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("self-test failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
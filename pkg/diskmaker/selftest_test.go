@@ -0,0 +1,54 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+
+	d := NewDiskMaker("/tmp/foo", tmpDir)
+	d.runner = &fakeRunner{output: getData()}
+	if err := d.SelfTest(); err != nil {
+		t.Errorf("expected self-test to pass, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpDir, selfTestFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected self-test file to be removed")
+	}
+}
+
+func TestSelfTestFailures(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+
+	d := NewDiskMaker("/tmp/foo", tmpDir)
+	d.runner = &fakeRunner{errors: map[string]error{"lsblk": fmt.Errorf("executable file not found in $PATH")}}
+	d.fs = &fakeFS{
+		readDirErr:   &os.PathError{Op: "open", Path: filepath.Dir(diskByIDPath), Err: syscall.EACCES},
+		writeFileErr: &os.PathError{Op: "open", Path: filepath.Join(tmpDir, selfTestFileName), Err: syscall.EROFS},
+	}
+	err = d.SelfTest()
+	if err == nil {
+		t.Fatalf("expected self-test to fail")
+	}
+	for _, expected := range []string{"permission denied", "executable file not found", "read-only file system"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q to be reported, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ShadowReport compares devices the DiskMaker would claim with devices symlinked by
+// another tool, see DiskMaker.ShadowMode
+type ShadowReport struct {
+	Time time.Time `json:"time"`
+	// WouldClaim maps storageclass names to devices that would be symlinked for them
+	WouldClaim map[string][]string `json:"wouldClaim"`
+	// LinkedElsewhere maps devices symlinked in ShadowLinkLocation to their symlinks
+	LinkedElsewhere map[string][]string `json:"linkedElsewhere"`
+	// WouldClaimOnly are devices that would be claimed but are not linked elsewhere
+	WouldClaimOnly []string `json:"wouldClaimOnly"`
+	// LinkedElsewhereOnly are devices linked elsewhere that would not be claimed
+	LinkedElsewhereOnly []string `json:"linkedElsewhereOnly"`
+	// Both are devices that would be claimed and are linked elsewhere
+	Both []string `json:"both"`
+}
+
+// writeShadowReport compares deviceMap with links in ShadowLinkLocation and writes
+// the result to ShadowReportPath
+func (d *DiskMaker) writeShadowReport(deviceMap map[string][]DiskLocation) error {
+	report := ShadowReport{
+		Time:            time.Now(),
+		WouldClaim:      make(map[string][]string),
+		LinkedElsewhere: make(map[string][]string),
+	}
+	wouldClaim := sets.NewString()
+	for storageClass, deviceArray := range deviceMap {
+		for _, deviceLocation := range deviceArray {
+			report.WouldClaim[storageClass] = append(report.WouldClaim[storageClass], deviceLocation.diskName)
+			wouldClaim.Insert(deviceLocation.diskName)
+		}
+		sort.Strings(report.WouldClaim[storageClass])
+	}
+	if d.ShadowLinkLocation != "" {
+		err := filepath.Walk(d.ShadowLinkLocation, func(linkPath string, info os.FileInfo, err error) error {
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return err
+			}
+			devPath, err := d.fs.EvalSymlinks(linkPath)
+			if err != nil {
+				d.Log.Infof("ignoring %s, unable to resolve it: %v", linkPath, err)
+				return nil
+			}
+			diskName := filepath.Base(devPath)
+			report.LinkedElsewhere[diskName] = append(report.LinkedElsewhere[diskName], linkPath)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error reading links in %s with %v", d.ShadowLinkLocation, err)
+		}
+	}
+	linkedElsewhere := sets.StringKeySet(report.LinkedElsewhere)
+	report.WouldClaimOnly = wouldClaim.Difference(linkedElsewhere).List()
+	report.LinkedElsewhereOnly = linkedElsewhere.Difference(wouldClaim).List()
+	report.Both = wouldClaim.Intersection(linkedElsewhere).List()
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(d.ShadowReportPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write shadow report %s with %v", d.ShadowReportPath, err)
+	}
+	d.Log.Infof("shadow mode: %d devices would be claimed, %d of them are linked in %s", wouldClaim.Len(), len(report.Both), d.ShadowLinkLocation)
+	return nil
+}
@@ -0,0 +1,71 @@
+package diskmaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShadowMode(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	// the other provisioner already linked vdc and vdd
+	foreignDir := filepath.Join(tmpDir, "disks", "local-ssd")
+	if err := os.MkdirAll(foreignDir, 0755); err != nil {
+		t.Fatalf("error creating foreign link dir %v", err)
+	}
+	for _, diskName := range []string{"vdc", "vdd"} {
+		if err := os.Symlink(filepath.Join(tmpDir, diskName), filepath.Join(foreignDir, diskName)); err != nil {
+			t.Fatalf("error creating foreign link %v", err)
+		}
+	}
+
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.ShadowMode = true
+	d.ShadowLinkLocation = filepath.Join(tmpDir, "disks")
+	d.ShadowReportPath = filepath.Join(tmpDir, "report.json")
+	d.reconcile()
+
+	if _, err := os.Stat(symlinkLocation); !os.IsNotExist(err) {
+		t.Errorf("expected no symlinks to be created in shadow mode, got %v", err)
+	}
+	if claimed := d.Status().Claimed; len(claimed) != 0 {
+		t.Errorf("expected nothing to be claimed in shadow mode, got %v", claimed)
+	}
+	content, err := ioutil.ReadFile(d.ShadowReportPath)
+	if err != nil {
+		t.Fatalf("error reading shadow report %v", err)
+	}
+	var report ShadowReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatalf("error parsing shadow report %v", err)
+	}
+	if !equalStrings(report.WouldClaim["foo"], []string{"vdb", "vdc"}) {
+		t.Errorf("expected vdb and vdc would be claimed, got %v", report.WouldClaim)
+	}
+	if links := report.LinkedElsewhere["vdd"]; len(links) != 1 || links[0] != filepath.Join(foreignDir, "vdd") {
+		t.Errorf("expected vdd to be linked elsewhere, got %v", report.LinkedElsewhere)
+	}
+	if !equalStrings(report.WouldClaimOnly, []string{"vdb"}) {
+		t.Errorf("expected only vdb to be claimed just here, got %v", report.WouldClaimOnly)
+	}
+	if !equalStrings(report.LinkedElsewhereOnly, []string{"vdd"}) {
+		t.Errorf("expected only vdd to be linked just elsewhere, got %v", report.LinkedElsewhereOnly)
+	}
+	if !equalStrings(report.Both, []string{"vdc"}) {
+		t.Errorf("expected vdc to be claimed by both, got %v", report.Both)
+	}
+}
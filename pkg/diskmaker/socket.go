@@ -0,0 +1,65 @@
+package diskmaker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// socketTimeout bounds how long a client of SocketPath may take to send its request
+// and read the response
+const socketTimeout = 10 * time.Second
+
+// socketResponse answers requests other than status on SocketPath
+type socketResponse struct {
+	Error string `json:"error"`
+}
+
+// listenStatusSocket listens on SocketPath, replacing the socket left by a previous run
+func (d *DiskMaker) listenStatusSocket() (net.Listener, error) {
+	err := os.Remove(d.SocketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing stale socket %s with %v", d.SocketPath, err)
+	}
+	listener, err := net.Listen("unix", d.SocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s with %v", d.SocketPath, err)
+	}
+	return listener, nil
+}
+
+// serveStatusSocket answers connections on listener until it is closed
+func (d *DiskMaker) serveStatusSocket(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			d.Log.Debugf("stopped serving %s: %v", d.SocketPath, err)
+			return
+		}
+		go d.answerSocket(conn)
+	}
+}
+
+// answerSocket reads a single request line, "status", and writes the current Status
+// as a line of JSON before closing the connection
+func (d *DiskMaker) answerSocket(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		d.Log.Debugf("error reading request on %s: %v", d.SocketPath, err)
+		return
+	}
+	var response interface{} = d.Status()
+	if request = strings.TrimSpace(request); request != "status" {
+		response = socketResponse{fmt.Sprintf("unknown request %q, expected status", request)}
+	}
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		d.Log.Debugf("error answering request on %s: %v", d.SocketPath, err)
+	}
+}
@@ -0,0 +1,85 @@
+package diskmaker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatusSocket(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = time.Hour
+	defer func() { checkDuration = oldCheckDuration }()
+
+	socketPath := filepath.Join(tmpDir, "diskmaker.sock")
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.SocketPath = socketPath
+	d.ProtectSwap = false
+	d.runner = &fakeRunner{output: getData()}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.Run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.Status().Claimed["foo"]) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected vdb to be claimed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	request := func(line string, response interface{}) {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("error connecting to %s %v", socketPath, err)
+		}
+		defer conn.Close()
+		if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+			t.Fatalf("error sending request %v", err)
+		}
+		content, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("error reading response %v", err)
+		}
+		if err := json.Unmarshal(content, response); err != nil {
+			t.Fatalf("error parsing response %q %v", content, err)
+		}
+	}
+	var status Status
+	request("status", &status)
+	if claimed := status.Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected vdb to be claimed in the status served on the socket, got %v", status.Claimed)
+	}
+	if status.LastReconcile.IsZero() {
+		t.Errorf("expected the time of the last reconcile in the status")
+	}
+
+	var response socketResponse
+	request("inventory", &response)
+	if response.Error == "" {
+		t.Errorf("expected an error for an unknown request")
+	}
+}
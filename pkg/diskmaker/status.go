@@ -0,0 +1,187 @@
+package diskmaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reasons for which a device was not symlinked, see Status.SkipReasons
+const (
+	skipMounted        = "mounted"
+	skipSwap           = "swap"
+	skipFormatted      = "formatted"
+	skipExcluded       = "excluded"
+	skipNotAllowlisted = "not-allowlisted"
+	skipNoMatch        = "no-match"
+	skipQuarantined    = "quarantined"
+	skipFailed         = "failed"
+	skipDisabled       = "disabled"
+	skipDuplicateID    = "duplicate-id"
+	skipCapacity       = "capacity"
+	skipReleased       = "released"
+	skipOwnStorage     = "own-storage"
+	skipConflict       = "conflict"
+	skipOpen           = "open"
+	skipFraction       = "claim-fraction"
+	skipUdevExcluded   = "udev-excluded"
+	skipThin           = "thin-provisioned"
+	skipPartitioned    = "partitioned"
+	skipNameCollision  = "name-collision"
+	skipDrained        = "drained"
+	skipUnhealthy      = "unhealthy"
+	skipStackMember    = "stack-member"
+	skipIOErrors       = "io-errors"
+	skipProtectedPath  = "protected-path"
+	skipNodeLabels     = "node-labels"
+	skipFilterCommand  = "filter-command"
+	skipMarked         = "marked"
+)
+
+// Status describes the outcome of the most recent reconcile
+type Status struct {
+	// NodeName is the name of the node, see IdentityProvider
+	NodeName string `json:"nodeName,omitempty"`
+	// LastReconcile is when the most recent reconcile finished
+	LastReconcile time.Time `json:"lastReconcile"`
+	// Claimed maps storageclass names to the devices successfully symlinked for them
+	Claimed map[string][]string `json:"claimed"`
+	// SkipReasons maps names of devices that were not symlinked to the reason why
+	SkipReasons map[string]string `json:"skipReasons"`
+	// InvalidClasses maps storageclasses left out of the configuration as invalid to
+	// the reason why, see NodeSettings.PartialApply
+	InvalidClasses map[string]string `json:"invalidClasses,omitempty"`
+	// Devices maps names of the block devices found on the node to their attributes
+	Devices map[string]Device `json:"devices,omitempty"`
+}
+
+// Device describes a block device found on the node, see Status.Devices
+type Device struct {
+	// Type is the type reported by lsblk, such as disk or part
+	Type string `json:"type"`
+	// Size is the size in bytes, 0 if unknown
+	Size       int64  `json:"size"`
+	Model      string `json:"model,omitempty"`
+	Transport  string `json:"transport,omitempty"`
+	Rotational bool   `json:"rotational"`
+	FSType     string `json:"fstype,omitempty"`
+}
+
+// ReconcileResult is passed to DiskMaker.OnReconcile at the end of every reconcile
+type ReconcileResult struct {
+	// Claimed and SkipReasons are those of the last reconcile that loaded its
+	// configuration, see Status
+	Claimed     map[string][]string
+	SkipReasons map[string]string
+	// Errors are the errors encountered by the reconcile
+	Errors []error
+}
+
+// Status returns the status of the most recent reconcile
+func (d *DiskMaker) Status() Status {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	status := Status{
+		NodeName:      d.NodeName,
+		LastReconcile: d.status.LastReconcile,
+		Claimed:       make(map[string][]string),
+		SkipReasons:   make(map[string]string),
+	}
+	if len(d.status.InvalidClasses) > 0 {
+		status.InvalidClasses = make(map[string]string)
+		for storageClass, reason := range d.status.InvalidClasses {
+			status.InvalidClasses[storageClass] = reason
+		}
+	}
+	for storageClass, devices := range d.status.Claimed {
+		status.Claimed[storageClass] = append([]string{}, devices...)
+	}
+	for device, reason := range d.status.SkipReasons {
+		status.SkipReasons[device] = reason
+	}
+	if len(d.status.Devices) > 0 {
+		status.Devices = make(map[string]Device)
+		for name, device := range d.status.Devices {
+			status.Devices[name] = device
+		}
+	}
+	return status
+}
+
+// reconcileErrorf logs an error and records it in the result of the current reconcile
+func (d *DiskMaker) reconcileErrorf(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	d.Log.Error(err)
+	d.reconcileErrors = append(d.reconcileErrors, err)
+	d.publishEvent(ReconcileEvent{Type: ReconcileEventError, Error: err})
+}
+
+func (d *DiskMaker) reconcileResult() ReconcileResult {
+	status := d.Status()
+	return ReconcileResult{
+		Claimed:     status.Claimed,
+		SkipReasons: status.SkipReasons,
+		Errors:      append([]error{}, d.reconcileErrors...),
+	}
+}
+
+// skipDevice records why a device is not being symlinked in the current reconcile
+func (d *DiskMaker) skipDevice(diskName, reason, detail string) {
+	if detail != "" {
+		reason = fmt.Sprintf("%s: %s", reason, detail)
+	}
+	d.skipReasons[diskName] = reason
+}
+
+// recordUnmatched records candidate devices that no storageclass claimed
+func (d *DiskMaker) recordUnmatched(deviceSet map[string]BlockDevice, deviceMap map[string][]DiskLocation) {
+	for _, deviceArray := range deviceMap {
+		for _, deviceLocation := range deviceArray {
+			delete(d.skipReasons, deviceLocation.diskName)
+		}
+	}
+	for diskName := range deviceSet {
+		if !isClaimed(deviceMap, diskName) {
+			if _, ok := d.skipReasons[diskName]; !ok {
+				d.skipDevice(diskName, skipNoMatch, "")
+			}
+		}
+	}
+}
+
+// updateStatus publishes results of a finished reconcile
+func (d *DiskMaker) updateStatus(deviceMap map[string][]DiskLocation) {
+	claimed := make(map[string][]string)
+	claimedBytes.Reset()
+	for storageClass, deviceArray := range deviceMap {
+		size := int64(0)
+		for _, deviceLocation := range deviceArray {
+			claimed[storageClass] = append(claimed[storageClass], deviceLocation.diskName)
+			size += deviceLocation.size
+		}
+		claimedBytes.WithLabelValues(storageClass).Set(float64(size))
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.status = Status{
+		LastReconcile: time.Now(),
+		Claimed:       claimed,
+		SkipReasons:   d.skipReasons,
+	}
+	if len(d.invalidClasses) > 0 {
+		d.status.InvalidClasses = d.invalidClasses
+	}
+	if len(d.devices) > 0 {
+		d.status.Devices = d.devices
+	}
+}
+
+func isClaimed(deviceMap map[string][]DiskLocation, diskName string) bool {
+	for _, deviceArray := range deviceMap {
+		for _, deviceLocation := range deviceArray {
+			if deviceLocation.diskName == diskName {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSkipReasons(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeSysfs(t)()
+	writeSysfsAttribute(t, "vdf", "queue/nr_requests", "32")
+
+	oldDiskByIDPath, oldProcSwapsPath := diskByIDPath, procSwapsPath
+	diskByIDPath = filepath.Join(tmpDir, "by-id", "*")
+	procSwapsPath = filepath.Join(tmpDir, "swaps")
+	defer func() { diskByIDPath, procSwapsPath = oldDiskByIDPath, oldProcSwapsPath }()
+
+	files := map[string]string{
+		"swaps":     "Filename Type Size Used Priority\n/dev/vdb partition 2097148 0 -2\n",
+		"allowlist": "vdc\nvdd\n",
+		"config": `
+foo:
+  disks: ["vdc", "vdd", "vde"]
+bar:
+  disks: ["vdf"]
+  minQueueDepth: 64
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing %s %v", name, err)
+		}
+	}
+
+	d := NewDiskMaker(filepath.Join(tmpDir, "config"), filepath.Join(tmpDir, "local-storage"))
+	d.AllowlistPath = filepath.Join(tmpDir, "allowlist")
+	d.runner = &fakeRunner{output: strings.Replace(getData(), `NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT=""`,
+		`NAME="vdd" MAJ:MIN="252:48" TYPE="disk" SIZE="1099511627776" MOUNTPOINT="" FSTYPE="ext4"`, 1)}
+	d.reconcile()
+
+	status := d.Status()
+	if len(status.Claimed["foo"]) != 1 || status.Claimed["foo"][0] != "vdc" {
+		t.Errorf("expected vdc to be claimed for foo, got %v", status.Claimed)
+	}
+	expectedReasons := map[string]string{
+		"sda":  skipNoMatch,
+		"sda1": skipMounted,
+		"vda":  skipNoMatch,
+		"vdb":  skipSwap,
+		"vdd":  skipFormatted,
+		"vde":  skipNotAllowlisted,
+		"vdf":  skipExcluded,
+	}
+	for device, expected := range expectedReasons {
+		if reason := status.SkipReasons[device]; !strings.HasPrefix(reason, expected) {
+			t.Errorf("expected skip reason %q for %s, got %q", expected, device, reason)
+		}
+	}
+	if reason, ok := status.SkipReasons["vdc"]; ok {
+		t.Errorf("expected no skip reason for claimed vdc, got %q", reason)
+	}
+}
+
+func TestOnReconcile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc, vde]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	// a conflicting symlink makes claiming vdc fail
+	symlinkLocation := filepath.Join(tmpDir, "local-storage")
+	if err := os.MkdirAll(filepath.Join(symlinkLocation, "foo"), 0755); err != nil {
+		t.Fatalf("error creating class dir %v", err)
+	}
+	if err := os.Symlink("/dev/elsewhere", filepath.Join(symlinkLocation, "foo", "vdc")); err != nil {
+		t.Fatalf("error creating symlink %v", err)
+	}
+
+	results := []ReconcileResult{}
+	d := NewDiskMaker(configFile, symlinkLocation)
+	d.runner = &fakeRunner{output: strings.Replace(getData(), `NAME="vde" MAJ:MIN="252:64" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""`,
+		`NAME="vde" MAJ:MIN="252:64" TYPE="disk" SIZE="10737418240" MOUNTPOINT="/data"`, 1)}
+	d.ProtectSwap = false
+	d.OnReconcile = func(result ReconcileResult) {
+		results = append(results, result)
+	}
+	d.reconcile()
+
+	if len(results) != 1 {
+		t.Fatalf("expected a single result, got %v", results)
+	}
+	result := results[0]
+	if !equalStrings(result.Claimed["foo"], []string{"vdb"}) {
+		t.Errorf("expected vdb to be claimed, got %v", result.Claimed)
+	}
+	if !strings.HasPrefix(result.SkipReasons["vdc"], skipFailed) || !strings.HasPrefix(result.SkipReasons["vde"], skipMounted) {
+		t.Errorf("expected vdc to fail and vde to be skipped as mounted, got %v", result.SkipReasons)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "error symlinking device vdc") {
+		t.Errorf("expected a symlink error for vdc, got %v", result.Errors)
+	}
+
+	// the callback also runs when configuration cannot be loaded
+	os.Remove(configFile)
+	d.reconcile()
+	if len(results) != 2 || len(results[1].Errors) != 1 {
+		t.Errorf("expected a result with the configuration error, got %v", results)
+	}
+}
+
+func TestClaimedBytes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc", "virtio-vdd": "vdd"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdd]\nbar:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	// vdb has 10Gi and vdd 1Ti
+	if value := gaugeValue(t, claimedBytes, "foo"); value != 10*(1<<30)+(1<<40) {
+		t.Errorf("expected foo to claim %d bytes, got %v", 10*(1<<30)+(1<<40), value)
+	}
+	if value := gaugeValue(t, claimedBytes, "bar"); value != 10*(1<<30) {
+		t.Errorf("expected bar to claim %d bytes, got %v", 10*(1<<30), value)
+	}
+}
+
+func TestStatusDevices(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: `
+NAME="sda" MAJ:MIN="8:0" TYPE="disk" SIZE="4000787030016" MOUNTPOINT="" FSTYPE="" MODEL="ST4000NM0035   " TRAN="sata" ROTA="1"
+NAME="sda1" MAJ:MIN="8:1" TYPE="part" SIZE="4000785964544" MOUNTPOINT="/data" PKNAME="sda" FSTYPE="xfs" MODEL="" TRAN="" ROTA="1"
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT="" FSTYPE="" MODEL="" TRAN="" ROTA="0"
+NAME="nvme0n1" MAJ:MIN="259:0" TYPE="disk" SIZE="960197124096" MOUNTPOINT="" FSTYPE="ext4" MODEL="Dell Express Flash" TRAN="nvme" ROTA="0"`}
+	d.ProtectSwap = false
+	d.reconcile()
+
+	expected := map[string]Device{
+		"sda":     {Type: "disk", Size: 4000787030016, Model: "ST4000NM0035", Transport: "sata", Rotational: true},
+		"sda1":    {Type: "part", Size: 4000785964544, Rotational: true, FSType: "xfs"},
+		"vdb":     {Type: "disk", Size: 10737418240},
+		"nvme0n1": {Type: "disk", Size: 960197124096, Model: "Dell Express Flash", Transport: "nvme", FSType: "ext4"},
+	}
+	devices := d.Status().Devices
+	if len(devices) != len(expected) {
+		t.Errorf("expected %d devices, got %v", len(expected), devices)
+	}
+	for name, device := range expected {
+		if devices[name] != device {
+			t.Errorf("expected %s to be %+v, got %+v", name, device, devices[name])
+		}
+	}
+	if claimed := d.Status().Claimed["foo"]; !equalStrings(claimed, []string{"vdb"}) {
+		t.Errorf("expected vdb to be claimed, got %v", claimed)
+	}
+}
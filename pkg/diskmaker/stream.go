@@ -0,0 +1,68 @@
+package diskmaker
+
+import (
+	"time"
+)
+
+// Types of ReconcileEvent
+const (
+	// ReconcileEventClaimed is published for devices claimed by a reconcile
+	ReconcileEventClaimed = "claimed"
+	// ReconcileEventReleased is published for devices no longer claimed
+	ReconcileEventReleased = "released"
+	// ReconcileEventMissing is published for claimed devices that disappeared
+	ReconcileEventMissing = "missing"
+	// ReconcileEventError is published for errors encountered by a reconcile
+	ReconcileEventError = "error"
+)
+
+// reconcileEventBuffer is the number of events Events buffers for a slow consumer
+var reconcileEventBuffer = 100
+
+// ReconcileEvent is something that happened during a reconcile, see DiskMaker.Events
+type ReconcileEvent struct {
+	Type string
+	Time time.Time
+	// StorageClass and Device are the device the event is about, unset for errors
+	StorageClass string
+	Device       string
+	// Error is the error of events of type ReconcileEventError
+	Error error
+}
+
+// Events returns a channel on which events are published as reconciles encounter
+// them, complementing Status. The channel is shared by all callers and never closed.
+// Events are only published once Events was called. If the consumer falls behind,
+// the oldest buffered events are dropped to make room for new ones.
+func (d *DiskMaker) Events() <-chan ReconcileEvent {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.events == nil {
+		d.events = make(chan ReconcileEvent, reconcileEventBuffer)
+	}
+	return d.events
+}
+
+// publishEvent publishes an event to the channel returned by Events, if any
+func (d *DiskMaker) publishEvent(event ReconcileEvent) {
+	d.lock.Lock()
+	events := d.events
+	d.lock.Unlock()
+	if events == nil {
+		return
+	}
+	event.Time = time.Now()
+	for {
+		select {
+		case events <- event:
+			return
+		default:
+		}
+		// the buffer is full, drop the oldest event unless the consumer just took it
+		select {
+		case <-events:
+			reconcileEventsDropped.Inc()
+		default:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// receiveEvents returns the events buffered in events
+func receiveEvents(events <-chan ReconcileEvent) []ReconcileEvent {
+	received := []ReconcileEvent{}
+	for {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	d.ProtectSwap = false
+	events := d.Events()
+	if d.Events() != events {
+		t.Errorf("expected all callers to get the same channel")
+	}
+
+	d.reconcile()
+	received := receiveEvents(events)
+	if len(received) != 2 {
+		t.Fatalf("expected two events, got %v", received)
+	}
+	for _, event := range received {
+		if event.Type != ReconcileEventClaimed || event.StorageClass != "foo" || event.Time.IsZero() {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+
+	// vdc disappears and vdb is no longer configured
+	runner.output = `
+NAME="vdb" MAJ:MIN="252:16" TYPE="disk" SIZE="10737418240" MOUNTPOINT=""`
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	types := map[string]string{}
+	for _, event := range receiveEvents(events) {
+		types[event.Device] += event.Type + " "
+	}
+	if types["vdc"] != "missing released " || types["vdb"] != "released " {
+		t.Errorf("expected vdc to be missing and both devices to be released, got %v", types)
+	}
+
+	// errors have no device
+	if err := ioutil.WriteFile(configFile, []byte("foo: ["), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	d.reconcile()
+	received = receiveEvents(events)
+	if len(received) != 1 || received[0].Type != ReconcileEventError || received[0].Error == nil {
+		t.Errorf("expected an error event, got %v", received)
+	}
+}
+
+func TestEventsDropOldest(t *testing.T) {
+	oldBuffer := reconcileEventBuffer
+	reconcileEventBuffer = 2
+	defer func() { reconcileEventBuffer = oldBuffer }()
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+
+	// nothing is published before Events is called
+	d.publishEvent(ReconcileEvent{Type: ReconcileEventClaimed, Device: "vda"})
+	events := d.Events()
+	dropped := metricValue(t, reconcileEventsDropped).GetCounter().GetValue()
+	for _, device := range []string{"vdb", "vdc", "vdd"} {
+		d.publishEvent(ReconcileEvent{Type: ReconcileEventClaimed, Device: device})
+	}
+	received := receiveEvents(events)
+	if len(received) != 2 || received[0].Device != "vdc" || received[1].Device != "vdd" {
+		t.Errorf("expected the oldest event to be dropped, got %v", received)
+	}
+	if value := metricValue(t, reconcileEventsDropped).GetCounter().GetValue(); value != dropped+1 {
+		t.Errorf("expected one dropped event to be counted, got %v", value-dropped)
+	}
+}
@@ -0,0 +1,61 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var procSwapsPath = "/proc/swaps"
+
+// findSwapDevices returns names of block devices (such as sda2) that are active swap
+// devices according to /proc/swaps. Swap files are ignored.
+func findSwapDevices() (sets.String, error) {
+	swapDevices := sets.NewString()
+	content, err := ioutil.ReadFile(procSwapsPath)
+	if err != nil {
+		return swapDevices, fmt.Errorf("failed to read %s with %v", procSwapsPath, err)
+	}
+	lines := strings.Split(string(content), "\n")
+	// first line is the header
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "partition" {
+			continue
+		}
+		swapDevices.Insert(filepath.Base(fields[0]))
+	}
+	return swapDevices, nil
+}
+
+// excludeSwapDevices removes active swap devices from deviceSet, together with the disks
+// they are partitions of and the other partitions of those disks
+func (d *DiskMaker) excludeSwapDevices(deviceSet map[string]BlockDevice, allDevices []BlockDevice) error {
+	swapDevices, err := findSwapDevices()
+	if err != nil {
+		return err
+	}
+	numbers := sets.NewString()
+	for _, blockDevice := range allDevices {
+		if swapDevices.Has(blockDevice.Name) {
+			numbers.Insert(blockDevice.MajMin)
+		}
+	}
+	for deviceName := range backingDevices(numbers, allDevices) {
+		if _, found := deviceSet[deviceName]; found {
+			d.Log.Infof("ignoring device %s because it is used as swap", deviceName)
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipSwap, "")
+		}
+	}
+	return nil
+}
+
+// isPartitionOf returns true if part is a partition of diskName according to the parent
+// lsblk reported for it. Names alone don't tell, nvme0n10 is a disk next to nvme0n1.
+func isPartitionOf(part BlockDevice, diskName string) bool {
+	return part.DiskType == "part" && part.Parent != "" && part.Parent == diskName
+}
@@ -0,0 +1,70 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExcludeSwapDevices(t *testing.T) {
+	swapFile, err := ioutil.TempFile("", "swaps")
+	if err != nil {
+		t.Fatalf("error creating temp file %v", err)
+	}
+	defer os.Remove(swapFile.Name())
+	swapFile.WriteString(`Filename				Type		Size	Used	Priority
+/dev/vdb                                partition	2097148	0	-2
+/swapfile                               file		1048572	0	-3
+/dev/vdc2                               partition	2097148	0	-4
+`)
+	swapFile.Close()
+
+	oldProcSwapsPath := procSwapsPath
+	procSwapsPath = swapFile.Name()
+	defer func() { procSwapsPath = oldProcSwapsPath }()
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	content := getData() + `
+NAME="vdb1" MAJ:MIN="252:17" TYPE="part" SIZE="5G" MOUNTPOINT="" PKNAME="vdb"
+NAME="vdb10" MAJ:MIN="252:26" TYPE="part" SIZE="5G" MOUNTPOINT="" PKNAME="vdb"
+NAME="vdc1" MAJ:MIN="252:33" TYPE="part" SIZE="5G" MOUNTPOINT="" PKNAME="vdc"
+NAME="vdc2" MAJ:MIN="252:34" TYPE="part" SIZE="5G" MOUNTPOINT="" PKNAME="vdc"`
+	deviceSet, err := d.findNewDisks(content)
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	err = d.excludeSwapDevices(deviceSet, parseBlockDevices(content))
+	if err != nil {
+		t.Fatalf("error excluding swap devices %v", err)
+	}
+	// the disk of a swap partition and its other partitions are excluded too
+	for _, swapDevice := range []string{"vdb", "vdb1", "vdb10", "vdc", "vdc1", "vdc2"} {
+		if _, ok := deviceSet[swapDevice]; ok {
+			t.Errorf("expected swap device %s to be excluded", swapDevice)
+		}
+	}
+	if _, ok := deviceSet["vdd"]; !ok {
+		t.Errorf("expected vdd to remain a candidate")
+	}
+}
+
+func TestIsPartitionOf(t *testing.T) {
+	tests := []struct {
+		part     BlockDevice
+		disk     string
+		expected bool
+	}{
+		{BlockDevice{Name: "sda1", DiskType: "part", Parent: "sda"}, "sda", true},
+		{BlockDevice{Name: "nvme0n1p2", DiskType: "part", Parent: "nvme0n1"}, "nvme0n1", true},
+		{BlockDevice{Name: "sda", DiskType: "disk"}, "sda", false},
+		{BlockDevice{Name: "sdab", DiskType: "disk"}, "sda", false},
+		{BlockDevice{Name: "sdb1", DiskType: "part", Parent: "sdb"}, "sda", false},
+		{BlockDevice{Name: "nvme0n10", DiskType: "disk"}, "nvme0n1", false},
+		{BlockDevice{Name: "dm-0", DiskType: "lvm", Parent: "sda"}, "sda", false},
+	}
+	for _, test := range tests {
+		if isPartitionOf(test.part, test.disk) != test.expected {
+			t.Errorf("expected isPartitionOf(%s, %s) to be %v", test.part.Name, test.disk, test.expected)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package diskmaker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var sysBlockPath = "/sys/block"
+
+// readSysfsAttribute reads an attribute of a block device from sysfs,
+// such as queue/nr_requests for /sys/block/sda/queue/nr_requests.
+func readSysfsAttribute(diskName, attribute string) (string, error) {
+	attributePath := filepath.Join(sysBlockPath, diskName, attribute)
+	content, err := ioutil.ReadFile(attributePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s with %v", attributePath, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// readSysfsInt reads an integer attribute of a block device from sysfs
+func readSysfsInt(diskName, attribute string) (int, error) {
+	value, err := readSysfsAttribute(diskName, attribute)
+	if err != nil {
+		return 0, err
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for %s of %s: %v", value, attribute, diskName, err)
+	}
+	return intValue, nil
+}
+
+// sysfsDiskName returns the name of the disk a partition belongs to, whose sysfs
+// directory holds the attributes of the hardware, or the name of any other device
+func sysfsDiskName(blockDevice BlockDevice) string {
+	if blockDevice.DiskType == "part" && blockDevice.Parent != "" {
+		return blockDevice.Parent
+	}
+	return blockDevice.Name
+}
+
+// isRemovable returns whether a device is removable media, such as an SD card or USB
+// drive. Partitions are removable if their disk is.
+func isRemovable(blockDevice BlockDevice) bool {
+	removable, err := readSysfsAttribute(sysfsDiskName(blockDevice), "removable")
+	return err == nil && removable == "1"
+}
+
+// isZoned returns whether a device is a zoned (SMR) drive, either host-aware or
+// host-managed. Partitions are zoned if their disk is.
+func isZoned(blockDevice BlockDevice) bool {
+	zoned, err := readSysfsAttribute(sysfsDiskName(blockDevice), "queue/zoned")
+	return err == nil && zoned != "" && zoned != "none"
+}
+
+// sysfsStableID synthesizes a stable identifier of a device from its wwid, or serial
+// if it has none, for nodes without /dev/disk/by-id. It returns "" if neither exists.
+func sysfsStableID(diskName string) string {
+	for _, attribute := range []string{"wwid", "serial"} {
+		value, err := readSysfsAttribute(diskName, "device/"+attribute)
+		if err == nil && value != "" {
+			return unsafeLinkNameRegex.ReplaceAllString(attribute+"-"+value, "_")
+		}
+	}
+	return ""
+}
+
+// numaSubDir returns the symlink subdirectory of a device grouped by NUMA node.
+// Partitions are on the NUMA node of their disk.
+func (d *DiskMaker) numaSubDir(blockDevice BlockDevice) string {
+	diskName := sysfsDiskName(blockDevice)
+	node, err := readSysfsInt(diskName, "device/numa_node")
+	if err != nil {
+		d.throttledWarningf("numa-node/"+diskName, "unable to read NUMA node of %s: %v", diskName, err)
+		return "numa-none"
+	}
+	if node < 0 {
+		return "numa-none"
+	}
+	return fmt.Sprintf("numa%d", node)
+}
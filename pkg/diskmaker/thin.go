@@ -0,0 +1,69 @@
+package diskmaker
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isThinProvisioned returns whether a device is thin provisioned, so that its size may
+// exceed the storage backing it: a device-mapper thin volume, or a SCSI disk reporting
+// logical block provisioning. Support for discard says nothing, SSDs support it too, and
+// ATA disks are left out as libata reports logical block provisioning for TRIM.
+func (d *DiskMaker) isThinProvisioned(diskName string) bool {
+	if strings.HasPrefix(diskName, "dm-") {
+		table, err := d.run("dmsetup", "table", path.Join("/dev", diskName))
+		if err != nil {
+			d.throttledWarningf("thin/"+diskName, "unable to read device-mapper table of %s: %v", diskName, err)
+			return false
+		}
+		for _, line := range strings.Split(string(table), "\n") {
+			// start, length, target type and its arguments
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[2] == "thin" {
+				return true
+			}
+		}
+		return false
+	}
+	attributes, _ := filepath.Glob(filepath.Join(sysBlockPath, diskName, "device", "scsi_disk", "*", "thin_provisioning"))
+	for _, attribute := range attributes {
+		relPath, _ := filepath.Rel(filepath.Join(sysBlockPath, diskName), attribute)
+		if value, err := readSysfsAttribute(diskName, relPath); err != nil || value != "1" {
+			continue
+		}
+		vendor, _ := readSysfsAttribute(diskName, "device/vendor")
+		return vendor != "ATA"
+	}
+	return false
+}
+
+// checkThinDevices warns about matched devices that are thin provisioned, as claiming
+// many of them can overcommit their backing storage. With NodeSettings.RefuseThinDevices
+// they are dropped from deviceMap instead.
+func (d *DiskMaker) checkThinDevices(deviceMap map[string][]DiskLocation) {
+	thinDevices := 0
+	storageClasses := []string{}
+	for storageClass := range deviceMap {
+		storageClasses = append(storageClasses, storageClass)
+	}
+	sort.Strings(storageClasses)
+	for _, storageClass := range storageClasses {
+		for _, deviceLocation := range deviceMap[storageClass] {
+			diskName := deviceLocation.diskName
+			if !d.isThinProvisioned(diskName) {
+				continue
+			}
+			if d.settings.RefuseThinDevices {
+				d.Log.Infof("not symlinking device %s for storageclass %s, it is thin provisioned", diskName, storageClass)
+				deviceMap[storageClass] = removeLocation(deviceMap[storageClass], diskName)
+				d.skipDevice(diskName, skipThin, "")
+				continue
+			}
+			d.throttledWarningf("thin/"+diskName, "device %s claimed for storageclass %s is thin provisioned, its backing storage may be overcommitted", diskName, storageClass)
+			thinDevices++
+		}
+	}
+	thinDevicesClaimed.Set(float64(thinDevices))
+}
@@ -0,0 +1,52 @@
+package diskmaker
+
+import (
+	"testing"
+)
+
+func TestThinDevices(t *testing.T) {
+	defer fakeSysfs(t)()
+	// sdb is a thin LUN of a SAN, sdc a SATA SSD supporting discard
+	writeSysfsAttribute(t, "sdb", "device/scsi_disk/1:0:0:0/thin_provisioning", "1")
+	writeSysfsAttribute(t, "sdb", "device/vendor", "LIO-ORG")
+	writeSysfsAttribute(t, "sdc", "device/scsi_disk/2:0:0:0/thin_provisioning", "1")
+	writeSysfsAttribute(t, "sdc", "device/vendor", "ATA     ")
+	writeSysfsAttribute(t, "sdc", "queue/discard_max_bytes", "2147450880")
+	writeSysfsAttribute(t, "sdc", "queue/discard_granularity", "512")
+	writeSysfsAttribute(t, "sdd", "device/scsi_disk/3:0:0:0/thin_provisioning", "0")
+	newDeviceMap := func() map[string][]DiskLocation {
+		return map[string][]DiskLocation{"foo": {{diskName: "sdb"}, {diskName: "sdc"}, {diskName: "sdd"}, {diskName: "dm-0"}, {diskName: "dm-1"}}}
+	}
+	runner := &fakeRunner{outputs: map[string]string{
+		"dmsetup table /dev/dm-0": "0 20971520 thin 253:2 1\n",
+		"dmsetup table /dev/dm-1": "0 20971520 linear 8:16 2048\n",
+	}}
+
+	// claimed with a warning by default
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = runner
+	d.skipReasons = make(map[string]string)
+	deviceMap := newDeviceMap()
+	d.checkThinDevices(deviceMap)
+	if len(deviceMap["foo"]) != 5 {
+		t.Errorf("expected thin devices to be claimed, got %v", deviceMap)
+	}
+	if value := metricValue(t, thinDevicesClaimed).GetGauge().GetValue(); value != 2 {
+		t.Errorf("expected two thin devices to be reported, got %v", value)
+	}
+
+	d.settings = NodeSettings{RefuseThinDevices: true}
+	deviceMap = newDeviceMap()
+	d.checkThinDevices(deviceMap)
+	if hasDevice(deviceMap["foo"], "sdb") || hasDevice(deviceMap["foo"], "dm-0") || len(deviceMap["foo"]) != 3 {
+		t.Errorf("expected thin sdb and dm-0 to be refused, got %v", deviceMap)
+	}
+	for _, diskName := range []string{"sdb", "dm-0"} {
+		if reason := d.skipReasons[diskName]; reason != skipThin {
+			t.Errorf("expected %s to be skipped as thin, got %q", diskName, reason)
+		}
+	}
+	if value := metricValue(t, thinDevicesClaimed).GetGauge().GetValue(); value != 0 {
+		t.Errorf("expected no thin device to be reported, got %v", value)
+	}
+}
@@ -0,0 +1,52 @@
+package diskmaker
+
+import (
+	"sync"
+	"time"
+)
+
+// logThrottleInterval is the minimum time between two logs of the same recurring error
+var logThrottleInterval = time.Minute
+
+// logThrottle limits how often a message with the same key is logged, so that a
+// permanently missing disk does not log an error on every reconcile.
+type logThrottle struct {
+	lock       sync.Mutex
+	interval   time.Duration
+	lastLogged map[string]time.Time
+	now        func() time.Time
+}
+
+func newLogThrottle(interval time.Duration) *logThrottle {
+	return &logThrottle{
+		interval:   interval,
+		lastLogged: make(map[string]time.Time),
+		now:        time.Now,
+	}
+}
+
+// allow returns true if a message with given key may be logged now
+func (l *logThrottle) allow(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	if last, ok := l.lastLogged[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	l.lastLogged[key] = now
+	return true
+}
+
+// throttledErrorf logs an error at most once per logThrottleInterval for given key
+func (d *DiskMaker) throttledErrorf(key, format string, args ...interface{}) {
+	if d.logThrottle.allow(key) {
+		d.Log.Errorf(format, args...)
+	}
+}
+
+// throttledWarningf logs a warning at most once per logThrottleInterval for given key
+func (d *DiskMaker) throttledWarningf(key, format string, args ...interface{}) {
+	if d.logThrottle.allow(key) {
+		d.Log.Warningf(format, args...)
+	}
+}
@@ -0,0 +1,69 @@
+package diskmaker
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestThrottledMissingDiskError(t *testing.T) {
+	var out bytes.Buffer
+	logrus.SetOutput(&out)
+	defer logrus.SetOutput(os.Stderr)
+
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	now := time.Now()
+	d.logThrottle.now = func() time.Time { return now }
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	diskConfig := DiskConfig{
+		"foo": &Disks{
+			DeviceNumbers: []string{"9:9"},
+		},
+	}
+	message := "unable to find device with number 9:9"
+
+	for i := 0; i < 5; i++ {
+		d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+		now = now.Add(checkDuration)
+	}
+	if count := strings.Count(out.String(), message); count != 1 {
+		t.Errorf("expected message to be logged once within the window, got %d", count)
+	}
+
+	now = now.Add(logThrottleInterval)
+	d.findMatchingDisks(context.Background(), diskConfig, deviceSet, getDeiveIDs())
+	if count := strings.Count(out.String(), message); count != 2 {
+		t.Errorf("expected message to be logged again after the window, got %d", count)
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	d := NewDiskMaker("/tmp/missing-config", "/mnt/local-storage")
+	if component := d.Log.Data["component"]; component != "diskmaker" {
+		t.Errorf("expected component field to be diskmaker, got %v", component)
+	}
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	d.Log = logger.WithFields(logrus.Fields{"node": "node-1", "component": "diskmaker"})
+	d.reconcile()
+	d.Recorder.Eventf("Normal", "Test", "event of %s", "node-1")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected the configuration error and the event to be logged, got %q", out.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "component=diskmaker") || !strings.Contains(line, "node=node-1") {
+			t.Errorf("expected node and component fields in %q", line)
+		}
+	}
+}
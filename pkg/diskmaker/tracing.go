@@ -0,0 +1,39 @@
+package diskmaker
+
+import (
+	"context"
+)
+
+// Tracer starts tracing spans. It mirrors Start of an OpenTelemetry trace.Tracer without
+// options, so that one can be plugged in through a small adapter.
+type Tracer interface {
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer
+type Span interface {
+	// SetAttribute tags the span with a count, such as the number of devices matched
+	SetAttribute(key string, value int)
+	End()
+}
+
+// noopTracer is the default Tracer, which traces nothing
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value int) {}
+func (noopSpan) End()                               {}
+
+// countLocations returns the number of devices in deviceMap
+func countLocations(deviceMap map[string][]DiskLocation) int {
+	count := 0
+	for _, deviceArray := range deviceMap {
+		count += len(deviceArray)
+	}
+	return count
+}
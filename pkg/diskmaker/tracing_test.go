@@ -0,0 +1,90 @@
+package diskmaker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordingTracer keeps finished spans in memory, like an in-memory span exporter
+type recordingTracer struct {
+	lock  sync.Mutex
+	ended []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer     *recordingTracer
+	name       string
+	parent     *recordedSpan
+	attributes map[string]int
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{tracer: r, name: spanName, parent: parent, attributes: make(map[string]int)}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttribute(key string, value int) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.tracer.ended = append(s.tracer.ended, s)
+}
+
+func TestReconcileSpans(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb", "virtio-vdc": "vdc"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb, vdc]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+	tracer := &recordingTracer{}
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.runner = &fakeRunner{output: getData()}
+	d.ProtectSwap = false
+	d.Tracer = tracer
+	d.reconcile()
+
+	if len(tracer.ended) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(tracer.ended))
+	}
+	root := tracer.ended[3]
+	if root.name != "reconcile" || root.parent != nil {
+		t.Errorf("expected root span reconcile to end last, got %s", root.name)
+	}
+	expected := []struct {
+		name       string
+		attributes map[string]int
+	}{
+		{"discovery", map[string]int{"devices": 10, "candidates": 7}},
+		{"matching", map[string]int{"matched": 2}},
+		{"symlinking", map[string]int{"linked": 2}},
+	}
+	for i, e := range expected {
+		span := tracer.ended[i]
+		if span.name != e.name || span.parent != root {
+			t.Errorf("expected span %s as child of reconcile, got %s", e.name, span.name)
+		}
+		for key, value := range e.attributes {
+			if span.attributes[key] != value {
+				t.Errorf("expected %s of span %s to be %d, got %d", key, span.name, value, span.attributes[key])
+			}
+		}
+	}
+	if root.attributes["skipped"] != 8 || root.attributes["errors"] != 0 {
+		t.Errorf("unexpected attributes of reconcile span %v", root.attributes)
+	}
+}
@@ -0,0 +1,44 @@
+package diskmaker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// udevProperties returns the udev properties of a device as reported by udevadm
+func (d *DiskMaker) udevProperties(diskName string) (map[string]string, error) {
+	out, err := d.run("udevadm", "info", "--query=property", "--name="+path.Join("/dev", diskName))
+	if err != nil {
+		return nil, err
+	}
+	properties := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		properties[parts[0]] = parts[1]
+	}
+	return properties, nil
+}
+
+// excludeUdevTagged removes devices whose udev property ExcludeUdevProperty equals
+// ExcludeUdevValue from deviceSet. Devices whose properties can't be read are removed
+// too, as they may carry the tag.
+func (d *DiskMaker) excludeUdevTagged(deviceSet map[string]BlockDevice) {
+	for deviceName := range deviceSet {
+		properties, err := d.udevProperties(deviceName)
+		if err != nil {
+			d.throttledErrorf("udevadm/"+deviceName, "not symlinking device %s, unable to read its udev properties: %v", deviceName, err)
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipUdevExcluded, fmt.Sprintf("udevadm failed: %v", err))
+			continue
+		}
+		if value, ok := properties[d.ExcludeUdevProperty]; ok && value == d.ExcludeUdevValue {
+			d.Log.Infof("ignoring device %s because its udev property %s is %s", deviceName, d.ExcludeUdevProperty, value)
+			delete(deviceSet, deviceName)
+			d.skipDevice(deviceName, skipUdevExcluded, fmt.Sprintf("%s=%s", d.ExcludeUdevProperty, value))
+		}
+	}
+}
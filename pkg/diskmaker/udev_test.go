@@ -0,0 +1,50 @@
+package diskmaker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExcludeUdevTagged(t *testing.T) {
+	runner := &fakeRunner{
+		output: "DEVTYPE=disk\nID_BUS=ata\n",
+		outputs: map[string]string{
+			"udevadm info --query=property --name=/dev/vdb": "DEVNAME=/dev/vdb\nDEVTYPE=disk\nLSO_RESERVED=1\n",
+			"udevadm info --query=property --name=/dev/vdc": "DEVNAME=/dev/vdc\nDEVTYPE=disk\nLSO_RESERVED=0\n",
+		},
+		errors: map[string]error{
+			"udevadm info --query=property --name=/dev/vdd": fmt.Errorf("device node not found"),
+		},
+	}
+	d := NewDiskMaker("/tmp/foo", "/mnt/local-storage")
+	d.runner = runner
+	d.ExcludeUdevProperty = "LSO_RESERVED"
+	d.ExcludeUdevValue = "1"
+	deviceSet, err := d.findNewDisks(getData())
+	if err != nil {
+		t.Fatalf("error getting data %v", err)
+	}
+	d.excludeUdevTagged(deviceSet)
+
+	if runner.count("udevadm") != 7 {
+		t.Errorf("expected udevadm to be run for every device, got %v", runner.calls)
+	}
+	if reason := d.skipReasons["vdb"]; reason != skipUdevExcluded+": LSO_RESERVED=1" {
+		t.Errorf("expected tagged vdb to be excluded, got %q", reason)
+	}
+	// vdd may be tagged, its properties are unknown
+	if reason := d.skipReasons["vdd"]; !strings.HasPrefix(reason, skipUdevExcluded) {
+		t.Errorf("expected vdd to be excluded, got %q", reason)
+	}
+	for _, deviceName := range []string{"vdb", "vdd"} {
+		if _, ok := deviceSet[deviceName]; ok {
+			t.Errorf("expected %s to be excluded", deviceName)
+		}
+	}
+	for _, deviceName := range []string{"vda", "vdc", "vde", "vdf"} {
+		if _, ok := deviceSet[deviceName]; !ok {
+			t.Errorf("expected %s to be kept", deviceName)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package diskmaker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// symlinkWatchMask are the inotify events watched in symlinkLocation and its directories
+const symlinkWatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_DELETE_SELF
+
+// symlinkWatcher reports symlinks removed from symlinkLocation, see DiskMaker.WatchSymlinks
+type symlinkWatcher struct {
+	d    *DiskMaker
+	file *os.File
+	// dirs maps inotify watch descriptors to the directories they watch
+	dirs map[int]string
+}
+
+// newSymlinkWatcher watches symlinkLocation and all directories in it
+func (d *DiskMaker) newSymlinkWatcher() (*symlinkWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("error creating inotify instance with %v", err)
+	}
+	// a non-blocking file is read through the runtime poller, so Close unblocks Read
+	w := &symlinkWatcher{d: d, file: os.NewFile(uintptr(fd), "inotify"), dirs: make(map[int]string)}
+	err = filepath.Walk(d.symlinkLocation, func(dirPath string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		return w.add(dirPath)
+	})
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *symlinkWatcher) add(dirPath string) error {
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), dirPath, symlinkWatchMask)
+	if err != nil {
+		return fmt.Errorf("error watching %s with %v", dirPath, err)
+	}
+	w.dirs[wd] = dirPath
+	return nil
+}
+
+// run triggers a reconcile whenever the symlink of a claimed device is removed, until
+// the watcher is closed
+func (w *symlinkWatcher) run() {
+	buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			w.d.Log.Debugf("stopped watching %s: %v", w.d.symlinkLocation, err)
+			return
+		}
+		removed := false
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameBytes := buffer[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				// events were lost, some may have been removals
+				removed = true
+				continue
+			}
+			dirPath, found := w.dirs[int(event.Wd)]
+			if !found {
+				continue
+			}
+			name := string(nameBytes[:clen(nameBytes)])
+			switch {
+			case event.Mask&unix.IN_IGNORED != 0:
+				delete(w.dirs, int(event.Wd))
+			case event.Mask&unix.IN_CREATE != 0 && event.Mask&unix.IN_ISDIR != 0:
+				// directories of new storageclasses or subdirectories
+				err := w.add(path.Join(dirPath, name))
+				if err != nil {
+					w.d.Log.Errorf("%v", err)
+				}
+			case event.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+				removed = removed || w.d.claimedLinks().Has(path.Join(dirPath, name))
+			}
+		}
+		if removed {
+			w.d.Log.Infof("symlinks in %s were removed, triggering a reconcile", w.d.symlinkLocation)
+			w.d.Trigger()
+		}
+	}
+}
+
+func (w *symlinkWatcher) close() {
+	w.file.Close()
+}
+
+// clen returns the length of the NUL terminated name of an inotify event
+func clen(name []byte) int {
+	for i, b := range name {
+		if b == 0 {
+			return i
+		}
+	}
+	return len(name)
+}
+
+// claimedLinks returns the paths of the symlinks of devices claimed by the last reconcile
+func (d *DiskMaker) claimedLinks() sets.String {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	links := sets.NewString()
+	for storageClass, deviceArray := range d.claimed {
+		for _, deviceLocation := range deviceArray {
+			links.Insert(path.Join(d.symlinkLocation, storageClass, deviceLocation.symlinkName()))
+		}
+	}
+	return links
+}
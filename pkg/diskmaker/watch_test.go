@@ -0,0 +1,74 @@
+package diskmaker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchSymlinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "diskmaker")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer fakeDiskByID(t, tmpDir, map[string]string{"virtio-vdb": "vdb"})()
+	configFile := filepath.Join(tmpDir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("foo:\n  disks: [vdb]\n"), 0644); err != nil {
+		t.Fatalf("error writing config %v", err)
+	}
+
+	oldCheckDuration := checkDuration
+	checkDuration = time.Hour
+	defer func() { checkDuration = oldCheckDuration }()
+
+	d := NewDiskMaker(configFile, filepath.Join(tmpDir, "local-storage"))
+	d.TriggerDebounce = 50 * time.Millisecond
+	d.WatchSymlinks = true
+	d.ProtectSwap = false
+	runner := &fakeRunner{output: getData()}
+	d.runner = runner
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.Run(stop)
+		close(done)
+	}()
+	// Run must have returned before the fake devices are removed
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	linkPath := filepath.Join(tmpDir, "local-storage", "foo", "vdb")
+	waitForClaim := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(d.Status().Claimed["foo"]) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected vdb to be claimed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForClaim()
+	reconciles := runner.count("lsblk")
+
+	// the link is removed behind the back of the diskmaker and re-created before the
+	// next interval
+	if err := os.Remove(linkPath); err != nil {
+		t.Fatalf("error removing symlink %v", err)
+	}
+	waitForCalls(t, runner, "lsblk", reconciles+1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Lstat(linkPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected symlink %s to be re-created", linkPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,5 +1,41 @@
 package version
 
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// Version, Commit and BuildDate are set at build time with
+// -ldflags "-X github.com/openshift/local-storage-operator/version.Commit=..."
 var (
-	Version = "0.0.1"
+	Version   = "0.0.1"
+	Commit    = "unknown"
+	BuildDate = "unknown"
 )
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information of the running binary
+func Get() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Handler serves the build information as JSON
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+}
@@ -0,0 +1,32 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	oldVersion, oldCommit, oldBuildDate := Version, Commit, BuildDate
+	defer func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldBuildDate }()
+	// as injected by -ldflags -X
+	Version, Commit, BuildDate = "4.2.0", "3fded79", "2019-06-01T10:00:00Z"
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + "/version")
+	if err != nil {
+		t.Fatalf("error getting version %v", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected json, got %s", contentType)
+	}
+	var info BuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("error decoding version %v", err)
+	}
+	if info.Version != "4.2.0" || info.Commit != "3fded79" || info.BuildDate != "2019-06-01T10:00:00Z" || info.GoVersion == "" {
+		t.Errorf("expected injected build info, got %+v", info)
+	}
+}